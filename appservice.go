@@ -0,0 +1,243 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+// Being a D-Bus activatable application, for -app-service.
+//
+// Desktop environments (and the desktop portal) can open things in an
+// application by calling the standard org.freedesktop.Application
+// interface on the session bus, instead of running a command. With
+// -app-service APPID we claim the bus name APPID and implement that
+// interface on its object path (APPID with '.' turned into '/'),
+// passing the URIs from Open() on to the Firefox that the options
+// select. Activate() opens a new window. Nothing that starts with '-'
+// is taken as a URI, since Firefox would take it as an option and
+// anything on the bus can call us. We find Firefox when the first
+// request comes in and look for it again whenever sending to it
+// fails, so it can come and go while we're running; each look gives
+// up after -timeout, or appFindTimeout if there's no -timeout.
+//
+// Requests are queued and sent to Firefox one at a time, in the order
+// they came in, so callers don't wait for Firefox (or each other). If
+// someone else holds Firefox's lock for longer than -lock-wait, we
+// keep trying to send the same request until we get the lock instead
+// of dropping it; other failures are only logged, since the caller
+// has long since gone on with its life.
+//
+// To have things routed through us, give a .desktop file named
+// APPID.desktop 'DBusActivatable=true' and a D-Bus service file that
+// runs 'ffox-remote -app-service APPID'; see the Desktop Entry
+// specification.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+)
+
+// appIface is the interface we implement.
+const appIface = "org.freedesktop.Application"
+
+// appIntrospect describes appIface for introspection.
+var appIntrospect = introspect.Interface{
+	Name: appIface,
+	Methods: []introspect.Method{
+		{Name: "Activate", Args: []introspect.Arg{
+			{Name: "platform_data", Type: "a{sv}", Direction: "in"}}},
+		{Name: "Open", Args: []introspect.Arg{
+			{Name: "uris", Type: "as", Direction: "in"},
+			{Name: "platform_data", Type: "a{sv}", Direction: "in"}}},
+		{Name: "ActivateAction", Args: []introspect.Arg{
+			{Name: "action_name", Type: "s", Direction: "in"},
+			{Name: "parameter", Type: "av", Direction: "in"},
+			{Name: "platform_data", Type: "a{sv}", Direction: "in"}}},
+	},
+}
+
+// appQueueSize is how many requests we'll hold before we start
+// refusing them.
+const appQueueSize = 64
+
+// appFindTimeout is how long we look for Firefox for a request if
+// there's no -timeout.
+const appFindTimeout = 10 * time.Second
+
+// appLockRetry is how long we wait before trying again to send a
+// request after someone else has held the lock too long.
+const appLockRetry = time.Second
+
+// appService is what we export on the bus. -socket-activated uses it
+// too, for its queue (see sockets.go).
+type appService struct {
+	t       transport
+	o       *options
+	recfile string
+	cwd     string
+	queue   chan []string
+	pending atomic.Int32 // queued or being sent
+	found   bool         // whether t has found Firefox; only run uses it
+}
+
+func newAppService(t transport, o *options, recfile, cwd string) *appService {
+	return &appService{t: t, o: o, recfile: recfile, cwd: cwd,
+		queue: make(chan []string, appQueueSize)}
+}
+
+// offer queues args to be sent to Firefox, unless the queue is full.
+func (s *appService) offer(args []string) error {
+	s.pending.Add(1)
+	select {
+	case s.queue <- args:
+		return nil
+	default:
+		s.pending.Add(-1)
+		return fmt.Errorf("%d requests are already waiting for Firefox", appQueueSize)
+	}
+}
+
+// enqueue is offer for D-Bus.
+func (s *appService) enqueue(args []string) *dbus.Error {
+	if err := s.offer(args); err != nil {
+		return dbus.MakeFailedError(err)
+	}
+	return nil
+}
+
+// idle reports whether there's nothing queued or being sent.
+func (s *appService) idle() bool {
+	return s.pending.Load() == 0
+}
+
+// run sends queued requests, in order, until the queue is closed.
+func (s *appService) run() {
+	for args := range s.queue {
+		for {
+			err := s.send(args)
+			var le lockedError
+			if err == nil || !errors.As(err, &le) {
+				break
+			}
+			time.Sleep(appLockRetry)
+		}
+		s.pending.Add(-1)
+	}
+}
+
+// find finds Firefox, if we haven't already.
+func (s *appService) find() error {
+	if s.found {
+		return nil
+	}
+	d := s.o.timeout
+	if d <= 0 {
+		d = appFindTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	if err := s.t.find(ctx); err != nil {
+		return err
+	}
+	s.found = true
+	return nil
+}
+
+// send passes args on to Firefox, finding it first if we have to.
+// If sending to a Firefox we found for an earlier request fails for
+// any reason but the lock, it may have gone away or been restarted,
+// so we look for it again and have another try.
+func (s *appService) send(args []string) error {
+	try := func() (response, error) {
+		if err := s.find(); err != nil {
+			return response{}, err
+		}
+		return sendRecorded(context.Background(), s.t, s.o, s.recfile, s.cwd, args)
+	}
+	earlier := s.found
+	resp, err := try()
+	var le lockedError
+	if err != nil && earlier && !errors.As(err, &le) {
+		s.found = false
+		resp, err = try()
+	}
+	if err == nil && resp.failed() {
+		err = fmt.Errorf("Firefox says: %s", resp)
+	}
+	if err != nil {
+		slog.Warn("", "err", err)
+		return err
+	}
+	if s.o.verbose {
+		slog.Info("sent", "args", strings.Join(args[1:], " "), "response", resp.raw)
+	}
+	return nil
+}
+
+// Activate opens a new Firefox window.
+func (s *appService) Activate(platformData map[string]dbus.Variant) *dbus.Error {
+	return s.enqueue([]string{"firefox", "-new-window"})
+}
+
+// Open opens the URIs in Firefox.
+func (s *appService) Open(uris []string, platformData map[string]dbus.Variant) *dbus.Error {
+	if len(uris) == 0 {
+		return s.Activate(platformData)
+	}
+	if err := checkURLs(uris); err != nil {
+		return dbus.MakeFailedError(err)
+	}
+	return s.enqueue(append([]string{"firefox"}, uris...))
+}
+
+// ActivateAction runs an application action. We don't have any.
+func (s *appService) ActivateAction(action string, params []dbus.Variant, platformData map[string]dbus.Variant) *dbus.Error {
+	return dbus.MakeFailedError(fmt.Errorf("no action %q", action))
+}
+
+// appObjectPath returns the object path for an application ID.
+func appObjectPath(appid string) dbus.ObjectPath {
+	return dbus.ObjectPath("/" + strings.Replace(strings.Replace(appid, ".", "/", -1), "-", "_", -1))
+}
+
+// runAppService claims appid on the session bus and serves
+// org.freedesktop.Application requests until we're killed.
+func runAppService(t transport, o *options, recfile, cwd, appid string) error {
+	conn, err := dbus.SessionBus()
+	if err != nil {
+		return fmt.Errorf("D-Bus session bus: %s", err)
+	}
+	path := appObjectPath(appid)
+	if !path.IsValid() {
+		return fmt.Errorf("%q isn't a valid application ID", appid)
+	}
+	s := newAppService(t, o, recfile, cwd)
+	go s.run()
+	if err = conn.Export(s, path, appIface); err != nil {
+		return err
+	}
+	node := &introspect.Node{
+		Name:       string(path),
+		Interfaces: []introspect.Interface{introspect.IntrospectData, appIntrospect},
+	}
+	if err = conn.Export(introspect.NewIntrospectable(node), path, "org.freedesktop.DBus.Introspectable"); err != nil {
+		return err
+	}
+	reply, err := conn.RequestName(appid, dbus.NameFlagDoNotQueue)
+	if err != nil {
+		return fmt.Errorf("requesting %s: %s", appid, err)
+	}
+	if reply != dbus.RequestNameReplyPrimaryOwner {
+		return errors.New(appid + " is already taken on the session bus")
+	}
+	if o.verbose {
+		slog.Info("serving", "name", appid, "path", path)
+	}
+	select {}
+}
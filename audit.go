@@ -0,0 +1,162 @@
+package main
+
+// The audit log, for -audit.
+//
+// Some places (shared lab machines, kiosks) need a reliable record of
+// everything that's been sent to the browser. With -audit FILE, we
+// refuse to send anything unless we can first append a record of what
+// we're about to send to FILE, and then we append another record with
+// Firefox's response. Each record is a JSON line that includes the
+// SHA-256 hash of the line before it, so editing or removing lines
+// (other than at the end) breaks the chain in a way that 'ffox-remote
+// audit FILE' will report. This is tamper-evident, not tamper-proof;
+// someone who can write the file can rewrite the whole thing. Put it
+// somewhere that people can only append to if you need more.
+//
+// We lock the file while we're using it so that concurrent
+// ffox-remotes don't interleave their records and break the chain.
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// An auditRecord is one line of the audit log. Event is "send" for
+// the record made before we send a command line and "result" for the
+// one made after.
+type auditRecord struct {
+	Prev     string    `json:"prev"`
+	Time     time.Time `json:"time"`
+	Event    string    `json:"event"`
+	UID      int       `json:"uid"`
+	PID      int       `json:"pid"`
+	PPID     int       `json:"ppid"`
+	Target   string    `json:"target,omitempty"`
+	Cwd      string    `json:"cwd,omitempty"`
+	Args     []string  `json:"args"`
+	Response string    `json:"response,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// An auditLog is an open audit log file.
+type auditLog struct {
+	fname string
+	f     *os.File
+	prev  string
+}
+
+// lineHash returns the hash of an audit log line (without its
+// newline), as it appears in the next line's prev.
+func lineHash(line []byte) string {
+	h := sha256.Sum256(line)
+	return hex.EncodeToString(h[:])
+}
+
+// openAudit opens (or creates) the audit log fname, locks it, and
+// finds the hash of its last line. We fail if we can't do all of
+// that, because then we couldn't write records.
+func openAudit(fname string) (*auditLog, error) {
+	f, err := os.OpenFile(fname, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err = lockFile(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("locking %s: %s", fname, err)
+	}
+	a := &auditLog{fname: fname, f: f}
+	// We only need the last line, but finding it backwards isn't
+	// worth the code for files of this size.
+	scan := bufio.NewScanner(f)
+	scan.Buffer(nil, 16*1024*1024)
+	for scan.Scan() {
+		a.prev = lineHash(scan.Bytes())
+	}
+	if err = scan.Err(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("reading %s: %s", fname, err)
+	}
+	return a, nil
+}
+
+// write appends a record to the audit log, making sure that it's
+// on disk before we go on.
+func (a *auditLog) write(rec auditRecord) error {
+	rec.Prev = a.prev
+	rec.Time = time.Now()
+	rec.UID, rec.PID, rec.PPID = os.Getuid(), os.Getpid(), os.Getppid()
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err = a.f.Write(append(b, '\n')); err != nil {
+		return fmt.Errorf("writing %s: %s", a.fname, err)
+	}
+	if err = a.f.Sync(); err != nil {
+		return fmt.Errorf("syncing %s: %s", a.fname, err)
+	}
+	a.prev = lineHash(b)
+	return nil
+}
+
+// close closes the audit log, which also unlocks it.
+func (a *auditLog) close() error {
+	return a.f.Close()
+}
+
+// errAuditBroken is returned by verifyAudit if the chain is broken.
+var errAuditBroken = errors.New("the audit log has been altered")
+
+// verifyAudit checks the chain of hashes in the audit log read from
+// r, reporting the first line where it's broken to w.
+func verifyAudit(r io.Reader, w io.Writer) error {
+	prev := ""
+	scan := bufio.NewScanner(r)
+	scan.Buffer(nil, 16*1024*1024)
+	ln := 0
+	for scan.Scan() {
+		ln++
+		var rec auditRecord
+		if err := json.Unmarshal(scan.Bytes(), &rec); err != nil {
+			fmt.Fprintf(w, "line %d: not an audit record: %s\n", ln, err)
+			return errAuditBroken
+		}
+		if rec.Prev != prev {
+			fmt.Fprintf(w, "line %d: chain broken; line %d was changed or removed\n", ln, ln-1)
+			return errAuditBroken
+		}
+		prev = lineHash(scan.Bytes())
+	}
+	if err := scan.Err(); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "%d records, chain intact\n", ln)
+	return nil
+}
+
+// auditFiles verifies all of the audit logs in files.
+func auditFiles(files []string) error {
+	if len(files) == 0 {
+		return errors.New("no files given")
+	}
+	for _, fn := range files {
+		f, err := os.Open(fn)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s: ", fn)
+		err = verifyAudit(f, os.Stdout)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %s", fn, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+// Measuring round trip latency, for 'ffox-remote bench'.
+//
+// We send the same command line N times and report percentiles for
+// the whole round trip and, where the transport tells us (currently
+// only X), for getting the lock and waiting for the response. By
+// default the command line opens about:blank, which is harmless but
+// does leave you with N new tabs; give your own arguments if you
+// have something better.
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+)
+
+// percentiles reports the distribution of durs under name.
+func percentiles(name string, durs []time.Duration) {
+	if len(durs) == 0 {
+		return
+	}
+	sort.Slice(durs, func(i, j int) bool { return durs[i] < durs[j] })
+	pct := func(p int) time.Duration {
+		return durs[(len(durs)-1)*p/100].Round(time.Microsecond)
+	}
+	fmt.Printf("%-9s min %v  p50 %v  p90 %v  p99 %v  max %v\n", name+":",
+		durs[0].Round(time.Microsecond), pct(50), pct(90), pct(99),
+		durs[len(durs)-1].Round(time.Microsecond))
+}
+
+// bench sends args n times and reports on how long it took.
+func bench(ctx context.Context, t transport, n int, cwd string, args []string) {
+	if n < 1 {
+		fatal("bench: -n must be at least 1")
+	}
+	if len(args) == 1 {
+		args = append(args, "about:blank")
+	}
+	var total, lock, resp []time.Duration
+	failed := 0
+	// Each send gets its own timings, so that we can pick out its
+	// phases. Any from -timings are put back afterward, so that it
+	// still reports on finding Firefox and so on.
+	outer := timings
+	for i := 0; i < n; i++ {
+		startTimings()
+		start := time.Now()
+		r, err := t.send(ctx, cwd, args)
+		total = append(total, time.Since(start))
+		if err != nil {
+			slog.Warn("", "err", err)
+			failed++
+		} else if !r.succeeded() {
+			failed++
+		}
+		if d, ok := timings.times["lock acquisition"]; ok {
+			lock = append(lock, d)
+		}
+		if d, ok := timings.times["response wait"]; ok {
+			resp = append(resp, d)
+		}
+	}
+	timings = outer
+
+	fmt.Printf("%d command lines, %d failed\n", n, failed)
+	percentiles("total", total)
+	percentiles("lock", lock)
+	percentiles("response", resp)
+}
@@ -0,0 +1,93 @@
+package main
+
+// Opening the links in a bookmarks file, for -bookmarks.
+//
+// Firefox (like every browser) exports bookmarks in the old Netscape
+// bookmarks format, which is HTML that looks like:
+//
+//	<DL><p>
+//	    <DT><H3>Folder name</H3>
+//	    <DL><p>
+//	        <DT><A HREF="https://example.org/" ...>Title</A>
+//	    </DL><p>
+//	</DL>
+//
+// This isn't necessarily well formed HTML (the <DT> and <p> are never
+// closed), so rather than parse it as HTML we just look at the tags
+// we care about in order. A <H3> names the folder that the next <DL>
+// starts, and </DL> ends the current folder.
+
+import (
+	"fmt"
+	"html"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// bookmarkTags matches the bookmark file tags that we care about.
+// The submatches are the tag name (with a / if it's a closing tag),
+// the HREF of an <A>, and the contents of an <H3>.
+var bookmarkTags = regexp.MustCompile(`(?is)<(/?DL)\b[^>]*>|<(A)\b[^>]*?\bHREF="([^"]*)"[^>]*>|<(H3)\b[^>]*>(.*?)</H3>`)
+
+// parseBookmarks returns all of the links in a bookmarks file, or
+// only the ones in folders named folder (including subfolders) if it
+// isn't "".
+func parseBookmarks(data string, folder string) []string {
+	var urls []string
+	var stack []string
+	pending := ""
+	inFolder := func() bool {
+		if folder == "" {
+			return true
+		}
+		for _, f := range stack {
+			if f == folder {
+				return true
+			}
+		}
+		return false
+	}
+	for _, m := range bookmarkTags.FindAllStringSubmatch(data, -1) {
+		switch {
+		case strings.EqualFold(m[1], "DL"):
+			stack = append(stack, pending)
+			pending = ""
+		case strings.EqualFold(m[1], "/DL"):
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		case m[2] != "":
+			if inFolder() && m[3] != "" {
+				urls = append(urls, html.UnescapeString(m[3]))
+			}
+		case m[4] != "":
+			pending = strings.TrimSpace(html.UnescapeString(m[5]))
+		}
+	}
+	return urls
+}
+
+// bookmarkURLs reads the bookmarks file named by spec, which is FILE
+// or FILE:FOLDER, and returns the links in it (or in FOLDER).
+func bookmarkURLs(spec string) ([]string, error) {
+	fname, folder := spec, ""
+	if i := strings.LastIndexByte(spec, ':'); i > 0 {
+		if _, e := os.Stat(spec); e != nil {
+			fname, folder = spec[:i], spec[i+1:]
+		}
+	}
+	b, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	urls := parseBookmarks(string(b), folder)
+	if len(urls) == 0 {
+		if folder != "" {
+			return nil, fmt.Errorf("%s: no bookmarks in folder %q", fname, folder)
+		}
+		return nil, fmt.Errorf("%s: no bookmarks found", fname)
+	}
+	return urls, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+// Cleaning up pasted links, for -clean.
+//
+// Links copied out of terminals and email come with things stuck to
+// them. Terminals that support OSC 8 hyperlinks wrap the link text
+// in escape sequences that carry the real URL ('ESC ] 8 ; ; URL ST
+// text ESC ] 8 ; ; ST', where ST is 'ESC \' or BEL), and may add
+// colour escapes too; email and chat put links in angle brackets,
+// parentheses, or quotes, and sentences put punctuation after them.
+// With -clean we take all of that off URL arguments, and with
+// -extract we turn OSC 8 hyperlinks into their URLs before looking
+// for URLs in the text.
+
+import (
+	"regexp"
+	"strings"
+)
+
+// osc8Re matches an OSC 8 hyperlink escape sequence; the URL is the
+// first submatch, and is empty for the sequence that ends the link.
+var osc8Re = regexp.MustCompile("\x1b\\]8;[^;\x07\x1b]*;([^\x07\x1b]*)(?:\x07|\x1b\\\\)")
+
+// csiRe matches other terminal escape sequences, such as colours.
+var csiRe = regexp.MustCompile("\x1b\\[[0-9;?]*[ -/]*[@-~]")
+
+// unOSC8 replaces OSC 8 hyperlinks in text with their URLs (set off
+// by spaces, so that they don't run into the link text) and removes
+// other terminal escapes.
+func unOSC8(text string) string {
+	text = osc8Re.ReplaceAllStringFunc(text, func(m string) string {
+		if u := osc8Re.FindStringSubmatch(m)[1]; u != "" {
+			return " " + u + " "
+		}
+		return ""
+	})
+	return csiRe.ReplaceAllString(text, "")
+}
+
+// wrappers are the pairs of things that links get wrapped in.
+var wrappers = []struct{ open, close string }{
+	{"<URL:", ">"}, {"<", ">"}, {"(", ")"}, {"[", "]"},
+	{`"`, `"`}, {"'", "'"}, {"“", "”"}, {"‘", "’"},
+	{"«", "»"},
+}
+
+// cleanURL cleans up one pasted URL. If it has an OSC 8 hyperlink in
+// it, the hyperlink's URL is what was meant.
+func cleanURL(u string) string {
+	if m := osc8Re.FindStringSubmatch(u); m != nil && m[1] != "" {
+		return m[1]
+	}
+	u = csiRe.ReplaceAllString(u, "")
+	for {
+		prev := u
+		u = trimURL(strings.TrimSpace(u))
+		for _, w := range wrappers {
+			switch {
+			case strings.HasPrefix(u, w.open) && strings.HasSuffix(u, w.close) && len(u) >= len(w.open)+len(w.close):
+				u = u[len(w.open) : len(u)-len(w.close)]
+			case strings.HasPrefix(u, w.open) && !strings.Contains(u[len(w.open):], w.close):
+				u = u[len(w.open):]
+			case strings.HasSuffix(u, w.close) && !strings.Contains(u[:len(u)-len(w.close)], w.open):
+				u = u[:len(u)-len(w.close)]
+			}
+		}
+		if u == prev {
+			return u
+		}
+	}
+}
+
+// cleanURLs is cleanURL for all of urls, dropping any that end up
+// empty.
+func cleanURLs(urls []string) []string {
+	var res []string
+	for _, u := range urls {
+		if u = cleanURL(u); u != "" {
+			res = append(res, u)
+		}
+	}
+	return res
+}
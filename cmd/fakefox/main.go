@@ -0,0 +1,173 @@
+// fakefox pretends to be a Firefox window for the purposes of the
+// X remote control protocol. It creates an (unmapped) window with the
+// _MOZILLA_* identification properties set, then waits for remote
+// control clients (such as ffox-remote) to send it commands, which
+// it decodes and prints before answering with a response.
+//
+// usage: fakefox [option ...]
+//
+// The options are:
+//
+//	-P PROFILE
+//	-U USER
+//	-G PROGRAM
+//	-version VERSION
+//		Set the profile, user, program, and protocol version
+//		that we advertise. The defaults are 'default', your
+//		login name, 'firefox', and '5.1'.
+//
+//	-response RESPONSE
+//		The response to send for every command. The default is
+//		what Firefox itself sends, '200 executed command'.
+//
+//	-delay DURATION
+//		Wait this long before responding, to simulate a slow
+//		Firefox.
+//
+//	-count N
+//		Exit after handling N commands (the default is to run
+//		forever).
+//
+//	-pref PREFIX
+//		Use PREFIX instead of _MOZILLA for the property names,
+//		like ffox-remote's -pref.
+//
+// fakefox prints every command line it receives, and also reports
+// the _MOZILLA_LOCK property being set and removed. Clients are
+// supposed to hold the lock while they send a command and wait for
+// the response, so we complain if a command arrives without it (this
+// is expected for 'ffox-remote -force').
+//
+// The point of fakefox is to allow end to end tests of ffox-remote
+// (and other remote control clients) under Xvfb, without needing a
+// real Firefox:
+//
+//	Xvfb :99 & DISPLAY=:99 fakefox -count 1 &
+//	DISPLAY=:99 ffox-remote -v https://example.com/
+package main
+
+// Author: Chris Siebenmann
+// https://github.com/siebenmann/ffox-remote
+// Copyright: GPL v3
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/user"
+	"time"
+
+	"github.com/BurntSushi/xgb/xproto"
+	"github.com/BurntSushi/xgbutil"
+	"github.com/BurntSushi/xgbutil/xevent"
+	"github.com/BurntSushi/xgbutil/xprop"
+	"github.com/BurntSushi/xgbutil/xwindow"
+	"github.com/siebenmann/ffox-remote/cmdline"
+)
+
+func main() {
+	log.SetPrefix("fakefox: ")
+	log.SetFlags(0)
+
+	login := ""
+	if u, e := user.Current(); e == nil {
+		login = u.Username
+	}
+	profile := flag.String("P", "default", "Firefox profile to advertise")
+	usr := flag.String("U", login, "Firefox user to advertise")
+	program := flag.String("G", "firefox", "Firefox program name to advertise")
+	version := flag.String("version", "5.1", "Protocol version to advertise")
+	response := flag.String("response", "200 executed command", "Response to send")
+	delay := flag.Duration("delay", 0, "How long to wait before responding")
+	count := flag.Int("count", 0, "Exit after this many commands")
+	pfix := flag.String("pref", "_MOZILLA", "X property prefix")
+	flag.Parse()
+
+	xu, err := xgbutil.NewConn()
+	if err != nil {
+		log.Fatal("X connection: ", err)
+	}
+	win, err := xwindow.Generate(xu)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// The window is never mapped, so its size doesn't matter.
+	err = win.CreateChecked(xu.RootWin(), 0, 0, 1, 1, xproto.CwEventMask, xproto.EventMaskPropertyChange)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	prop := func(name string) string { return *pfix + name }
+	for name, val := range map[string]string{
+		"_VERSION": *version, "_USER": *usr,
+		"_PROFILE": *profile, "_PROGRAM": *program,
+	} {
+		if err := xprop.ChangeProp(xu, win.Id, 8, prop(name), "STRING", []byte(val)); err != nil {
+			log.Fatal(err)
+		}
+	}
+	atom := func(name string) xproto.Atom {
+		a, err := xprop.Atm(xu, prop(name))
+		if err != nil {
+			log.Fatal(err)
+		}
+		return a
+	}
+	lockatom, cmdlatom, cmdatom := atom("_LOCK"), atom("_COMMANDLINE"), atom("_COMMAND")
+	fmt.Printf("fakefox window: 0x%x\n", win.Id)
+
+	// take reads and deletes a property in one go, as Firefox does.
+	take := func(a xproto.Atom) []byte {
+		p, err := xproto.GetProperty(xu.Conn(), true, win.Id, a,
+			xproto.GetPropertyTypeAny, 0, (1<<32)-1).Reply()
+		if err != nil {
+			return nil
+		}
+		return p.Value
+	}
+
+	locked := false
+	handled := 0
+	respond := func() {
+		if !locked {
+			log.Print("warning: command sent without the lock")
+		}
+		time.Sleep(*delay)
+		if err := xprop.ChangeProp(xu, win.Id, 8, prop("_RESPONSE"), "STRING", []byte(*response)); err != nil {
+			log.Print(err)
+		}
+		handled++
+		if *count > 0 && handled >= *count {
+			// Give the client a chance to see the response.
+			xu.Sync()
+			os.Exit(0)
+		}
+	}
+
+	xevent.PropertyNotifyFun(
+		func(xu *xgbutil.XUtil, ev xevent.PropertyNotifyEvent) {
+			newval := ev.State == xproto.PropertyNewValue
+			switch {
+			case ev.Atom == lockatom:
+				locked = newval
+				if newval {
+					fmt.Printf("locked\n")
+				} else {
+					fmt.Printf("unlocked\n")
+				}
+			case ev.Atom == cmdlatom && newval:
+				pwd, args, err := cmdline.Decode(take(cmdlatom))
+				if err != nil {
+					fmt.Printf("bad command line: %s\n", err)
+				} else {
+					fmt.Printf("command line: %q (in %s)\n", args, pwd)
+				}
+				respond()
+			case ev.Atom == cmdatom && newval:
+				fmt.Printf("legacy command: %s\n", take(cmdatom))
+				respond()
+			}
+		}).Connect(xu, win.Id)
+	xevent.Main(xu)
+}
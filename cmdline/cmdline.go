@@ -0,0 +1,147 @@
+// Package cmdline encodes and decodes the command lines of Firefox's
+// remote control protocol, as found in the _MOZILLA_COMMANDLINE X
+// property and passed to the D-Bus OpenURL method.
+//
+// The format is described in a comment in Firefox's
+// toolkit/components/remote/nsXRemoteService.cpp:
+//
+//	the commandline property is constructed as an array of int32_t
+//	followed by a series of null-terminated strings:
+//
+//	[argc][offsetargv0][offsetargv1...]<workingdir>\0<argv[0]>\0argv[1]...\0
+//	(offset is from the beginning of the buffer)
+//
+// Although it isn't documented, the integers are little-endian
+// (Firefox only runs on little-endian machines these days). argc
+// doesn't count the working directory, which has no offset of its
+// own; it always starts right after the offsets. argv[0] is the
+// program, which Firefox ignores. In practice Firefox also ignores
+// the working directory, except to resolve relative file names.
+//
+// Command lines come from other programs, so Decode checks
+// everything it can and never panics, however mangled its input.
+// DecodeStrict goes further, for command lines that we're going to
+// act on as Firefox would: it also insists that the command line is
+// laid out the way Encode (and Firefox) lay it out, which bounds how
+// much work and memory a hostile one can make us spend.
+package cmdline
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// MaxLen is the largest encoded command line that Firefox will read
+// from _MOZILLA_COMMANDLINE; it asks for 64 Kbytes worth of C longs
+// from the X server, which is 8192 32-bit units on 64-bit machines.
+// Longer command lines are silently truncated.
+const MaxLen = 32 * 1024
+
+// ErrTooShort is returned by Decode for data that's too short to have
+// even an argc.
+var ErrTooShort = errors.New("cmdline: command line too short")
+
+// Encode encodes the working directory pwd and the arguments args
+// (args[0] is the program) into a command line.
+func Encode(pwd string, args []string) []byte {
+	// The header is argc and then the offset of each argument;
+	// the strings start after it, with the working directory
+	// first.
+	hdr := (len(args) + 1) * 4
+	size := hdr + len(pwd) + 1
+	for _, a := range args {
+		size += len(a) + 1
+	}
+	b := make([]byte, hdr, size)
+	binary.LittleEndian.PutUint32(b, uint32(len(args)))
+	b = append(append(b, pwd...), 0)
+	for i, a := range args {
+		binary.LittleEndian.PutUint32(b[(i+1)*4:], uint32(len(b)))
+		b = append(append(b, a...), 0)
+	}
+	return b
+}
+
+// Decode decodes a command line into its working directory and
+// arguments. It fails if argc is impossible for the size of b, if
+// any offset points into the header or outside of b, or if any
+// string isn't NUL-terminated.
+func Decode(b []byte) (string, []string, error) {
+	return DecodeOrder(b, binary.LittleEndian)
+}
+
+// DecodeOrder is Decode with the integers in the given byte order.
+// Firefox always uses little-endian, but a broken client on a
+// big-endian machine might not, and it can be useful to know that.
+func DecodeOrder(b []byte, order binary.ByteOrder) (string, []string, error) {
+	return decode(b, order, false)
+}
+
+// DecodeStrict is Decode for command lines from untrusted sources,
+// such as the ones that -serve receives. On top of what Decode
+// checks, it fails if b is longer than MaxLen (Firefox would only
+// see part of it), if there are no arguments at all (not even the
+// program), or if the strings aren't one after another in order
+// without overlapping. The last means that a small command line
+// can't claim thousands of arguments that all point at one long
+// string, so what we return is never larger than b.
+func DecodeStrict(b []byte) (string, []string, error) {
+	if len(b) > MaxLen {
+		return "", nil, fmt.Errorf("cmdline: command line is %d bytes, over the limit of %d", len(b), MaxLen)
+	}
+	return decode(b, binary.LittleEndian, true)
+}
+
+// decode does the work of DecodeOrder and DecodeStrict.
+func decode(b []byte, order binary.ByteOrder, strict bool) (string, []string, error) {
+	if len(b) < 4 {
+		return "", nil, ErrTooShort
+	}
+	argc := order.Uint32(b)
+	// The offsets have to fit in the buffer, and so does a NUL
+	// for every argument.
+	if uint64(argc) > uint64(len(b))/4 {
+		return "", nil, fmt.Errorf("cmdline: impossible argc %d for %d bytes", argc, len(b))
+	}
+	if strict && argc == 0 {
+		return "", nil, errors.New("cmdline: no arguments, not even the program")
+	}
+	hdr := int(argc+1) * 4
+	pwd, err := cstring(b, hdr)
+	if err != nil {
+		return "", nil, fmt.Errorf("cmdline: working directory: %s", err)
+	}
+	// next is where the next string has to start, for strict
+	// checking.
+	next := int64(hdr + len(pwd) + 1)
+	args := make([]string, argc)
+	for i := range args {
+		off := order.Uint32(b[(i+1)*4:])
+		if int64(off) < int64(hdr) {
+			return "", nil, fmt.Errorf("cmdline: argument %d: offset %d is inside the header", i, off)
+		}
+		if strict && int64(off) < next {
+			return "", nil, fmt.Errorf("cmdline: argument %d: offset %d overlaps the previous string", i, off)
+		}
+		args[i], err = cstring(b, int(off))
+		if err != nil {
+			return "", nil, fmt.Errorf("cmdline: argument %d: %s", i, err)
+		}
+		next = int64(off) + int64(len(args[i])) + 1
+	}
+	return pwd, args, nil
+}
+
+// cstring returns the NUL-terminated string starting at off in b.
+func cstring(b []byte, off int) (string, error) {
+	if off < 0 || off >= len(b) {
+		return "", fmt.Errorf("offset %d out of range", off)
+	}
+	n := bytes.IndexByte(b[off:], 0)
+	if n < 0 {
+		return "", fmt.Errorf("string at offset %d isn't terminated", off)
+	}
+	return string(b[off : off+n]), nil
+}
@@ -0,0 +1,119 @@
+package cmdline
+
+import (
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// raw builds a command line by hand: argc, then the offsets, then
+// the strings, which the caller has to lay out (with NULs) itself.
+func raw(argc uint32, offs []uint32, strs string) []byte {
+	b := binary.LittleEndian.AppendUint32(nil, argc)
+	for _, o := range offs {
+		b = binary.LittleEndian.AppendUint32(b, o)
+	}
+	return append(b, strs...)
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, c := range []struct {
+		pwd  string
+		args []string
+	}{
+		{"/home/cks", []string{"firefox"}},
+		{"/tmp", []string{"firefox", "-new-tab", "https://example.org/"}},
+		{"", []string{"firefox", "", ""}},
+		{"/a dir/with spaces", []string{"firefox", "-search", "two words", "ünïcödé"}},
+		{"/", []string{"firefox", strings.Repeat("x", 20000)}},
+	} {
+		enc := Encode(c.pwd, c.args)
+		for name, decode := range map[string]func([]byte) (string, []string, error){
+			"Decode": Decode, "DecodeStrict": DecodeStrict,
+		} {
+			pwd, args, err := decode(enc)
+			if err != nil {
+				t.Errorf("%s(Encode(%q, %q)): %s", name, c.pwd, c.args, err)
+				continue
+			}
+			if pwd != c.pwd || !reflect.DeepEqual(args, c.args) {
+				t.Errorf("%s(Encode(%q, %q)) = %q, %q", name, c.pwd, c.args, pwd, args)
+			}
+		}
+	}
+}
+
+func TestMalformed(t *testing.T) {
+	good := Encode("/tmp", []string{"firefox", "https://example.org/"})
+	// In the ones built by hand, "/tmp\0" and "firefox\0" are at 8
+	// and 13 with one argument and 12 and 17 with two.
+	for _, c := range []struct {
+		name   string
+		b      []byte
+		lax    bool // Decode should fail as well as DecodeStrict
+		tooLax error
+	}{
+		{"empty", nil, true, ErrTooShort},
+		{"truncated header", good[:3], true, ErrTooShort},
+		{"no offsets", good[:4], true, nil},
+		{"truncated offsets", good[:10], true, nil},
+		{"negative argc", raw(0xffffffff, nil, "/tmp\x00"), true, nil},
+		{"huge argc", raw(1000, []uint32{8}, "/tmp\x00firefox\x00"), true, nil},
+		{"argc past the offsets", raw(3, []uint32{16, 16}, "/\x00a\x00"), true, nil},
+		{"offset past the end", raw(1, []uint32{1000}, "/tmp\x00firefox\x00"), true, nil},
+		{"offset at the end", raw(1, []uint32{21}, "/tmp\x00firefox\x00"), true, nil},
+		{"negative offset", raw(1, []uint32{0xfffffffc}, "/tmp\x00firefox\x00"), true, nil},
+		{"offset in the header", raw(1, []uint32{4}, "/tmp\x00firefox\x00"), true, nil},
+		{"missing final NUL", good[:len(good)-1], true, nil},
+		{"unterminated working directory", raw(0, nil, "/tmp"), true, nil},
+		{"overlapping offsets", raw(2, []uint32{17, 17}, "/tmp\x00firefox\x00"), false, nil},
+		{"offset in the working directory", raw(1, []uint32{9}, "/tmp\x00firefox\x00"), false, nil},
+		{"out of order offsets", raw(2, []uint32{25, 17}, "/tmp\x00firefox\x00https\x00"), false, nil},
+		{"no arguments", raw(0, nil, "/tmp\x00"), false, nil},
+		{"longer than MaxLen", Encode("/", []string{"firefox", strings.Repeat("x", MaxLen)}), false, nil},
+	} {
+		if _, _, err := DecodeStrict(c.b); err == nil {
+			t.Errorf("%s: DecodeStrict accepted it", c.name)
+		}
+		_, _, err := Decode(c.b)
+		switch {
+		case c.lax && err == nil:
+			t.Errorf("%s: Decode accepted it", c.name)
+		case !c.lax && err != nil:
+			t.Errorf("%s: Decode rejected it: %s", c.name, err)
+		case c.tooLax != nil && !errors.Is(err, c.tooLax):
+			t.Errorf("%s: Decode gave %v, want %v", c.name, err, c.tooLax)
+		}
+	}
+}
+
+// Decoding any prefix of a command line mustn't panic, and only the
+// whole thing is valid.
+func TestTruncated(t *testing.T) {
+	enc := Encode("/home/cks", []string{"firefox", "-new-window", "https://example.org/"})
+	for i := 0; i < len(enc); i++ {
+		if _, _, err := Decode(enc[:i]); err == nil {
+			t.Errorf("Decode accepted the first %d of %d bytes", i, len(enc))
+		}
+		if _, _, err := DecodeStrict(enc[:i]); err == nil {
+			t.Errorf("DecodeStrict accepted the first %d of %d bytes", i, len(enc))
+		}
+	}
+}
+
+// A big-endian command line decodes with DecodeOrder and not with
+// Decode.
+func TestDecodeOrder(t *testing.T) {
+	b := binary.BigEndian.AppendUint32(nil, 1)
+	b = binary.BigEndian.AppendUint32(b, 10)
+	b = append(b, "/\x00firefox\x00"...)
+	pwd, args, err := DecodeOrder(b, binary.BigEndian)
+	if err != nil || pwd != "/" || !reflect.DeepEqual(args, []string{"firefox"}) {
+		t.Errorf("DecodeOrder = %q, %q, %v", pwd, args, err)
+	}
+	if _, _, err := Decode(b); err == nil {
+		t.Errorf("Decode accepted a big-endian command line")
+	}
+}
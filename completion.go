@@ -0,0 +1,210 @@
+package main
+
+// Shell completion, for 'ffox-remote completion'.
+//
+// We generate the completion scripts from our actual flag definitions
+// (and our subcommands), so they can't get out of date the way a
+// hand-maintained script would. What we can't get from the flag
+// package is what sort of value a flag takes, so we keep a little
+// table of the flags that take files and the ones with a fixed set
+// of values; everything else that isn't a boolean just takes some
+// word that we can't complete.
+//
+// -P's values are the instance names from the configuration file and
+// the profile names from profiles.ini, which can change after you've
+// loaded the completion script. So the scripts get them by running
+// 'ffox-remote completion profiles' every time.
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// fileFlags are the flags whose values are file names.
+var fileFlags = map[string]bool{
+	"config": true, "record": true, "history": true, "audit": true,
+	"trace": true, "bookmarks": true, "markdown": true, "opml": true,
+	"xauthority": true,
+}
+
+// choiceFlags are the flags that take one of a fixed set of values.
+var choiceFlags = map[string][]string{
+	"log-format": {"plain", "text", "json"},
+	"log-level":  {"debug", "info", "warn", "error", "none"},
+	"transport":  transportNames(),
+}
+
+// completionShells are the shells we can generate completion for.
+var completionShells = []string{"bash", "zsh", "fish"}
+
+// A complFlag is what a completion script needs to know about a flag.
+type complFlag struct {
+	name, usage string
+	isBool      bool
+}
+
+// completionFlags returns all of our flags, sorted by name.
+func completionFlags() []complFlag {
+	var flags []complFlag
+	flag.VisitAll(func(f *flag.Flag) {
+		b, ok := f.Value.(interface{ IsBoolFlag() bool })
+		flags = append(flags, complFlag{f.Name, f.Usage, ok && b.IsBoolFlag()})
+	})
+	return flags
+}
+
+// sortedSubcommands returns our subcommands in order.
+func sortedSubcommands() []string {
+	var subs []string
+	for s := range subcommands {
+		subs = append(subs, s)
+	}
+	sort.Strings(subs)
+	return subs
+}
+
+// completionProfiles returns the possible values for -P, which are
+// the configured instances and then the profiles from profiles.ini.
+func completionProfiles(cfg *config) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for n := range cfg.instances {
+		names = append(names, n)
+		seen[n] = true
+	}
+	sort.Strings(names)
+	for _, n := range profileNames() {
+		if !seen[n] {
+			seen[n] = true
+			names = append(names, n)
+		}
+	}
+	return names
+}
+
+// writeCompletion writes the completion script for shell to w.
+func writeCompletion(w io.Writer, shell string) error {
+	switch shell {
+	case "bash":
+		bashCompletion(w)
+	case "zsh":
+		zshCompletion(w)
+	case "fish":
+		fishCompletion(w)
+	default:
+		return fmt.Errorf("unknown shell %q (we know %s)", shell, strings.Join(completionShells, ", "))
+	}
+	return nil
+}
+
+func bashCompletion(w io.Writer) {
+	var all, withValue, files []string
+	for _, f := range completionFlags() {
+		all = append(all, "-"+f.name)
+		switch {
+		case f.name == "P" || choiceFlags[f.name] != nil:
+		case fileFlags[f.name]:
+			files = append(files, "-"+f.name)
+		case !f.isBool:
+			withValue = append(withValue, "-"+f.name)
+		}
+	}
+
+	fmt.Fprintf(w, "# bash completion for ffox-remote, from 'ffox-remote completion bash'\n")
+	fmt.Fprintf(w, "_ffox_remote() {\n")
+	fmt.Fprintf(w, "\tlocal cur=${COMP_WORDS[COMP_CWORD]} prev=${COMP_WORDS[COMP_CWORD-1]}\n")
+	fmt.Fprintf(w, "\tCOMPREPLY=()\n")
+	fmt.Fprintf(w, "\tif [[ ${COMP_WORDS[1]} == completion && $COMP_CWORD -eq 2 ]]; then\n")
+	fmt.Fprintf(w, "\t\tCOMPREPLY=($(compgen -W '%s' -- \"$cur\"))\n", strings.Join(completionShells, " "))
+	fmt.Fprintf(w, "\t\treturn\n\tfi\n")
+	fmt.Fprintf(w, "\tcase $prev in\n")
+	fmt.Fprintf(w, "\t-P)\n\t\tlocal IFS=$'\\n'\n")
+	fmt.Fprintf(w, "\t\tCOMPREPLY=($(compgen -W \"$(ffox-remote completion profiles 2>/dev/null)\" -- \"$cur\"))\n")
+	fmt.Fprintf(w, "\t\treturn;;\n")
+	var names []string
+	for n := range choiceFlags {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		fmt.Fprintf(w, "\t-%s)\n\t\tCOMPREPLY=($(compgen -W '%s' -- \"$cur\"))\n\t\treturn;;\n", n, strings.Join(choiceFlags[n], " "))
+	}
+	fmt.Fprintf(w, "\t%s)\n\t\tCOMPREPLY=($(compgen -f -- \"$cur\"))\n\t\treturn;;\n", strings.Join(files, "|"))
+	fmt.Fprintf(w, "\t%s)\n\t\treturn;;\n", strings.Join(withValue, "|"))
+	fmt.Fprintf(w, "\tesac\n")
+	fmt.Fprintf(w, "\tif [[ $cur == -* ]]; then\n")
+	fmt.Fprintf(w, "\t\tCOMPREPLY=($(compgen -W '%s' -- \"$cur\"))\n", strings.Join(all, " "))
+	fmt.Fprintf(w, "\telif [[ $COMP_CWORD -eq 1 ]]; then\n")
+	fmt.Fprintf(w, "\t\tCOMPREPLY=($(compgen -W '%s' -- \"$cur\"))\n", strings.Join(sortedSubcommands(), " "))
+	fmt.Fprintf(w, "\tfi\n")
+	fmt.Fprintf(w, "}\n")
+	fmt.Fprintf(w, "complete -o default -F _ffox_remote ffox-remote\n")
+}
+
+// zshQuote quotes s for use in an _arguments description inside
+// single quotes.
+func zshQuote(s string) string {
+	r := strings.NewReplacer(`'`, `'\''`, `\`, `\\`, `[`, `\[`, `]`, `\]`, `:`, `\:`)
+	return r.Replace(s)
+}
+
+func zshCompletion(w io.Writer) {
+	fmt.Fprintf(w, "#compdef ffox-remote\n")
+	fmt.Fprintf(w, "# zsh completion for ffox-remote, from 'ffox-remote completion zsh'\n\n")
+	fmt.Fprintf(w, "_ffox_remote_profiles() {\n")
+	fmt.Fprintf(w, "\tlocal -a profs\n")
+	fmt.Fprintf(w, "\tprofs=(${(f)\"$(ffox-remote completion profiles 2>/dev/null)\"})\n")
+	fmt.Fprintf(w, "\tcompadd -a profs\n")
+	fmt.Fprintf(w, "}\n\n")
+	fmt.Fprintf(w, "_ffox_remote() {\n")
+	fmt.Fprintf(w, "\tif [[ $words[2] == completion ]] && (( CURRENT == 3 )); then\n")
+	fmt.Fprintf(w, "\t\tcompadd %s\n\t\treturn\n\tfi\n", strings.Join(completionShells, " "))
+	fmt.Fprintf(w, "\tif (( CURRENT == 2 )) && [[ $words[2] != -* ]]; then\n")
+	fmt.Fprintf(w, "\t\tcompadd %s\n\tfi\n", strings.Join(sortedSubcommands(), " "))
+	fmt.Fprintf(w, "\t_arguments \\\n")
+	for _, f := range completionFlags() {
+		spec := "-" + f.name + "[" + zshQuote(f.usage) + "]"
+		switch {
+		case f.name == "P":
+			spec += ":profile:_ffox_remote_profiles"
+		case choiceFlags[f.name] != nil:
+			spec += ":" + f.name + ":(" + strings.Join(choiceFlags[f.name], " ") + ")"
+		case fileFlags[f.name]:
+			spec += ":file:_files"
+		case !f.isBool:
+			spec += ":" + f.name + ": "
+		}
+		fmt.Fprintf(w, "\t\t'%s' \\\n", spec)
+	}
+	fmt.Fprintf(w, "\t\t'*:URL or file:_files'\n")
+	fmt.Fprintf(w, "}\n\n")
+	fmt.Fprintf(w, "_ffox_remote \"$@\"\n")
+}
+
+// fishQuote quotes s for fish inside single quotes.
+func fishQuote(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s)
+}
+
+func fishCompletion(w io.Writer) {
+	fmt.Fprintf(w, "# fish completion for ffox-remote, from 'ffox-remote completion fish'\n")
+	fmt.Fprintf(w, "complete -c ffox-remote -n __fish_use_subcommand -f -a '%s'\n", strings.Join(sortedSubcommands(), " "))
+	fmt.Fprintf(w, "complete -c ffox-remote -n '__fish_seen_subcommand_from completion' -f -a '%s'\n", strings.Join(completionShells, " "))
+	for _, f := range completionFlags() {
+		extra := ""
+		switch {
+		case f.name == "P":
+			extra = " -x -a '(ffox-remote completion profiles 2>/dev/null)'"
+		case choiceFlags[f.name] != nil:
+			extra = " -x -a '" + strings.Join(choiceFlags[f.name], " ") + "'"
+		case fileFlags[f.name]:
+			extra = " -r -F"
+		case !f.isBool:
+			extra = " -x"
+		}
+		fmt.Fprintf(w, "complete -c ffox-remote -o %s%s -d '%s'\n", f.name, extra, fishQuote(f.usage))
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+// The configuration file, which describes named Firefox instances.
+//
+// If you run several Firefoxes, possibly some of them with
+// non-standard X property prefixes, it gets tedious to remember
+// which options pick out which one. The configuration file lets you
+// give each of them a name and then say '-P NAME'. The file is
+// $XDG_CONFIG_HOME/ffox-remote/config (normally
+// ~/.config/ffox-remote/config) unless you use -config, and looks
+// like:
+//
+//	# my work Firefox, run with its own property prefix
+//	[work]
+//	profile = default
+//	user = cks
+//	program = firefox
+//	prefix = _WORKFOX, _MOZILLA
+//
+// Each '[NAME]' section is an instance, and the settings (profile,
+// user, program, prefix, title, class, and host) are the same as
+// -P, -U, -G, -pref, -title, -class, and -host. All of them are
+// optional; the profile defaults to the section name. Settings given
+// on the command line override the instance's. It's not an error for
+// the file not to exist.
+//
+// Before the first instance, there can be global settings:
+//
+//	user = auto
+//	history = ~/.ffox-history
+//
+// sets the default for -U ('auto' is a good choice on X servers that
+// several people use) and turns on the history file (see -history).
+// Hook commands (pre-hook and post-hook; see hooks.go) can be set
+// either globally or for an instance, and an instance's hooks replace
+// the global ones.
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// An instance is a named Firefox from the configuration file.
+type instance struct {
+	profile  string
+	user     string
+	program  string
+	prefixes []string
+	preHook  string
+	postHook string
+	title    string
+	class    string
+	host     string
+}
+
+// config is the contents of the configuration file.
+type config struct {
+	instances map[string]*instance
+	user      string
+	history   string
+	preHook   string
+	postHook  string
+}
+
+// loginName returns our login name the way that Firefox finds it
+// for _MOZILLA_USER.
+func loginName() string {
+	for _, v := range []string{"LOGNAME", "USER"} {
+		if n := os.Getenv(v); n != "" {
+			return n
+		}
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return ""
+}
+
+// configFile returns the default location of the configuration file.
+func configFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "ffox-remote", "config")
+}
+
+// loadConfig reads the configuration file fname. If fname is "", we
+// use the default file, which doesn't have to exist.
+func loadConfig(fname string) (*config, error) {
+	cfg := &config{instances: make(map[string]*instance)}
+	mustExist := fname != ""
+	if fname == "" {
+		fname = configFile()
+	}
+	if fname == "" {
+		return cfg, nil
+	}
+	f, err := os.Open(fname)
+	if err != nil {
+		if os.IsNotExist(err) && !mustExist {
+			return cfg, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var cur *instance
+	lnum := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lnum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if line[0] == '[' && line[len(line)-1] == ']' {
+			name := strings.TrimSpace(line[1 : len(line)-1])
+			cur = &instance{profile: name}
+			cfg.instances[name] = cur
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("%s:%d: not a setting: %q", fname, lnum, line)
+		}
+		key, val := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		if cur == nil {
+			switch key {
+			case "user":
+				cfg.user = val
+			case "history":
+				cfg.history = expandHome(val)
+			case "pre-hook":
+				cfg.preHook = val
+			case "post-hook":
+				cfg.postHook = val
+			default:
+				return nil, fmt.Errorf("%s:%d: unknown global setting %q", fname, lnum, key)
+			}
+			continue
+		}
+		switch key {
+		case "profile":
+			cur.profile = val
+		case "user":
+			cur.user = val
+		case "program":
+			cur.program = val
+		case "prefix":
+			var l listFlag
+			_ = l.Set(strings.Replace(val, " ", "", -1))
+			cur.prefixes = l
+		case "pre-hook":
+			cur.preHook = val
+		case "post-hook":
+			cur.postHook = val
+		case "host":
+			cur.host = val
+		case "title", "class":
+			if _, err := regexp.Compile(val); err != nil {
+				return nil, fmt.Errorf("%s:%d: bad %s: %s", fname, lnum, key, err)
+			}
+			if key == "title" {
+				cur.title = val
+			} else {
+				cur.class = val
+			}
+		default:
+			return nil, fmt.Errorf("%s:%d: unknown setting %q", fname, lnum, key)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// expandHome expands a leading '~/' in fname to our home directory.
+func expandHome(fname string) string {
+	if !strings.HasPrefix(fname, "~/") {
+		return fname
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return fname
+	}
+	return filepath.Join(home, fname[2:])
+}
+
+// apply fills in o from the instance, except for things that were
+// set on the command line (as reported by set, which is indexed by
+// flag name).
+func (in *instance) apply(o *options, set map[string]bool) {
+	o.profile = in.profile
+	if in.user != "" && !set["U"] {
+		o.user = in.user
+	}
+	if in.program != "" && !set["G"] {
+		o.programs = []string{in.program}
+	}
+	if len(in.prefixes) > 0 && !set["pref"] {
+		o.pfixes = in.prefixes
+	}
+	if in.preHook != "" {
+		o.preHook = in.preHook
+	}
+	if in.postHook != "" {
+		o.postHook = in.postHook
+	}
+	if in.title != "" && !set["title"] {
+		o.title = regexp.MustCompile(in.title)
+	}
+	if in.class != "" && !set["class"] {
+		o.class = regexp.MustCompile(in.class)
+	}
+	if in.host != "" && !set["host"] {
+		o.host = in.host
+	}
+}
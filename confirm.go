@@ -0,0 +1,98 @@
+package main
+
+// Asking for confirmation before we send things, for -confirm.
+//
+// If ffox-remote is run by helpers that you only partly trust, -confirm
+// gives you a look at each command line before it goes to Firefox.
+// We show what Firefox will actually get, by decoding the encoded
+// command line, and quote anything that has unprintable characters in
+// it so that nothing can hide. We ask on the terminal, not standard
+// input (which may be a pipe of URLs), and if there's no terminal we
+// refuse to send anything.
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/siebenmann/ffox-remote/cmdline"
+)
+
+// errNotConfirmed is returned by confirmSend when the answer is no.
+var errNotConfirmed = errors.New("not confirmed, nothing sent")
+
+// openTTY opens the terminal for reading and writing.
+func openTTY() (*os.File, *os.File, error) {
+	if runtime.GOOS == "windows" {
+		in, err := os.Open("CONIN$")
+		if err != nil {
+			return nil, nil, err
+		}
+		out, err := os.OpenFile("CONOUT$", os.O_WRONLY, 0)
+		if err != nil {
+			in.Close()
+			return nil, nil, err
+		}
+		return in, out, nil
+	}
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	return tty, tty, err
+}
+
+// showArg returns a as is if it's entirely printable and quoted if
+// it isn't.
+func showArg(a string) string {
+	for _, r := range a {
+		if !unicode.IsPrint(r) {
+			return strconv.QuoteToASCII(a)
+		}
+	}
+	return a
+}
+
+// confirmSend shows the command line that we'd send to target and
+// asks whether to send it. It returns errNotConfirmed if the answer
+// isn't yes, or another error if we can't ask.
+func confirmSend(o *options, target, cwd string, args []string) error {
+	in, out, err := openTTY()
+	if err != nil {
+		return fmt.Errorf("can't ask for confirmation: %s", err)
+	}
+	defer in.Close()
+	if out != in {
+		defer out.Close()
+	}
+	pwd, dargs, err := cmdline.Decode(cmdline.Encode(cwd, args))
+	if err != nil {
+		return err
+	}
+	if target == "" {
+		target = "Firefox"
+	}
+	if o.profile != "" {
+		target += fmt.Sprintf(" (profile %s)", showArg(o.profile))
+	}
+	fmt.Fprintf(out, "send to %s:\n", target)
+	if pwd != "" {
+		fmt.Fprintf(out, "\tcwd: %s\n", showArg(pwd))
+	}
+	for i, a := range dargs[1:] {
+		fmt.Fprintf(out, "\targv[%d]: %s\n", i+1, showArg(a))
+	}
+	fmt.Fprintf(out, "send this? [y/N] ")
+	ans, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && ans == "" {
+		fmt.Fprintln(out)
+		return errNotConfirmed
+	}
+	switch strings.ToLower(strings.TrimSpace(ans)) {
+	case "y", "yes":
+		return nil
+	}
+	return errNotConfirmed
+}
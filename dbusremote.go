@@ -0,0 +1,369 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+// The D-Bus transport.
+//
+// When Firefox runs natively on Wayland it has no X windows, so it
+// can't use the X property protocol. Instead it registers on the
+// D-Bus session bus as 'org.mozilla.<app>.<profile>', with an object
+// '/org/mozilla/<app>/Remote' that has an 'OpenURL' method in the
+// interface 'org.mozilla.<app>'. OpenURL takes a byte array that is
+// the same encoded command line we put in _MOZILLA_COMMANDLINE (see the
+// cmdline package). There's no lock and no response; the method
+// call succeeds or fails.
+//
+// <app> is the remoting name (what X Firefox puts in _MOZILLA_PROGRAM)
+// and <profile> is the profile name (or, in Firefox 131+, the full
+// path to the profile) encoded in base64, with the characters that
+// D-Bus doesn't allow in names ('+', '/', and '=') all turned into
+// '_'. This can't be reversed exactly, so when we want to decode a
+// profile from a bus name we try both '+' and '/'.
+//
+// Snap and Flatpak only let a sandboxed program own bus names under
+// its own app ID. The official Snap and Flatpak Firefoxes have the
+// app ID 'org.mozilla.firefox', so their names are the usual ones,
+// but a Firefox packaged under some other app ID (a rebuild in its
+// own Flatpak, say) has to use '<app ID>.<profile>' instead, which
+// doesn't tell us its remoting name. Asking every program on a busy
+// bus what it is would be slow, so we only look at names that have
+// the name of a Firefox or a fork (see forks.go) in them, owned by a
+// sandboxed process whose app ID does too, and then ask that process
+// what's under '/org/mozilla'.
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/xgb/xproto"
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+	"github.com/siebenmann/ffox-remote/cmdline"
+)
+
+// dbusApp turns a program name into what Firefox uses in its D-Bus
+// names, which can't have '-' in them in all places.
+func dbusApp(program string) string {
+	return strings.Replace(strings.ToLower(program), "-", "_", -1)
+}
+
+// dbusProfileEncode encodes a profile name the way Firefox does.
+func dbusProfileEncode(profile string) string {
+	enc := base64.StdEncoding.EncodeToString([]byte(profile))
+	return strings.NewReplacer("+", "_", "/", "_", "=", "_").Replace(enc)
+}
+
+// dbusProfileDecode returns the possible decodings of an encoded
+// profile name.
+func dbusProfileDecode(enc string) []string {
+	enc = strings.TrimRight(enc, "_")
+	var res []string
+	for _, r := range []string{"+", "/"} {
+		dec, e := base64.RawStdEncoding.DecodeString(strings.Replace(enc, "_", r, -1))
+		if e == nil {
+			res = append(res, string(dec))
+		}
+	}
+	return res
+}
+
+// dbusProfileMatch is profileMatch for D-Bus names, with the same
+// rules for matching new-style full profile paths and globs.
+func dbusProfileMatch(enc, profile string) bool {
+	if profile == "" || enc == dbusProfileEncode(profile) {
+		return true
+	}
+	for _, p := range dbusProfileDecode(enc) {
+		if p == profile || (p != "" && p[0] == '/' && profile[0] != '/' &&
+			strings.HasSuffix(p, "."+profile)) {
+			return true
+		}
+		if strings.ContainsAny(profile, "*?[") && profileGlob(p, profile) {
+			return true
+		}
+	}
+	return false
+}
+
+// dbusTransport talks to Firefox through D-Bus.
+type dbusTransport struct {
+	o    *options
+	conn *dbus.Conn
+	name string // bus name
+	app  string
+	// If pids isn't empty, we only want a Firefox whose bus name
+	// is owned by one of these processes (see ipc.go).
+	pids map[uint32]string
+}
+
+func newDBusTransport(o *options) (*dbusTransport, error) {
+	conn, err := dbus.SessionBus()
+	if err != nil {
+		return nil, fmt.Errorf("D-Bus session bus: %s", err)
+	}
+	return &dbusTransport{o: o, conn: conn}, nil
+}
+
+// A dbusName is a Firefox remote name on the bus.
+type dbusName struct {
+	name string // the bus name
+	app  string // the remoting name
+	prof string // the encoded profile
+}
+
+// firefoxNames returns all of the Firefox remote names on the bus.
+func (t *dbusTransport) firefoxNames() []dbusName {
+	var names []string
+	err := t.conn.BusObject().Call("org.freedesktop.DBus.ListNames", 0).Store(&names)
+	if err != nil {
+		slog.Warn("D-Bus ListNames", "err", err)
+		return nil
+	}
+	var res []dbusName
+	for _, n := range names {
+		f := strings.SplitN(n, ".", 4)
+		switch {
+		case len(f) == 4 && f[0] == "org" && f[1] == "mozilla":
+			res = append(res, dbusName{n, f[2], f[3]})
+		case len(f) >= 3 && foxLike(n[:strings.LastIndexByte(n, '.')]):
+			if app := t.sandboxedApp(n); app != "" {
+				res = append(res, dbusName{n, app, n[strings.LastIndexByte(n, '.')+1:]})
+			}
+		}
+	}
+	return res
+}
+
+// ownerPID returns the process ID of whatever owns name.
+func (t *dbusTransport) ownerPID(name string) (uint32, error) {
+	var pid uint32
+	err := t.conn.BusObject().Call("org.freedesktop.DBus.GetConnectionUnixProcessID", 0, name).Store(&pid)
+	return pid, err
+}
+
+// foxLike reports whether an app ID or bus name has the name of a
+// Firefox or a fork in it.
+func foxLike(id string) bool {
+	id = strings.ToLower(id)
+	for _, p := range forkPrograms {
+		if strings.Contains(id, strings.SplitN(p, "-", 2)[0]) {
+			return true
+		}
+	}
+	return false
+}
+
+// sandboxID returns the Flatpak app ID or Snap name of the process
+// pid, or "" if it isn't sandboxed.
+func sandboxID(pid uint32) string {
+	switch pidPackaging(uint(pid)) {
+	case "flatpak":
+		b, err := os.ReadFile(fmt.Sprintf("/proc/%d/root/.flatpak-info", pid))
+		if err != nil {
+			return ""
+		}
+		app := false
+		for _, l := range strings.Split(string(b), "\n") {
+			switch {
+			case strings.HasPrefix(l, "["):
+				app = l == "[Application]"
+			case app && strings.HasPrefix(l, "name="):
+				return strings.TrimPrefix(l, "name=")
+			}
+		}
+	case "snap":
+		b, err := os.ReadFile(fmt.Sprintf("/proc/%d/cgroup", pid))
+		if err != nil {
+			return ""
+		}
+		if _, rest, ok := strings.Cut(string(b), "/snap."); ok {
+			return strings.SplitN(rest, ".", 2)[0]
+		}
+	}
+	return ""
+}
+
+// sandboxedApp returns the remoting name of the Firefox that owns
+// name if it's a sandboxed Firefox, and "" otherwise. Firefox's
+// remote object is '/org/mozilla/<app>/Remote', so the remoting name
+// is whatever under '/org/mozilla' has a 'Remote'.
+func (t *dbusTransport) sandboxedApp(name string) string {
+	pid, err := t.ownerPID(name)
+	if err != nil || !foxLike(sandboxID(pid)) {
+		return ""
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), dbusProbeTimeout)
+	defer cancel()
+	children := func(path string) []introspect.Node {
+		var s string
+		err := t.conn.Object(name, dbus.ObjectPath(path)).CallWithContext(ctx, "org.freedesktop.DBus.Introspectable.Introspect", 0).Store(&s)
+		var n introspect.Node
+		if err != nil || xml.Unmarshal([]byte(s), &n) != nil {
+			return nil
+		}
+		return n.Children
+	}
+	for _, c := range children("/org/mozilla") {
+		for _, r := range children("/org/mozilla/" + c.Name) {
+			if r.Name == "Remote" {
+				return c.Name
+			}
+		}
+	}
+	return ""
+}
+
+// dbusProbeTimeout is how long we give a sandboxed program to tell us
+// whether it's Firefox.
+const dbusProbeTimeout = 2 * time.Second
+
+// packaging returns how the Firefox that owns n was packaged, the
+// same way as packaging.go does for X windows.
+func (t *dbusTransport) packaging(n dbusName) string {
+	for _, p := range dbusProfileDecode(n.prof) {
+		if pk := profilePackaging(p); pk != "" {
+			return pk
+		}
+	}
+	pid, err := t.ownerPID(n.name)
+	if err != nil {
+		return ""
+	}
+	return pidPackaging(uint(pid))
+}
+
+func (t *dbusTransport) list() {
+	for _, n := range t.firefoxNames() {
+		pk := t.packaging(n)
+		if pk == "" {
+			pk = "unknown"
+		}
+		fmt.Printf("%s program=%s profile=%s packaging=%s\n", n.name, n.app,
+			strings.Join(dbusProfileDecode(n.prof), "|"), pk)
+	}
+}
+
+// find finds the bus name for our program and profile, preferring
+// programs in the order we were given them. If nothing has our
+// profile, we try the alternate profile (see options.profileAlt).
+func (t *dbusTransport) find(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	names := t.firefoxNames()
+	profiles := []string{t.o.profile}
+	if t.o.profileAlt != "" {
+		profiles = append(profiles, t.o.profileAlt)
+	}
+	for _, prof := range profiles {
+		for _, p := range t.o.programs {
+			for _, n := range names {
+				if (p == "" || n.app == dbusApp(p)) && dbusProfileMatch(n.prof, prof) && t.wantOwner(n.name) {
+					t.name = n.name
+					t.app = n.app
+					return nil
+				}
+			}
+		}
+	}
+	return errors.New("can't find a running Firefox on D-Bus")
+}
+
+// wantOwner reports whether the process that owns the bus name is
+// one that we want, if we only want some.
+func (t *dbusTransport) wantOwner(name string) bool {
+	if len(t.pids) == 0 {
+		return true
+	}
+	pid, err := t.ownerPID(name)
+	if err != nil {
+		slog.Warn("D-Bus GetConnectionUnixProcessID", "name", name, "err", err)
+		return false
+	}
+	_, ok := t.pids[pid]
+	return ok
+}
+
+func (t *dbusTransport) describe() {
+	fmt.Printf("firefox D-Bus name: %s\n", t.name)
+	fmt.Printf("firefox program: %s\n", t.app)
+}
+
+// details reports what we know about the Firefox we found, for
+// -find-format.
+func (t *dbusTransport) details() []foundField {
+	return []foundField{{"dbus_name", t.name}, {"program", t.app}}
+}
+
+// target describes the Firefox we found, for records.
+func (t *dbusTransport) target() string {
+	return "D-Bus " + t.name
+}
+
+// encode returns what send would pass to OpenURL.
+func (t *dbusTransport) encode(cwd string, args []string) ([]wireMsg, error) {
+	return []wireMsg{{where: "org.mozilla." + t.app + ".OpenURL", data: cmdline.Encode(cwd, args), cmdline: true}}, nil
+}
+
+func (t *dbusTransport) send(ctx context.Context, cwd string, args []string) (response, error) {
+	obj := t.conn.Object(t.name, dbus.ObjectPath("/org/mozilla/"+t.app+"/Remote"))
+	call := obj.CallWithContext(ctx, "org.mozilla."+t.app+".OpenURL", 0, cmdline.Encode(cwd, args))
+	if call.Err != nil {
+		return response{}, fmt.Errorf("D-Bus OpenURL: %s", call.Err)
+	}
+	return response{}, nil
+}
+
+// isXWayland returns true if the X server is XWayland. Modern
+// XWayland has a XWAYLAND extension; for older ones we guess from
+// the environment.
+func (t *xTransport) isXWayland() bool {
+	r, err := xproto.QueryExtension(t.xu.Conn(), uint16(len("XWAYLAND")), "XWAYLAND").Reply()
+	if err == nil && r.Present {
+		return true
+	}
+	return os.Getenv("WAYLAND_DISPLAY") != ""
+}
+
+// altTransport is what we try if the normal transport fails to find
+// Firefox (or we couldn't even set it up, in which case t is nil).
+// If we're on Wayland, Firefox is probably running natively and so
+// we can talk to it through D-Bus instead. If the window manager told
+// us about native Wayland Firefox windows (see ipc.go), we talk to
+// the Firefox that they belong to.
+func altTransport(o *options, t transport) transport {
+	if _, ok := t.(*xTransport); ok && len(ipcWayland) > 0 {
+		slog.Info("no Firefox X windows, but the window manager has native Wayland Firefox windows; trying D-Bus")
+		dt, err := newDBusTransport(o)
+		if err != nil {
+			slog.Warn("", "err", err)
+			return nil
+		}
+		dt.pids = ipcWayland
+		return dt
+	}
+	if xt, ok := t.(*xTransport); ok {
+		if xt.xu == nil || !xt.isXWayland() {
+			return nil
+		}
+		slog.Info("no Firefox X windows on this XWayland server; Firefox is probably running natively on Wayland, trying D-Bus")
+	} else if os.Getenv("WAYLAND_DISPLAY") != "" {
+		slog.Info("no X server but we're on Wayland; trying D-Bus")
+	} else {
+		return nil
+	}
+	dt, err := newDBusTransport(o)
+	if err != nil {
+		slog.Warn("", "err", err)
+		return nil
+	}
+	return dt
+}
@@ -0,0 +1,108 @@
+package main
+
+// Decoding _MOZILLA_COMMANDLINE values, for 'ffox-remote decode'.
+//
+// People generally get these values from xprop, which prints 8-bit
+// STRING properties as a C-style string with backslash escapes for
+// everything unprintable:
+//
+//	_MOZILLA_COMMANDLINE(STRING) = "\002\000\000\000\020\000..."
+//
+// so we accept that as well as the raw bytes.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+
+	"github.com/siebenmann/ffox-remote/cmdline"
+)
+
+// unXprop extracts the property value from xprop output, if that's
+// what b is. Otherwise it returns b unchanged.
+func unXprop(b []byte) ([]byte, error) {
+	i := bytes.Index(b, []byte(`= "`))
+	if i < 0 || !bytes.HasPrefix(bytes.TrimSpace(b), []byte("_")) {
+		return b, nil
+	}
+	s := b[i+3:]
+	var out []byte
+	for len(s) > 0 {
+		c := s[0]
+		switch {
+		case c == '"':
+			return out, nil
+		case c != '\\':
+			out = append(out, c)
+			s = s[1:]
+		case len(s) >= 4 && s[1] >= '0' && s[1] <= '7':
+			n, e := strconv.ParseUint(string(s[1:4]), 8, 8)
+			if e != nil {
+				return nil, fmt.Errorf("bad octal escape %q", s[:4])
+			}
+			out = append(out, byte(n))
+			s = s[4:]
+		case len(s) >= 2:
+			switch s[1] {
+			case 'n':
+				out = append(out, '\n')
+			case 't':
+				out = append(out, '\t')
+			default:
+				out = append(out, s[1])
+			}
+			s = s[2:]
+		default:
+			s = s[1:]
+		}
+	}
+	return nil, errors.New("unterminated xprop string")
+}
+
+// decodeValue decodes and prints one command line value.
+func decodeValue(name string, b []byte) error {
+	b, err := unXprop(b)
+	if err != nil {
+		return fmt.Errorf("%s: %s", name, err)
+	}
+	pwd, args, err := cmdline.Decode(b)
+	if err != nil {
+		// Firefox would reject it, but it's useful to know
+		// if someone got the byte order wrong.
+		if _, _, e2 := cmdline.DecodeOrder(b, binary.BigEndian); e2 == nil {
+			return fmt.Errorf("%s: %s (but it decodes as big-endian, which is wrong)", name, err)
+		}
+		return fmt.Errorf("%s: %s", name, err)
+	}
+	fmt.Printf("cwd: %s\n", pwd)
+	for i, a := range args {
+		fmt.Printf("argv[%d]: %s\n", i, a)
+	}
+	return nil
+}
+
+// decodeFiles decodes the command lines in all of the files, or in
+// standard input if there are none.
+func decodeFiles(files []string) error {
+	if len(files) == 0 {
+		b, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		return decodeValue("<stdin>", b)
+	}
+	for _, fn := range files {
+		b, err := ioutil.ReadFile(fn)
+		if err != nil {
+			return err
+		}
+		if err = decodeValue(fn, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
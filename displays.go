@@ -0,0 +1,45 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+// Finding all of the local X displays, for -scan-displays.
+//
+// Every local X server has a socket in /tmp/.X11-unix named 'X<N>'
+// for display :N. Not all of them will be ones we can talk to (they
+// may belong to other people and we won't be able to authenticate),
+// but that's fine; we just skip the ones we can't connect to.
+
+import (
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const x11SocketDir = "/tmp/.X11-unix"
+
+// localDisplays returns the local X displays, in numeric order.
+func localDisplays() []string {
+	fis, e := ioutil.ReadDir(x11SocketDir)
+	if e != nil {
+		return nil
+	}
+	var nums []int
+	for _, fi := range fis {
+		if !strings.HasPrefix(fi.Name(), "X") {
+			continue
+		}
+		n, e := strconv.Atoi(fi.Name()[1:])
+		if e != nil {
+			continue
+		}
+		nums = append(nums, n)
+	}
+	sort.Ints(nums)
+	var displays []string
+	for _, n := range nums {
+		displays = append(displays, ":"+strconv.Itoa(n))
+	}
+	return displays
+}
@@ -0,0 +1,72 @@
+package main
+
+// Showing what we would send, for -dry-run.
+//
+// With -dry-run we do everything up to actually talking to Firefox:
+// we find it, build the command line (running any pre-hook, since it
+// can change the command line), and encode it. Then instead of
+// locking Firefox and setting properties, we print where it would go
+// and the exact bytes, as a hexdump and decoded again, so that you
+// can see what happens to your arguments on the way. Nothing is
+// recorded, audited, confirmed, or passed to a post-hook.
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/siebenmann/ffox-remote/cmdline"
+)
+
+// A wireMsg is one thing that a transport would send to Firefox:
+// where it goes (a property or a D-Bus method) and its exact bytes.
+// If cmdline is set, it's an encoded _MOZILLA_COMMANDLINE command
+// line; otherwise it's a legacy command.
+type wireMsg struct {
+	where   string
+	data    []byte
+	cmdline bool
+}
+
+// An encoder is a transport that can say exactly what it would send.
+type encoder interface {
+	encode(cwd string, args []string) ([]wireMsg, error)
+}
+
+// dryRun prints what sending args through t would send.
+func dryRun(t transport, cwd string, args []string) error {
+	if tg, ok := t.(targeter); ok {
+		fmt.Printf("target: %s\n", tg.target())
+	}
+	e, ok := t.(encoder)
+	if !ok {
+		fmt.Printf("cwd: %s\n", cwd)
+		for i, a := range args {
+			fmt.Printf("argv[%d]: %s\n", i, a)
+		}
+		fmt.Printf("(this transport doesn't say how it encodes command lines)\n")
+		return nil
+	}
+	msgs, err := e.encode(cwd, args)
+	if err != nil {
+		return err
+	}
+	for i, m := range msgs {
+		if len(msgs) > 1 {
+			fmt.Printf("message %d of %d:\n", i+1, len(msgs))
+		}
+		fmt.Printf("%s (%d bytes):\n%s", m.where, len(m.data), hex.Dump(m.data))
+		if !m.cmdline {
+			fmt.Printf("legacy command: %s\n", m.data)
+			continue
+		}
+		pwd, dargs, err := cmdline.Decode(m.data)
+		if err != nil {
+			return fmt.Errorf("our own encoding doesn't decode: %s", err)
+		}
+		fmt.Printf("cwd: %s\n", pwd)
+		for j, a := range dargs {
+			fmt.Printf("argv[%d]: %s\n", j, a)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+// Debugging tools for the protocol.
+//
+// -dump prints all of the remote control properties on a Firefox
+// window. This is what you'd otherwise get by running xprop on the
+// window and picking through its output, except that we decode the
+// command line (if it's still there) and only show the properties
+// that matter.
+//
+// -set-prop NAME=VALUE sets (or with an empty VALUE, deletes) a
+// property on the window, for experimenting; for example, you can
+// plant a lock to see what another client does, or clear a stale
+// response. Since it's easy to confuse Firefox this way, we only
+// touch properties with our property prefix unless you also give
+// -force.
+//
+// 'ffox-remote unlock' deletes the lock property, after telling you
+// who had it. This is the cure for a client that died holding the
+// lock, which otherwise leaves every other client waiting for it
+// (or stealing it, with -steal-lock). Before this, the only way to
+// get rid of a stale lock was to send something with -force.
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/BurntSushi/xgb/xproto"
+	"github.com/BurntSushi/xgbutil/xprop"
+	"github.com/siebenmann/ffox-remote/cmdline"
+)
+
+// dump prints every property on the Firefox window that has our
+// property prefix, with both its raw value and what it means.
+func (t *xTransport) dump() error {
+	xu, win := t.xu, t.win
+	props, err := xproto.ListProperties(xu.Conn(), win).Reply()
+	if err != nil {
+		return err
+	}
+	pfix := strings.TrimSuffix(versProp, "VERSION")
+	var names []string
+	for _, a := range props.Atoms {
+		n, err := xprop.AtomName(xu, a)
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(n, pfix) {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+
+	fmt.Printf("window 0x%x:\n", win)
+	for _, n := range names {
+		p, err := getProp(liveX{xu}, win, n)
+		if err != nil {
+			fmt.Printf("%s: %s\n", n, err)
+			continue
+		}
+		fmt.Printf("%s: %s\n", n, strconv.Quote(string(p.Value)))
+		switch n {
+		case versProp:
+			fmt.Printf("\tprotocol: %s\n", protoClassName(protoClass(string(p.Value))))
+		case lockProp:
+			fmt.Printf("\tlocked by: %s\n", p.Value)
+		case respProp:
+			fmt.Printf("\tlast response: %s\n", p.Value)
+		case cmdlProp:
+			pwd, args, err := cmdline.Decode(p.Value)
+			if err != nil {
+				fmt.Printf("\tundecodable: %s\n", err)
+				break
+			}
+			fmt.Printf("\tcwd: %s\n", pwd)
+			for i, a := range args {
+				fmt.Printf("\targv[%d]: %s\n", i, a)
+			}
+		}
+	}
+	if !contains(names, lockProp) {
+		fmt.Printf("%s: not set\n\tunlocked\n", lockProp)
+	}
+	return nil
+}
+
+// setProp sets the property name on the Firefox window to value, or
+// deletes it if value is empty.
+func (t *xTransport) setProp(name, value string) error {
+	if err := t.checkOwned(); err != nil {
+		return err
+	}
+	return setWindowProp(liveX{t.xu}, t.win, name, value, t.o)
+}
+
+// setWindowProp is setProp for any window. With -dry-run, we only
+// say what we would do.
+func setWindowProp(xc xConn, win xproto.Window, name, value string, o *options) error {
+	pfix := strings.TrimSuffix(versProp, "VERSION")
+	if !strings.HasPrefix(name, pfix) && !o.force {
+		return fmt.Errorf("%s doesn't start with %s (use -force to set it anyway)", name, pfix)
+	}
+	if name == pfix {
+		return errors.New("no property name given")
+	}
+	switch {
+	case o.dryRun && value == "":
+		fmt.Printf("window 0x%x: would delete %s\n", win, name)
+		return nil
+	case o.dryRun:
+		fmt.Printf("window 0x%x: would set %s to %q\n", win, name, value)
+		return nil
+	}
+	a, err := xc.atom(name)
+	if err != nil {
+		return err
+	}
+	if value == "" {
+		xc.deleteProperty(win, a)
+		xc.sync()
+		return nil
+	}
+	return xc.changeProperty(xproto.PropModeReplace, win, a, xproto.AtomString, []byte(value))
+}
+
+// unlock prints who holds the lock on the Firefox window, if anyone,
+// and deletes it.
+func (t *xTransport) unlock() error {
+	if err := t.checkOwned(); err != nil {
+		return err
+	}
+	return unlockWindow(liveX{t.xu}, t.win, t.o)
+}
+
+// unlockWindow is unlock for any window. We check and delete the
+// lock with the server grabbed so that we can't delete a lock that
+// someone else has just taken. With -dry-run, we only say who has
+// the lock.
+func unlockWindow(xc xConn, win xproto.Window, o *options) error {
+	xc.grab()
+	holder := propValue(xc, win, lockProp)
+	if holder != "" && !o.dryRun {
+		xc.deleteProperty(win, lockatom)
+	}
+	xc.ungrab()
+	xc.sync()
+	if holder == "" {
+		fmt.Printf("window 0x%x: not locked\n", win)
+		return nil
+	}
+	fmt.Printf("window 0x%x: locked by %s%s\n", win, holder, lockHolderState(holder))
+	if o.dryRun {
+		fmt.Printf("window 0x%x: would unlock\n", win)
+		return nil
+	}
+	protoLog("deleted someone else's lock", "window", hexWin(win), "holder", holder)
+	fmt.Printf("window 0x%x: unlocked\n", win)
+	return nil
+}
+
+// lockHolderState says whether the process holding a lock still
+// exists, if the lock is in the usual '[program] PID@HOST' form and
+// HOST is this host. Otherwise it returns "".
+func lockHolderState(holder string) string {
+	f := strings.Fields(holder)
+	if len(f) == 0 {
+		return ""
+	}
+	ph := strings.SplitN(f[len(f)-1], "@", 2)
+	if len(ph) != 2 {
+		return ""
+	}
+	pid, err := strconv.Atoi(ph[0])
+	host, _ := os.Hostname()
+	if err != nil || pid <= 0 || ph[1] != host {
+		return ""
+	}
+	if err := syscall.Kill(pid, 0); err == syscall.ESRCH {
+		return fmt.Sprintf(" (process %d is gone)", pid)
+	}
+	return fmt.Sprintf(" (process %d is still running)", pid)
+}
+
+// protoClassName describes a protocol class from protoClass.
+func protoClassName(c int) string {
+	switch c {
+	case protoCurrent:
+		return "current"
+	case protoNewer:
+		return "different but probably compatible"
+	case protoLegacy:
+		return "legacy _MOZILLA_COMMAND"
+	}
+	return "unknown"
+}
+
+// contains reports whether s is in l.
+func contains(l []string, s string) bool {
+	for _, e := range l {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
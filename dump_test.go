@@ -0,0 +1,76 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/BurntSushi/xgb/xproto"
+)
+
+// snapshot returns every property of every window on fx.
+func snapshot(fx *fakeX) map[xproto.Window]map[xproto.Atom]string {
+	fx.mu.Lock()
+	defer fx.mu.Unlock()
+	res := make(map[xproto.Window]map[xproto.Atom]string)
+	for win, w := range fx.wins {
+		props := make(map[xproto.Atom]string)
+		for a, p := range w.props {
+			props[a] = string(p.value)
+		}
+		res[win] = props
+	}
+	return res
+}
+
+// With -dry-run, -set-prop and unlock only say what they would do.
+func TestDryRunLeavesFirefoxAlone(t *testing.T) {
+	fx, win, _ := setupFake(t)
+	fx.setProp(win, lockProp, "other 1234@host")
+	before := snapshot(fx)
+
+	o := testOptions()
+	o.dryRun = true
+	if err := setWindowProp(fx, win, userProp, "fred", o); err != nil {
+		t.Fatal(err)
+	}
+	if err := setWindowProp(fx, win, lockProp, "", o); err != nil {
+		t.Fatal(err)
+	}
+	if err := unlockWindow(fx, win, o); err != nil {
+		t.Fatal(err)
+	}
+	if after := snapshot(fx); !reflect.DeepEqual(before, after) {
+		t.Errorf("-dry-run changed the X server:\n%v\nto\n%v", before, after)
+	}
+
+	// And without -dry-run, they do.
+	o.dryRun = false
+	if err := setWindowProp(fx, win, userProp, "fred", o); err != nil {
+		t.Fatal(err)
+	}
+	if v := propValue(fx, win, userProp); v != "fred" {
+		t.Errorf("-set-prop set %s to %q", userProp, v)
+	}
+	if err := unlockWindow(fx, win, o); err != nil {
+		t.Fatal(err)
+	}
+	if hasProp(fx, win, lockProp) {
+		t.Errorf("unlock left the lock")
+	}
+}
+
+// -set-prop won't touch other people's properties without -force,
+// with or without -dry-run.
+func TestSetPropPrefix(t *testing.T) {
+	fx, win, _ := setupFake(t)
+	for _, dry := range []bool{false, true} {
+		o := testOptions()
+		o.dryRun = dry
+		if err := setWindowProp(fx, win, "WM_NAME", "x", o); err == nil {
+			t.Errorf("-dry-run %v: set WM_NAME without -force", dry)
+		}
+	}
+}
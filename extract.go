@@ -0,0 +1,78 @@
+package main
+
+// Pulling URLs out of arbitrary text, for -extract.
+//
+// We look for things that are obviously URLs: http, https, and ftp
+// URLs, anything in angle brackets that has a scheme (including the
+// old '<URL:...>' form), and bare 'www.' host names. Bare URLs in
+// running text often have punctuation stuck on the end, so we trim
+// that off, along with a closing ')' that doesn't have a matching
+// '(' in the URL (for '(see https://example.org/)').
+
+import (
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+// urlPattern matches either a URL in angle brackets (the URL is the
+// first submatch) or a bare URL (the whole match). Since the angle
+// bracket alternative starts earlier, it wins when both could match.
+var urlPattern = regexp.MustCompile(`<(?:URL:)?\s*([a-zA-Z][a-zA-Z0-9+.-]*://[^>\s]+)\s*>|` +
+	`(?i)\b(?:(?:https?|ftp)://|www\.)[^\s<>"'{}|\\^\[\]` + "`" + `]+`)
+
+// trimURL trims trailing punctuation from a bare URL.
+func trimURL(u string) string {
+	for len(u) > 0 {
+		last := u[len(u)-1]
+		switch {
+		case strings.IndexByte(".,;:!?*", last) >= 0:
+			u = u[:len(u)-1]
+		case last == ')' && strings.Count(u, "(") < strings.Count(u, ")"):
+			u = u[:len(u)-1]
+		default:
+			return u
+		}
+	}
+	return u
+}
+
+// extractURLs returns all of the URLs in text, in order and without
+// duplicates.
+func extractURLs(text string) []string {
+	var urls []string
+	seen := make(map[string]bool)
+	add := func(u string) {
+		if strings.HasPrefix(strings.ToLower(u), "www.") {
+			u = "http://" + u
+		}
+		if u != "" && !seen[u] {
+			seen[u] = true
+			urls = append(urls, u)
+		}
+	}
+	for _, m := range urlPattern.FindAllStringSubmatch(text, -1) {
+		if m[1] != "" {
+			add(m[1])
+		} else {
+			add(trimURL(m[0]))
+		}
+	}
+	return urls
+}
+
+// readURLs reads all of r and returns the URLs in it. If clean is
+// set, we first turn terminal hyperlinks into their URLs (see
+// clean.go).
+func readURLs(r io.Reader, clean bool) ([]string, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	text := string(b)
+	if clean {
+		text = unOSC8(text)
+	}
+	return extractURLs(text), nil
+}
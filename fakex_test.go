@@ -0,0 +1,382 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+// An X server in memory, for exercising the protocol code.
+//
+// A fakeX is an xConn (see xconn.go) that keeps windows and their
+// properties in maps instead of talking to a real X server. It's
+// enough of an X server to find Firefox windows, take and release
+// the lock, and send command lines: it has a window tree, atoms,
+// properties (including reading them in pieces and deleting them as
+// they're read), and PropertyNotify and DestroyNotify events for
+// windows that are being listened to. It doesn't have anything else.
+// Other clients are stood in for by the onChange hook, which runs
+// after every property change; grabbing the server holds the hook
+// off until the grab is released, just as a real grab holds off
+// other clients.
+//
+// addFirefox makes a window that looks like a Firefox one, and
+// answerAs makes the fake respond to command lines the way Firefox
+// would, so that submitCommand can get a response without a real
+// Firefox. Something that wants to act as another client (to hold
+// the lock, say) can use setProp and deleteProperty directly.
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/siebenmann/ffox-remote/cmdline"
+
+	"github.com/BurntSushi/xgb"
+	"github.com/BurntSushi/xgb/xproto"
+)
+
+// fakeMaxChange is how much one changeProperty can set on a fakeX.
+// It's small so that long command lines get set in pieces.
+const fakeMaxChange = 1024
+
+// A fakeProp is the value of a property on a fake window.
+type fakeProp struct {
+	typ   xproto.Atom
+	value []byte
+}
+
+// A fakeWindow is a window on a fakeX.
+type fakeWindow struct {
+	parent    xproto.Window
+	children  []xproto.Window
+	props     map[xproto.Atom]fakeProp
+	listening bool
+}
+
+// fakeX is an X server in memory. onChange, if set, is called after
+// every property change (with the fakeX unlocked, so it can change
+// things itself), or when the server is ungrabbed if it's grabbed.
+type fakeX struct {
+	mu       sync.Mutex
+	grabbed  bool
+	pending  []func()
+	atoms    map[string]xproto.Atom
+	names    map[xproto.Atom]string
+	wins     map[xproto.Window]*fakeWindow
+	rootWin  xproto.Window
+	nextWin  xproto.Window
+	evs      chan xgb.Event
+	onChange func(win xproto.Window, prop string)
+}
+
+// newFakeX returns a fakeX with only a root window.
+func newFakeX() *fakeX {
+	fx := &fakeX{
+		atoms:   make(map[string]xproto.Atom),
+		names:   make(map[xproto.Atom]string),
+		wins:    make(map[xproto.Window]*fakeWindow),
+		rootWin: 0x100,
+		nextWin: 0x200000,
+		evs:     make(chan xgb.Event, 1024),
+	}
+	fx.wins[fx.rootWin] = &fakeWindow{props: make(map[xproto.Atom]fakeProp)}
+	return fx
+}
+
+// atomLocked is atom with fx.mu held.
+func (fx *fakeX) atomLocked(name string) xproto.Atom {
+	if a, ok := fx.atoms[name]; ok {
+		return a
+	}
+	a := xproto.Atom(len(fx.atoms) + 1)
+	fx.atoms[name] = a
+	fx.names[a] = name
+	return a
+}
+
+func (fx *fakeX) atom(name string) (xproto.Atom, error) {
+	fx.mu.Lock()
+	defer fx.mu.Unlock()
+	return fx.atomLocked(name), nil
+}
+
+func (fx *fakeX) atomName(a xproto.Atom) (string, error) {
+	fx.mu.Lock()
+	defer fx.mu.Unlock()
+	if n, ok := fx.names[a]; ok {
+		return n, nil
+	}
+	return "", xproto.AtomError{BadValue: uint32(a)}
+}
+
+func (fx *fakeX) internAtoms(names []string) {
+	fx.mu.Lock()
+	defer fx.mu.Unlock()
+	for _, n := range names {
+		fx.atomLocked(n)
+	}
+}
+
+func (fx *fakeX) root() xproto.Window {
+	return fx.rootWin
+}
+
+// window returns win, or the BadWindow error that the X server would
+// give us if it doesn't exist. It's called with fx.mu held.
+func (fx *fakeX) window(win xproto.Window) (*fakeWindow, error) {
+	w, ok := fx.wins[win]
+	if !ok {
+		return nil, xproto.WindowError{BadValue: uint32(win)}
+	}
+	return w, nil
+}
+
+// Replies are worked out right away, since that's when the X server
+// would handle the request.
+func (fx *fakeX) queryTree(win xproto.Window) func() (*xproto.QueryTreeReply, error) {
+	fx.mu.Lock()
+	defer fx.mu.Unlock()
+	w, err := fx.window(win)
+	if err != nil {
+		return func() (*xproto.QueryTreeReply, error) { return nil, err }
+	}
+	r := &xproto.QueryTreeReply{Root: fx.rootWin, Parent: w.parent,
+		Children: append([]xproto.Window(nil), w.children...)}
+	r.ChildrenLen = uint16(len(r.Children))
+	return func() (*xproto.QueryTreeReply, error) { return r, nil }
+}
+
+func (fx *fakeX) getProperty(win xproto.Window, a xproto.Atom, del bool, offset, length uint32) func() (*xproto.GetPropertyReply, error) {
+	r, err := fx.readProperty(win, a, del, offset, length)
+	return func() (*xproto.GetPropertyReply, error) { return r, err }
+}
+
+// readProperty does the work of getProperty.
+func (fx *fakeX) readProperty(win xproto.Window, a xproto.Atom, del bool, offset, length uint32) (*xproto.GetPropertyReply, error) {
+	fx.mu.Lock()
+	w, err := fx.window(win)
+	if err != nil {
+		fx.mu.Unlock()
+		return nil, err
+	}
+	p, ok := w.props[a]
+	if !ok {
+		fx.mu.Unlock()
+		return &xproto.GetPropertyReply{}, nil
+	}
+	start := int(offset) * 4
+	if start > len(p.value) {
+		fx.mu.Unlock()
+		return nil, xproto.ValueError{BadValue: offset}
+	}
+	end := start + int(length)*4
+	if end > len(p.value) {
+		end = len(p.value)
+	}
+	r := &xproto.GetPropertyReply{Format: 8, Type: p.typ,
+		BytesAfter: uint32(len(p.value) - end),
+		ValueLen:   uint32(end - start),
+		Value:      append([]byte(nil), p.value[start:end]...)}
+	var evs []xgb.Event
+	if del && r.BytesAfter == 0 {
+		delete(w.props, a)
+		evs = fx.notify(win, w, a, xproto.PropertyDelete)
+	}
+	fx.mu.Unlock()
+	fx.send(evs)
+	return r, nil
+}
+
+func (fx *fakeX) changeProperty(mode byte, win xproto.Window, a, typ xproto.Atom, data []byte) error {
+	fx.mu.Lock()
+	w, err := fx.window(win)
+	if err != nil {
+		fx.mu.Unlock()
+		return err
+	}
+	p := w.props[a]
+	if mode == xproto.PropModeAppend {
+		p.value = append(p.value, data...)
+	} else {
+		p.value = append([]byte(nil), data...)
+	}
+	p.typ = typ
+	w.props[a] = p
+	evs := fx.notify(win, w, a, xproto.PropertyNewValue)
+	var run func()
+	if hook, name := fx.onChange, fx.names[a]; hook != nil {
+		run = func() { hook(win, name) }
+		if fx.grabbed {
+			fx.pending = append(fx.pending, run)
+			run = nil
+		}
+	}
+	fx.mu.Unlock()
+	fx.send(evs)
+	if run != nil {
+		run()
+	}
+	return nil
+}
+
+func (fx *fakeX) deleteProperty(win xproto.Window, a xproto.Atom) {
+	fx.mu.Lock()
+	var evs []xgb.Event
+	if w, err := fx.window(win); err == nil {
+		if _, ok := w.props[a]; ok {
+			delete(w.props, a)
+			evs = fx.notify(win, w, a, xproto.PropertyDelete)
+		}
+	}
+	fx.mu.Unlock()
+	fx.send(evs)
+}
+
+func (fx *fakeX) maxChange() int {
+	return fakeMaxChange
+}
+
+func (fx *fakeX) listen(win xproto.Window) error {
+	fx.mu.Lock()
+	defer fx.mu.Unlock()
+	w, err := fx.window(win)
+	if err != nil {
+		return err
+	}
+	w.listening = true
+	return nil
+}
+
+func (fx *fakeX) events() chan xgb.Event {
+	return fx.evs
+}
+
+func (fx *fakeX) grab() {
+	fx.mu.Lock()
+	fx.grabbed = true
+	fx.mu.Unlock()
+}
+
+// ungrab runs the hooks that the grab held off.
+func (fx *fakeX) ungrab() {
+	fx.mu.Lock()
+	fx.grabbed = false
+	pending := fx.pending
+	fx.pending = nil
+	fx.mu.Unlock()
+	for _, run := range pending {
+		run()
+	}
+}
+
+func (fx *fakeX) sync() {}
+
+// notify returns the PropertyNotify event for a change to a on win,
+// if anyone is listening for it. It's called with fx.mu held; the
+// events are sent with send once it's been released.
+func (fx *fakeX) notify(win xproto.Window, w *fakeWindow, a xproto.Atom, state byte) []xgb.Event {
+	if !w.listening {
+		return nil
+	}
+	return []xgb.Event{xproto.PropertyNotifyEvent{Window: win, Atom: a, State: state}}
+}
+
+// send delivers events.
+func (fx *fakeX) send(evs []xgb.Event) {
+	for _, ev := range evs {
+		fx.evs <- ev
+	}
+}
+
+// addWindow creates a new window that's a child of parent and
+// returns it.
+func (fx *fakeX) addWindow(parent xproto.Window) xproto.Window {
+	fx.mu.Lock()
+	defer fx.mu.Unlock()
+	p, err := fx.window(parent)
+	if err != nil {
+		panic(fmt.Sprintf("fakeX: no parent window 0x%x", parent))
+	}
+	win := fx.nextWin
+	fx.nextWin++
+	fx.wins[win] = &fakeWindow{parent: parent, props: make(map[xproto.Atom]fakeProp)}
+	p.children = append(p.children, win)
+	return win
+}
+
+// destroyWindow destroys win and everything underneath it.
+func (fx *fakeX) destroyWindow(win xproto.Window) {
+	fx.mu.Lock()
+	var evs []xgb.Event
+	var destroy func(win xproto.Window)
+	destroy = func(win xproto.Window) {
+		w := fx.wins[win]
+		for _, c := range w.children {
+			destroy(c)
+		}
+		if w.listening {
+			evs = append(evs, xproto.DestroyNotifyEvent{Event: win, Window: win})
+		}
+		delete(fx.wins, win)
+	}
+	if w, ok := fx.wins[win]; ok && win != fx.rootWin {
+		destroy(win)
+		p := fx.wins[w.parent]
+		for i, c := range p.children {
+			if c == win {
+				p.children = append(p.children[:i], p.children[i+1:]...)
+				break
+			}
+		}
+	}
+	fx.mu.Unlock()
+	fx.send(evs)
+}
+
+// setProp sets the string property prop on win to val, as another
+// client would.
+func (fx *fakeX) setProp(win xproto.Window, prop, val string) {
+	a, _ := fx.atom(prop)
+	stype, _ := fx.atom("STRING")
+	if err := fx.changeProperty(xproto.PropModeReplace, win, a, stype, []byte(val)); err != nil {
+		panic(fmt.Sprintf("fakeX: setting %s: %s", prop, err))
+	}
+}
+
+// addFirefox creates what looks like a Firefox window, a frame under
+// the root window with a client window that has WM_STATE and the
+// identification properties, and returns the client window. Empty
+// values aren't set.
+func (fx *fakeX) addFirefox(user, profile, program string) xproto.Window {
+	frame := fx.addWindow(fx.rootWin)
+	win := fx.addWindow(frame)
+	fx.setProp(win, "WM_STATE", "\x01\x00\x00\x00\x00\x00\x00\x00")
+	fx.setProp(win, versProp, firefoxVersion)
+	for _, p := range []struct{ prop, val string }{{userProp, user}, {profProp, profile}, {progProp, program}} {
+		if p.val != "" {
+			fx.setProp(win, p.prop, p.val)
+		}
+	}
+	return win
+}
+
+// answerAs makes the fake answer command lines sent to win the way
+// Firefox does, by deleting the command line and setting the
+// response to resp. Each command line it answers is passed to got,
+// if that's not nil.
+func (fx *fakeX) answerAs(win xproto.Window, resp string, got func([]byte)) {
+	fx.onChange = func(w xproto.Window, prop string) {
+		if w != win || prop != cmdlProp {
+			return
+		}
+		// A command line set in pieces gets us here once for
+		// each piece, but Firefox (and we) only read it once.
+		a, _ := fx.atom(cmdlProp)
+		r, err := fx.readProperty(win, a, true, 0, cmdline.MaxLen)
+		if err != nil || r.Format == 0 {
+			return
+		}
+		if got != nil {
+			got(r.Value)
+		}
+		fx.setProp(win, respProp, resp)
+	}
+}
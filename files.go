@@ -0,0 +1,60 @@
+package main
+
+// Turning file name arguments into file: URLs.
+//
+// Firefox will open a file name argument itself, but current versions
+// resolve relative names against their own working directory, not
+// the one we send, and it doesn't understand a '#fragment' on the end
+// of a file name at all. So we turn arguments that are existing files
+// (possibly with a fragment, like './docs/page.html#section3') into
+// absolute file: URLs ourselves, keeping the fragment.
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// fileURL returns the file: URL for arg if it's the name of an
+// existing file (relative to cwd if it's relative), with any fragment
+// preserved. Otherwise it returns arg unchanged.
+func fileURL(cwd, arg string) string {
+	if arg == "" || strings.HasPrefix(arg, "-") {
+		return arg
+	}
+	name, frag := arg, ""
+	if i := strings.IndexByte(arg, '#'); i >= 0 {
+		name, frag = arg[:i], arg[i:]
+	}
+	if name == "" {
+		return arg
+	}
+	if !filepath.IsAbs(name) {
+		if cwd != "" {
+			name = filepath.Join(cwd, name)
+		} else if abs, e := filepath.Abs(name); e == nil {
+			name = abs
+		}
+	}
+	if _, e := os.Stat(name); e != nil {
+		return arg
+	}
+	p := filepath.ToSlash(name)
+	if !strings.HasPrefix(p, "/") {
+		// Windows drive letters
+		p = "/" + p
+	}
+	u := url.URL{Scheme: "file", Path: p}
+	return u.String() + frag
+}
+
+// fileArgs converts all of the file arguments in a command line (but
+// not args[0], the program) to file: URLs.
+func fileArgs(cwd string, args []string) []string {
+	nargs := []string{args[0]}
+	for _, a := range args[1:] {
+		nargs = append(nargs, fileURL(cwd, a))
+	}
+	return nargs
+}
@@ -0,0 +1,82 @@
+package main
+
+// Machine-readable -find output, for -find-format.
+//
+// Plain -find prints a few lines for people. Scripts that want to
+// know which Firefox we found (and then do different things for
+// different ones) get everything we know about it instead, either as
+// KEY=VALUE lines that are easy to pick apart in the shell or as a
+// JSON object. What keys there are depends on the transport; with X
+// they're window, display, user, profile, profile_path, program,
+// host, protocol, packaging, pid, title, geometry, and desktop, and
+// we leave out the ones we can't find out.
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A foundField is one thing that a transport knows about the Firefox
+// it found.
+type foundField struct {
+	key, value string
+}
+
+// A detailer is a transport that can report everything it knows about
+// the Firefox it found.
+type detailer interface {
+	details() []foundField
+}
+
+// checkFindFormat checks a -find-format value.
+func checkFindFormat(format string) error {
+	switch format {
+	case "kv", "json":
+		return nil
+	}
+	return fmt.Errorf("unknown format %q (we know kv and json)", format)
+}
+
+// printFound prints the details of the Firefox that t found in
+// format, which is "kv" or "json".
+func printFound(t transport, format string) error {
+	var fields []foundField
+	if d, ok := t.(detailer); ok {
+		fields = d.details()
+	} else if tg, ok := t.(targeter); ok {
+		fields = []foundField{{"target", tg.target()}}
+	}
+
+	if format == "json" {
+		m := make(map[string]string)
+		for _, f := range fields {
+			m[f.key] = f.value
+		}
+		b, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+		return nil
+	}
+	for _, f := range fields {
+		fmt.Printf("%s=%s\n", f.key, kvQuote(f.value))
+	}
+	return nil
+}
+
+// kvQuote quotes v Go style if it has anything in it that would make
+// a KEY=VALUE line hard to split, such as spaces.
+func kvQuote(v string) string {
+	if v == "" || strings.ContainsAny(v, "\"'\\") {
+		return strconv.Quote(v)
+	}
+	for _, r := range v {
+		if r == ' ' || !strconv.IsPrint(r) {
+			return strconv.Quote(v)
+		}
+	}
+	return v
+}
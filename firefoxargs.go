@@ -0,0 +1,149 @@
+package main
+
+// Taking Firefox's own arguments, so that we can stand in for it.
+//
+// Desktop files and scripts run 'firefox --new-tab URL' and the like.
+// If ffox-remote is run as 'firefox' (through a symlink or a copy
+// earlier in $PATH), or with the firefox subcommand, we take
+// Firefox's syntax instead of ours and translate it: both single and
+// double dash forms, '--option=value', options mixed in with the
+// URLs, and '--new-tab URL' with the URL belonging to the option. We
+// only understand the options that make sense for talking to a
+// running Firefox:
+//
+//	--new-tab [URL], --new-window [URL], --url URL
+//	--search TERM
+//	-P NAME, --profile PATH
+//	--display DISPLAY
+//	-remote COMMAND (see remote.go)
+//	--foreground and --browser, which we ignore
+//
+// Anything else (--no-remote, --private-window, and so on) is an
+// error, rather than something we quietly drop; those mean starting
+// a new Firefox, which we can't do. For the same reason, if there's
+// no Firefox running we fail instead of starting one, so don't put
+// us ahead of the real Firefox for whatever starts it in the first
+// place.
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// invokedAsFirefox reports whether we were run under the name of
+// Firefox (or one of the forks we look for).
+func invokedAsFirefox() bool {
+	name := strings.TrimSuffix(filepath.Base(os.Args[0]), ".exe")
+	for _, p := range forkPrograms {
+		if name == p {
+			return true
+		}
+	}
+	return false
+}
+
+// firefoxArgs translates Firefox's arguments into ours.
+func firefoxArgs(argv []string) ([]string, error) {
+	var opts, urls []string
+	where, term := "", ""
+	search := false
+	setWhere := func(w string) error {
+		if where != "" && where != w {
+			return fmt.Errorf("can't use both -%s and -%s", where, w)
+		}
+		where = w
+		return nil
+	}
+	for i := 0; i < len(argv); i++ {
+		a := argv[i]
+		if a == "--" {
+			urls = append(urls, argv[i+1:]...)
+			break
+		}
+		if !strings.HasPrefix(a, "-") || a == "-" {
+			urls = append(urls, a)
+			continue
+		}
+		name := strings.TrimPrefix(strings.TrimPrefix(a, "-"), "-")
+		val, hasVal := "", false
+		if j := strings.IndexByte(name, '='); j >= 0 {
+			name, val, hasVal = name[:j], name[j+1:], true
+		}
+		// value gets the option's value, which is required;
+		// optValue gets it only if there is one.
+		value := func() (string, error) {
+			if hasVal {
+				return val, nil
+			}
+			if i+1 >= len(argv) {
+				return "", fmt.Errorf("-%s needs a value", name)
+			}
+			i++
+			return argv[i], nil
+		}
+		optValue := func() string {
+			if hasVal {
+				return val
+			}
+			if i+1 < len(argv) && !strings.HasPrefix(argv[i+1], "-") {
+				i++
+				return argv[i]
+			}
+			return ""
+		}
+
+		switch name {
+		case "new-tab", "new-window":
+			if err := setWhere(name); err != nil {
+				return nil, err
+			}
+			if u := optValue(); u != "" {
+				urls = append(urls, u)
+			}
+		case "url":
+			u, err := value()
+			if err != nil {
+				return nil, err
+			}
+			urls = append(urls, u)
+		case "search":
+			t, err := value()
+			if err != nil {
+				return nil, err
+			}
+			search, term = true, t
+		case "P", "profile":
+			// Without a name, -P is Firefox's profile
+			// manager.
+			p := optValue()
+			if p == "" {
+				return nil, fmt.Errorf("-%s needs a profile", name)
+			}
+			opts = append(opts, "-P", p)
+		case "display", "remote":
+			v, err := value()
+			if err != nil {
+				return nil, err
+			}
+			opts = append(opts, "-"+name, v)
+		case "foreground", "browser":
+		default:
+			return nil, fmt.Errorf("the Firefox option -%s isn't something we can do with a running Firefox", name)
+		}
+	}
+
+	switch {
+	case search && where != "":
+		return nil, fmt.Errorf("can't use both -search and -%s", where)
+	case search && len(urls) > 0:
+		return nil, fmt.Errorf("can't search and open URLs at the same time")
+	case search:
+		opts = append(opts, "-search")
+		urls = []string{term}
+	case where != "":
+		opts = append(opts, "-"+where)
+	}
+	return append(append(opts, "--"), urls...), nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestFirefoxArgs(t *testing.T) {
+	for _, c := range []struct {
+		in   []string
+		want []string // nil for an error
+	}{
+		{[]string{"https://a/"}, []string{"--", "https://a/"}},
+		{[]string{"--new-tab", "https://a/"}, []string{"-new-tab", "--", "https://a/"}},
+		{[]string{"-new-tab", "https://a/", "https://b/"}, []string{"-new-tab", "--", "https://a/", "https://b/"}},
+		{[]string{"--new-tab"}, []string{"-new-tab", "--"}},
+		{[]string{"--new-tab", "--new-tab", "https://a/"}, []string{"-new-tab", "--", "https://a/"}},
+		{[]string{"--new-window=https://a/"}, []string{"-new-window", "--", "https://a/"}},
+		{[]string{"--url=https://a/", "https://b/"}, []string{"--", "https://a/", "https://b/"}},
+		{[]string{"-url", "https://a/"}, []string{"--", "https://a/"}},
+		{[]string{"--search", "two words"}, []string{"-search", "--", "two words"}},
+		{[]string{"--search=two words"}, []string{"-search", "--", "two words"}},
+		{[]string{"-P", "work", "https://a/"}, []string{"-P", "work", "--", "https://a/"}},
+		{[]string{"--profile=/home/cks/p", "https://a/"}, []string{"-P", "/home/cks/p", "--", "https://a/"}},
+		{[]string{"--display", ":1", "https://a/"}, []string{"-display", ":1", "--", "https://a/"}},
+		{[]string{"-remote", "openURL(https://a/)"}, []string{"-remote", "openURL(https://a/)", "--"}},
+		{[]string{"--foreground", "--browser", "https://a/"}, []string{"--", "https://a/"}},
+		{[]string{"--", "-new-tab", "--x"}, []string{"--", "-new-tab", "--x"}},
+		{[]string{"-new-window", "--", "-x"}, []string{"-new-window", "--", "-x"}},
+		{[]string{"-"}, []string{"--", "-"}},
+		{nil, []string{"--"}},
+
+		// -P with no name is the profile manager.
+		{[]string{"-P"}, nil},
+		{[]string{"-P", "-new-tab"}, nil},
+		{[]string{"--url"}, nil},
+		{[]string{"--search"}, nil},
+		{[]string{"--private-window", "https://a/"}, nil},
+		{[]string{"--no-remote"}, nil},
+		{[]string{"--new-tab", "https://a/", "--new-window", "https://b/"}, nil},
+		{[]string{"--search", "x", "https://a/"}, nil},
+		{[]string{"--search", "x", "--new-tab"}, nil},
+	} {
+		got, err := firefoxArgs(c.in)
+		switch {
+		case c.want == nil && err == nil:
+			t.Errorf("firefoxArgs(%q) = %q, want an error", c.in, got)
+		case c.want != nil && err != nil:
+			t.Errorf("firefoxArgs(%q): %s", c.in, err)
+		case c.want != nil && fmt.Sprintf("%q", got) != fmt.Sprintf("%q", c.want):
+			t.Errorf("firefoxArgs(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+// Firefox forks and non-release builds.
+//
+// The _MOZILLA_PROGRAM property is the browser's 'remoting name',
+// which is 'firefox' for release Firefox but something else for
+// various other builds and forks of it. They all speak the same
+// remote control protocol, so if you didn't say what program you
+// want with -G, we try all of the ones we know about.
+
+// forkPrograms is the list of program names we try by default, in
+// order of preference. Real Firefox comes first so that people with
+// both it and a fork running get what they always got.
+var forkPrograms = []string{
+	"firefox",
+	"firefox-esr",
+	"firefox-beta",
+	"firefox-aurora", // Developer Edition
+	"firefox-nightly",
+	"firefox-trunk", // Ubuntu's Nightly packages
+	"librewolf",
+	"icecat",
+	"waterfox",
+	"iceweasel",
+	"floorp",
+}
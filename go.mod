@@ -1,8 +1,9 @@
 module github.com/siebenmann/ffox-remote
 
-go 1.15
+go 1.21
 
 require (
 	github.com/BurntSushi/xgb v0.0.0-20201008132610-5f9e7b3c49cd
 	github.com/BurntSushi/xgbutil v0.0.0-20190907113008-ad855c713046
+	github.com/godbus/dbus/v5 v5.1.0
 )
@@ -0,0 +1,51 @@
+package main
+
+// The history file and 'ffox-remote history'.
+//
+// If you turn on the history file with -history or in the
+// configuration file, every command line we send is recorded in it
+// the same way that -record does (see record.go), including where we
+// sent it and what Firefox said. 'ffox-remote history' lists the
+// entries, numbered from the start of the file, and 'ffox-remote
+// history -pick N,...' re-sends some of them. Negative numbers count
+// back from the end, so '-pick -1' re-sends the last thing you sent.
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// listHistory prints the history records, numbered for pickRecords.
+func listHistory(recs []record) {
+	for i, rec := range recs {
+		what := strings.Join(rec.Args[1:], " ")
+		if rec.Error != "" {
+			what += "  [" + rec.Error + "]"
+		} else if parseResponse(rec.Response).failed() {
+			what += "  [" + rec.Response + "]"
+		}
+		target := rec.Target
+		if target == "" {
+			target = "-"
+		}
+		fmt.Printf("%4d  %s  %s  %s\n", i+1, rec.Time.Local().Format("2006-01-02 15:04"), target, what)
+	}
+}
+
+// pickRecords returns the records numbered in pick, in the order
+// given.
+func pickRecords(recs []record, pick []string) ([]record, error) {
+	var picked []record
+	for _, p := range pick {
+		n, err := strconv.Atoi(strings.TrimSpace(p))
+		if err == nil && n < 0 {
+			n += len(recs) + 1
+		}
+		if err != nil || n < 1 || n > len(recs) {
+			return nil, fmt.Errorf("no history entry %q", p)
+		}
+		picked = append(picked, recs[n-1])
+	}
+	return picked, nil
+}
@@ -0,0 +1,119 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+// Holding the lock to see what happens, for -hold-lock.
+//
+// We take the lock the normal way and then sit on it, reporting
+// everything that happens to the remote control properties while we
+// have it. Well behaved clients (Firefox, us, and Mozilla's old
+// remote client) that want the lock are invisible, since all they
+// do is wait for us to release it; what we can see is clients that
+// don't wait. Someone taking the lock over (as -steal-lock does) or
+// deleting it (as -force does when it's done) changes the lock
+// property, and someone who doesn't bother with the lock at all just
+// sets the command line, which Firefox will then act on even though
+// we're holding the lock. We don't fight back; we just report.
+//
+// We release the lock when the time is up or we're interrupted, if
+// it's still ours.
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/BurntSushi/xgb/xproto"
+)
+
+// holdLock takes the lock on the Firefox window and holds it for d,
+// or until we're interrupted, reporting on any activity.
+func (t *xTransport) holdLock(ctx context.Context, d time.Duration) error {
+	xu, win := t.xu, t.win
+	xc := liveX{xu}
+	if err := t.checkOwned(); err != nil {
+		return err
+	}
+	if err := lockFirefox(ctx, xc, win, t.o.lockWait, t.o.stealLock); err != nil {
+		return err
+	}
+	start := time.Now()
+	stamp := func() string {
+		return time.Now().Format("15:04:05.000")
+	}
+	fmt.Printf("%s holding the lock on window 0x%x for %v\n", stamp(), win, d)
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	tmo := time.NewTimer(d)
+	defer tmo.Stop()
+
+	cmdlatom := getAtom(xc, cmdlProp)
+	cmdatom := getAtom(xc, cmdProp)
+	events := xevents(xu)
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				return errWindowGone
+			}
+			switch e := ev.(type) {
+			case xproto.PropertyNotifyEvent:
+				if e.Window != win || e.State == xproto.PropertyDelete && e.Atom != lockatom {
+					continue
+				}
+				switch e.Atom {
+				case lockatom:
+					v := propValue(xc, win, lockProp)
+					switch {
+					case e.State == xproto.PropertyDelete:
+						fmt.Printf("%s someone deleted our lock\n", stamp())
+					case v != lockValue:
+						fmt.Printf("%s lock taken over by: %s\n", stamp(), v)
+					}
+				case cmdlatom:
+					reportCommandLine(xu, win, stamp()+" without the lock:")
+				case cmdatom:
+					fmt.Printf("%s without the lock: legacy command: %s\n", stamp(), propValue(xc, win, cmdProp))
+				case responseatom:
+					fmt.Printf("%s response: %s\n", stamp(), propValue(xc, win, respProp))
+				}
+			case xproto.DestroyNotifyEvent:
+				if e.Window == win {
+					fmt.Printf("%s window went away\n", stamp())
+					return nil
+				}
+			}
+		case <-sigs:
+			fmt.Printf("%s interrupted\n", stamp())
+			return t.releaseHeldLock(start)
+		case <-tmo.C:
+			return t.releaseHeldLock(start)
+		}
+	}
+}
+
+// releaseHeldLock releases the lock for holdLock if we still have it.
+func (t *xTransport) releaseHeldLock(start time.Time) error {
+	xu, win := t.xu, t.win
+	xc := liveX{xu}
+	xu.Grab()
+	held := holdingLock(xc, win)
+	if held {
+		unlockFirefox(xc, win)
+	}
+	xu.Ungrab()
+	xu.Sync()
+	heldFor := time.Since(start).Round(time.Millisecond)
+	if held {
+		fmt.Printf("%s released the lock after %v\n", time.Now().Format("15:04:05.000"), heldFor)
+	} else {
+		fmt.Printf("%s the lock wasn't ours any more after %v\n", time.Now().Format("15:04:05.000"), heldFor)
+	}
+	return nil
+}
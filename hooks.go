@@ -0,0 +1,85 @@
+package main
+
+// Hook commands run before and after we send a command line.
+//
+// The configuration file can set a pre-hook and a post-hook, either
+// globally or for an instance:
+//
+//	pre-hook = ~/bin/ffox-filter
+//	post-hook = notify-send "ffox-remote" "$FFOX_RESPONSE"
+//
+// Both are run with /bin/sh -c, with the Firefox arguments (without
+// the program name, so options like -new-tab are included) as "$@",
+// and with $FFOX_CWD, $FFOX_PROFILE, and $FFOX_TARGET (where we're
+// sending it) in the environment.
+//
+// If the pre-hook exits with a non-zero status, we don't send the
+// command line. If it prints anything, each line it prints is taken
+// as an argument and they replace all of the arguments; this is how it
+// can rewrite URLs. A pre-hook that only checks things should print
+// nothing.
+//
+// The post-hook also gets $FFOX_RESPONSE (Firefox's response, if
+// any), $FFOX_CODE (the response's three digit code), and $FFOX_ERROR
+// (if we couldn't send the command line at all). What it prints goes
+// to our standard output and its exit status is only reported.
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// hookCmd sets up running a hook command.
+func hookCmd(hook string, o *options, target, cwd string, args []string) *exec.Cmd {
+	c := exec.Command("/bin/sh", append([]string{"-c", hook, "ffox-hook"}, args[1:]...)...)
+	c.Env = append(os.Environ(), "FFOX_CWD="+cwd, "FFOX_PROFILE="+o.profile, "FFOX_TARGET="+target)
+	c.Stderr = os.Stderr
+	return c
+}
+
+// runPreHook runs the pre-hook for sending args, returning the
+// arguments to actually send or an error if the hook vetoed sending
+// them (or couldn't be run).
+func runPreHook(hook string, o *options, target, cwd string, args []string) ([]string, error) {
+	c := hookCmd(hook, o, target, cwd, args)
+	var out bytes.Buffer
+	c.Stdout = &out
+	if err := c.Run(); err != nil {
+		if _, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("pre-hook refused to send %s (%s)", strings.Join(args[1:], " "), err)
+		}
+		return nil, fmt.Errorf("pre-hook: %s", err)
+	}
+	var nargs []string
+	for _, l := range strings.Split(out.String(), "\n") {
+		if l = strings.TrimRight(l, "\r"); l != "" {
+			nargs = append(nargs, l)
+		}
+	}
+	if len(nargs) == 0 {
+		return args, nil
+	}
+	return append([]string{args[0]}, nargs...), nil
+}
+
+// runPostHook runs the post-hook after sending args, which got resp
+// or err.
+func runPostHook(hook string, o *options, target, cwd string, args []string, resp response, err error) error {
+	c := hookCmd(hook, o, target, cwd, args)
+	c.Stdout = os.Stdout
+	code := ""
+	if resp.code != 0 {
+		code = fmt.Sprint(resp.code)
+	}
+	c.Env = append(c.Env, "FFOX_RESPONSE="+resp.raw, "FFOX_CODE="+code)
+	if err != nil {
+		c.Env = append(c.Env, "FFOX_ERROR="+err.Error())
+	}
+	if e := c.Run(); e != nil {
+		return fmt.Errorf("post-hook: %s", e)
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+// Finding Firefox windows through i3 or sway's IPC interface.
+//
+// Normally we find Firefox windows by walking the X window tree, but
+// under sway (and XWayland in general) the X tree doesn't tell you
+// much, and i3 and sway both know exactly what windows exist. So with
+// -ipc we ask the window manager for its tree of windows and look at
+// the X windows in it instead. Sway will also tell us about native
+// Wayland Firefox windows, which we can't talk to through X at all.
+// We remember their processes, and if there's no Firefox X window for
+// us, we go to D-Bus (see altTransport) and use the Firefox whose bus
+// name is owned by one of those processes.
+//
+// The IPC protocol is simple: a message is the magic string 'i3-ipc',
+// a 32-bit payload length, a 32-bit message type, and the payload,
+// with the integers in native byte order (which we assume is little
+// endian). Replies have the same format, with a JSON payload.
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/BurntSushi/xgb/xproto"
+)
+
+const (
+	i3Magic   = "i3-ipc"
+	i3GetTree = 4
+)
+
+// useIPC is set if we should find windows through the i3/sway IPC
+// instead of the X window tree.
+var useIPC bool
+
+// ipcWayland is the processes of the native Wayland Firefox windows
+// that ipcWindows has seen, with their app IDs.
+var ipcWayland = make(map[uint32]string)
+
+// i3Node is the part of an i3/sway tree node that we care about.
+// Window is the X window ID (nil for sway's native Wayland windows),
+// and AppID is the Wayland app ID (only sway has this).
+type i3Node struct {
+	Window        *uint32  `json:"window"`
+	AppID         *string  `json:"app_id"`
+	Pid           int      `json:"pid"`
+	Nodes         []i3Node `json:"nodes"`
+	FloatingNodes []i3Node `json:"floating_nodes"`
+}
+
+// i3SocketPath finds the IPC socket for sway or i3.
+func i3SocketPath() (string, error) {
+	for _, ev := range []string{"SWAYSOCK", "I3SOCK"} {
+		if p := os.Getenv(ev); p != "" {
+			return p, nil
+		}
+	}
+	out, err := exec.Command("i3", "--get-socketpath").Output()
+	if err != nil {
+		return "", errors.New("can't find an i3 or sway IPC socket")
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// i3Tree gets the window manager's window tree.
+func i3Tree() (*i3Node, error) {
+	path, err := i3SocketPath()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	msg := make([]byte, len(i3Magic)+8)
+	copy(msg, i3Magic)
+	binary.LittleEndian.PutUint32(msg[len(i3Magic):], 0)
+	binary.LittleEndian.PutUint32(msg[len(i3Magic)+4:], i3GetTree)
+	if _, err = conn.Write(msg); err != nil {
+		return nil, err
+	}
+
+	hdr := make([]byte, len(i3Magic)+8)
+	if _, err = io.ReadFull(conn, hdr); err != nil {
+		return nil, err
+	}
+	if string(hdr[:len(i3Magic)]) != i3Magic {
+		return nil, errors.New("bad i3 IPC reply")
+	}
+	payload := make([]byte, binary.LittleEndian.Uint32(hdr[len(i3Magic):]))
+	if _, err = io.ReadFull(conn, payload); err != nil {
+		return nil, err
+	}
+	var tree i3Node
+	if err = json.Unmarshal(payload, &tree); err != nil {
+		return nil, err
+	}
+	return &tree, nil
+}
+
+// walk calls fn on n and all nodes underneath it.
+func (n *i3Node) walk(fn func(*i3Node)) {
+	fn(n)
+	for i := range n.Nodes {
+		n.Nodes[i].walk(fn)
+	}
+	for i := range n.FloatingNodes {
+		n.FloatingNodes[i].walk(fn)
+	}
+}
+
+// ipcWindows returns all of the X windows that the window manager
+// knows about. If we can't talk to it, we fall back to the X tree.
+func ipcWindows(xc xConn) []xproto.Window {
+	tree, err := i3Tree()
+	if err != nil {
+		slog.Info("i3/sway IPC failed; using the X window tree", "err", err)
+		return treeWindows(xc)
+	}
+	var wins []xproto.Window
+	tree.walk(func(n *i3Node) {
+		switch {
+		case n.Window != nil:
+			wins = append(wins, xproto.Window(*n.Window))
+		case n.AppID != nil && isFirefoxAppID(*n.AppID) && n.Pid > 0:
+			if _, ok := ipcWayland[uint32(n.Pid)]; !ok {
+				slog.Info("found a native Wayland Firefox window, which we'll have to talk to through D-Bus", "app", *n.AppID, "pid", n.Pid)
+			}
+			ipcWayland[uint32(n.Pid)] = *n.AppID
+		}
+	})
+	return wins
+}
+
+// isFirefoxAppID returns true if a Wayland app ID looks like some
+// sort of Firefox. Firefox uses its remoting name as its app ID, the
+// same thing that it puts in _MOZILLA_PROGRAM.
+func isFirefoxAppID(id string) bool {
+	for _, p := range forkPrograms {
+		if id == p {
+			return true
+		}
+	}
+	return strings.HasPrefix(id, "org.mozilla.")
+}
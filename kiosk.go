@@ -0,0 +1,41 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+// Checking that Firefox is in kiosk mode, for -kiosk.
+//
+// Kiosk mode (full screen, no toolbars or menus) is something that
+// Firefox decides on when it starts, from --kiosk (or --kiosk-monitor)
+// on its command line. Sending -kiosk in a remote command line
+// doesn't switch a normal Firefox into kiosk mode; the page just
+// opens the usual way. So when you ask for -kiosk we check that the
+// Firefox we found was started in kiosk mode, by finding its process
+// (as owner.go does) and looking at its command line in /proc.
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// kioskMode reports whether the Firefox we found was started in
+// kiosk mode.
+func (t *xTransport) kioskMode() (bool, error) {
+	pid := windowPID(t.xu, t.win, localDisplay(t.displayName()))
+	if pid <= 0 {
+		return false, errors.New("can't find its process")
+	}
+	b, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil {
+		return false, err
+	}
+	for _, a := range strings.Split(string(b), "\x00") {
+		name := strings.TrimPrefix(strings.TrimPrefix(a, "-"), "-")
+		if name != a && (name == "kiosk" || name == "kiosk-monitor" || strings.HasPrefix(name, "kiosk-monitor=")) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
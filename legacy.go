@@ -0,0 +1,58 @@
+package main
+
+// Support for the legacy _MOZILLA_COMMAND remote control protocol.
+//
+// Before _MOZILLA_COMMANDLINE, the remote control protocol (the one
+// behind 'firefox -remote ...') sent a single command in
+// _MOZILLA_COMMAND in a little function-call syntax, for example
+// 'openURL(http://example.com/,new-tab)'. Locking and responses work
+// exactly the same as in the modern protocol, so the only thing
+// that changes is what we put in which property.
+//
+// Current Firefox doesn't understand this at all, but SeaMonkey and
+// very old Firefox (and Iceweasel) builds only understand it, and
+// they generally advertise a _MOZILLA_VERSION older than 5.1 (see
+// protoClass in xremote.go).
+
+import (
+	"errors"
+	"strings"
+)
+
+// legacyEscape protects characters that are significant to the
+// openURL() argument syntax by %-encoding them. Old Mozillas split
+// the arguments on commas and stop at the first ')', so a raw URL
+// with either in it would be mangled.
+var legacyEscape = strings.NewReplacer(",", "%2C", ")", "%29")
+
+// legacyCommands turns a modern command line (without the program
+// name) into a series of _MOZILLA_COMMAND commands, one per URL,
+// since the old protocol can only send one URL at a time. With no
+// URLs, we ask for a new browser window (which is what opening the
+// default page amounts to).
+func legacyCommands(args []string) ([]string, error) {
+	where := ""
+	var urls []string
+	for _, a := range args {
+		switch a {
+		case "-search":
+			return nil, errors.New("-search is not supported by the legacy protocol")
+		case "-kiosk":
+			return nil, errors.New("-kiosk is not supported by the legacy protocol")
+		case "-new-window":
+			where = ",new-window"
+		case "-new-tab":
+			where = ",new-tab"
+		default:
+			urls = append(urls, a)
+		}
+	}
+	if len(urls) == 0 {
+		return []string{"xfeDoCommand(openBrowser)"}, nil
+	}
+	var cmds []string
+	for _, u := range urls {
+		cmds = append(cmds, "openURL("+legacyEscape.Replace(u)+where+")")
+	}
+	return cmds, nil
+}
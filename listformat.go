@@ -0,0 +1,70 @@
+package main
+
+// Formatting -list output for pickers, for -list-format, and taking
+// their choice back with -window.
+//
+// Menu programs like dmenu and rofi read lines and print the one you
+// pick. -list-format lets you make -list print exactly the lines you
+// want them to show, and -window takes the picked line back and uses
+// the window ID at the start of it, so that
+//
+//	ffox-remote -window "$(ffox-remote -list \
+//	    -list-format '0x{id}\t{profile}\t{title}' | dmenu)" URL
+//
+// sends URL to the Firefox window you picked. The format can use
+// \t and \n, since shells won't expand them for you.
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// listFields are the fields that -list-format can use.
+var listFields = map[string]bool{
+	"id": true, "version": true, "user": true, "profile": true,
+	"program": true, "packaging": true, "title": true,
+	"display": true, "prefix": true,
+}
+
+var listFieldRe = regexp.MustCompile(`\{(\w+)\}`)
+
+// checkListFormat checks that format only uses fields we know about.
+func checkListFormat(format string) error {
+	for _, m := range listFieldRe.FindAllStringSubmatch(format, -1) {
+		if !listFields[m[1]] {
+			var names []string
+			for n := range listFields {
+				names = append(names, n)
+			}
+			sort.Strings(names)
+			return fmt.Errorf("unknown field {%s} (known fields: %s)", m[1], strings.Join(names, " "))
+		}
+	}
+	return nil
+}
+
+// formatListLine expands format with fields.
+func formatListLine(format string, fields map[string]string) string {
+	format = strings.NewReplacer(`\t`, "\t", `\n`, "\n", `\\`, `\`).Replace(format)
+	return listFieldRe.ReplaceAllStringFunc(format, func(m string) string {
+		return fields[m[1:len(m)-1]]
+	})
+}
+
+// parseWindowID gets the window ID from s, which is either an ID
+// ('0x' and hex, or decimal) or a whole line from a picker that
+// starts with one.
+func parseWindowID(s string) (uint32, error) {
+	f := strings.Fields(s)
+	if len(f) == 0 {
+		return 0, fmt.Errorf("no window ID given")
+	}
+	id, err := strconv.ParseUint(f[0], 0, 32)
+	if err != nil || id == 0 {
+		return 0, fmt.Errorf("%q isn't a window ID", f[0])
+	}
+	return uint32(id), nil
+}
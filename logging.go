@@ -0,0 +1,168 @@
+package main
+
+// Logging, through log/slog.
+//
+// By default our messages look like they always have, one line of
+// 'ffox-remote: message' on standard error, with warnings marked. For
+// things that collect logs (journald, ELK, and so on), -log-format
+// text or json gives slog's standard formats instead, with the error
+// and other details as separate attributes, and -log-level sets the
+// lowest level that's logged. -vv and -vvv lower the level to show
+// their details. We log through slog's default logger,
+// so anything that embeds this code can install its own handler with
+// slog.SetDefault.
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+// A plainHandler is a slog.Handler that writes messages the way we
+// always have. An "err" attribute is written as 'message: error' and
+// other attributes as key=value.
+type plainHandler struct {
+	mu    *sync.Mutex
+	w     io.Writer
+	level slog.Leveler
+	attrs []slog.Attr
+}
+
+func (h *plainHandler) Enabled(_ context.Context, l slog.Level) bool {
+	return l >= h.level.Level()
+}
+
+func (h *plainHandler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+	b.WriteString("ffox-remote: ")
+	if r.Level >= slog.LevelWarn && r.Level < slog.LevelError {
+		b.WriteString("warning: ")
+	}
+	b.WriteString(r.Message)
+	var rest []string
+	add := func(a slog.Attr) bool {
+		switch {
+		case a.Equal(slog.Attr{}):
+		case a.Key == "err":
+			if r.Message != "" {
+				b.WriteString(": ")
+			}
+			b.WriteString(a.Value.String())
+		default:
+			rest = append(rest, fmt.Sprintf("%s=%v", a.Key, a.Value))
+		}
+		return true
+	}
+	for _, a := range h.attrs {
+		add(a)
+	}
+	r.Attrs(add)
+	if len(rest) > 0 {
+		b.WriteString(" (" + strings.Join(rest, " ") + ")")
+	}
+	b.WriteByte('\n')
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.w, b.String())
+	return err
+}
+
+func (h *plainHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	nh := *h
+	nh.attrs = append(append([]slog.Attr{}, h.attrs...), attrs...)
+	return &nh
+}
+
+// WithGroup doesn't bother with groups; plain output is for people.
+func (h *plainHandler) WithGroup(name string) slog.Handler {
+	return h
+}
+
+// levelNone is above every level that we log at, for -quiet.
+const levelNone = slog.Level(1 << 30)
+
+// setupLogging makes slog's default logger write to standard error
+// in format ("plain", "text", or "json") at level and above. A level
+// of "none" logs nothing.
+func setupLogging(format, level string) error {
+	var l slog.Level
+	if level == "none" {
+		l = levelNone
+	} else if err := l.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("bad log level %q (use debug, info, warn, error, or none)", level)
+	}
+	var h slog.Handler
+	opts := &slog.HandlerOptions{Level: l}
+	switch format {
+	case "plain":
+		h = &plainHandler{mu: new(sync.Mutex), w: os.Stderr, level: l}
+	case "text":
+		h = slog.NewTextHandler(os.Stderr, opts)
+	case "json":
+		h = slog.NewJSONHandler(os.Stderr, opts)
+	default:
+		return fmt.Errorf("bad log format %q (use plain, text, or json)", format)
+	}
+	slog.SetDefault(slog.New(h))
+	return nil
+}
+
+// -vv and -vvv log details at levels below slog's debug level, so
+// that they only show up if we're asked for them (or -log-level is
+// set low enough).
+const (
+	levelProto = slog.LevelDebug     // property changes and locking
+	levelEvent = slog.LevelDebug - 4 // X events
+)
+
+// logEnabled reports whether messages at level are logged, for when
+// working out what to log is expensive.
+func logEnabled(level slog.Level) bool {
+	return slog.Default().Enabled(context.Background(), level)
+}
+
+// protoLog logs protocol details for -vv.
+func protoLog(msg string, args ...any) {
+	slog.Log(context.Background(), levelProto, msg, args...)
+}
+
+// eventLog logs X event details for -vvv.
+func eventLog(msg string, args ...any) {
+	slog.Log(context.Background(), levelEvent, msg, args...)
+}
+
+// fatal logs msg and args as an error and exits with status 1.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	exit(1)
+}
+
+// exitFuncs are what cleanup runs, most recent first.
+var exitFuncs []func()
+
+// atExit arranges for f to be run when we exit, whether main returns
+// or we exit early through exit (or fatal). This is for things like
+// -timings, which have the most to tell you when things go wrong;
+// os.Exit doesn't run deferred functions.
+func atExit(f func()) {
+	exitFuncs = append(exitFuncs, f)
+}
+
+// cleanup runs everything given to atExit, once.
+func cleanup() {
+	for len(exitFuncs) > 0 {
+		f := exitFuncs[len(exitFuncs)-1]
+		exitFuncs = exitFuncs[:len(exitFuncs)-1]
+		f()
+	}
+}
+
+// exit runs cleanup and exits with status code.
+func exit(code int) {
+	cleanup()
+	os.Exit(code)
+}
@@ -3,7 +3,26 @@
 // This mimics what Firefox will do if you run a second copy but is much
 // lighter weight and can do some things that Firefox normally won't do.
 //
-// usage: ffox-remote [option ...] [URL ...]
+// usage: ffox-remote [open] [option ...] [URL ...]
+// usage: ffox-remote search [option ...] TERM ...
+// usage: ffox-remote find|list|ping [option ...]
+// usage: ffox-remote prefs [option ...] [SECTION]
+// usage: ffox-remote profiles [option ...]
+// usage: ffox-remote replay [option ...] FILE ...
+// usage: ffox-remote monitor [option ...]
+// usage: ffox-remote unlock [option ...]
+// usage: ffox-remote quit|restart [option ...]
+// usage: ffox-remote screenshot [option ...] [URL] -o FILE
+// usage: ffox-remote decode [FILE ...]
+// usage: ffox-remote bench [-n N] [option ...] [URL ...]
+// usage: ffox-remote stress [-c C] [-n N] [option ...] [URL ...]
+// usage: ffox-remote session [-pick N[.T],...] [option ...] FILE|DIR
+// usage: ffox-remote session -list FILE|DIR
+// usage: ffox-remote mozlz4 [-encode] [FILE ...]
+// usage: ffox-remote history [-pick N,...] [option ...] [FILE]
+// usage: ffox-remote audit FILE ...
+// usage: ffox-remote completion bash|zsh|fish
+// usage: ffox-remote firefox [FIREFOX-ARGUMENT ...]
 //
 // The URL may be anything that Firefox recognizes, including 'about:'
 // URLs. If no URL is given, Firefox will open whatever you've set as
@@ -30,32 +49,775 @@
 //		Firefox and turns all arguments into a single argument
 //		that Firefox will search for.
 //
+//	-kiosk
+//		Pass -kiosk to Firefox along with the URLs, for digital
+//		signs and the like that push new pages to a browser
+//		that's already showing in kiosk mode. Firefox only goes
+//		into kiosk mode when it starts, so first we check that
+//		the Firefox we found was started with --kiosk (from its
+//		process's command line), and fail if it wasn't, instead
+//		of opening the page in an ordinary window. If we can't
+//		tell (for example, Firefox is on another machine or
+//		we're using D-Bus), we warn and go ahead.
+//
+//	-bookmarks FILE[:FOLDER]
+//		Open all of the links in FILE, a bookmarks file in the
+//		usual HTML format that Firefox exports, as tabs in a new
+//		window (or in the current window with -new-tab). With
+//		FOLDER, only open the links in bookmark folders with
+//		that name (and their subfolders). Any URLs on the
+//		command line are opened too, first.
+//
+//	-extract
+//		Read standard input and open every URL in it, in the
+//		same way as -bookmarks. We find http, https, and ftp
+//		URLs, anything with a scheme in angle brackets (such as
+//		'<URL:gopher://...>'), and bare 'www.' host names,
+//		trimming off punctuation that running text puts on the
+//		end. This lets you pipe email or log files to us.
+//
+//	-selection
+//	-clipboard
+//		Open the X PRIMARY selection (whatever is highlighted)
+//		or the clipboard as a URL, after any URL arguments, so
+//		that a hotkey can open whatever you just selected. With
+//		-clean, it's cleaned up first. This only works with X
+//		(including XWayland). See selection.go.
+//
+//	-search-selection
+//		Search for the X PRIMARY selection (or the clipboard,
+//		with -clipboard), as -search does, with runs of
+//		whitespace (including newlines) turned into single
+//		spaces. Bound to a hotkey, this looks up whatever you
+//		just highlighted.
+//
+//	-clean	Clean up URL arguments pasted from terminals and email:
+//		use the real URL of a terminal (OSC 8) hyperlink, and
+//		strip other terminal escapes, surrounding angle
+//		brackets, parentheses, and quotes, and punctuation on
+//		the end. With -extract, terminal hyperlinks in the
+//		input are turned into their URLs first. Search terms
+//		are left alone. See clean.go.
+//
+//	-markdown FILE
+//	-heading HEADING
+//		Open every link in the Markdown file FILE (standard
+//		input if FILE is '-'), in the same way as -bookmarks.
+//		With -heading, only open the links in the section under
+//		the heading HEADING (ignoring case). Links in fenced
+//		code blocks and links to anchors in the file itself
+//		are skipped.
+//
+//	-opml FILE[:TITLE]
+//	-opml-feeds
+//		Open the web site of every feed in the OPML subscription
+//		list FILE (or the feed itself if there's no web site,
+//		or always with -opml-feeds), in the same way as
+//		-bookmarks. With TITLE, only open feeds with that title
+//		or in a folder with that title.
+//
 //	-P PROFILE
 //	-U USER
 //	-G PROGRAM
 //		These set the name of the Firefox profile, user, and
 //		program to match Firefox windows against, in case you
 //		have multiple Firefox sessions running on the same X
-//		server. A blank value matches anything (and if there
-//		are multiple sessions, which one matches is uncertain).
+//		server. A blank value matches anything. If windows from
+//		more than one Firefox session match equally well, we
+//		use the one with the lowest window ID, so it's the same
+//		one every time, and warn about the others.
 //		The default settings are -P 'default' -U '' -G 'firefox',
-//		which is normally what you want.
+//		which is normally what you want. Without -P, we actually
+//		look for Firefox's default profile, from the
+//		profiles.ini and installs.ini in your Firefox
+//		directory (modern Firefox calls it something like
+//		'abcd1234.default-release'), and fall back to 'default'
+//		if there's no Firefox with it or we can't tell which
+//		profile is the default (for example, if you have
+//		several Firefox installations). On Windows, no -P
+//		means the Firefox that was started without a profile
+//		name, which is how Firefox is normally started there.
+//
+//		PROFILE can be a shell glob pattern, such as 'work*',
+//		which is matched against the profile's directory name
+//		both with and without the random prefix that Firefox
+//		puts on it (eg 'abcd1234.work-stuff'), or against the
+//		full path if the pattern has a / in it. This way you
+//		don't need to know the random part.
+//
+//		-U auto matches your own login name (from $LOGNAME,
+//		$USER, or the password file, the same way that Firefox
+//		gets it), which is safer on an X server that several
+//		people use. You can make it the default with 'user =
+//		auto' in the configuration file; -U '' still matches
+//		anything.
+//
+//		If you don't give -G and there's no 'firefox' window, we
+//		try the program names of various Firefox forks and other
+//		builds (LibreWolf, IceCat, Waterfox, Nightly, and so on;
+//		see forks.go) in turn. -v and -find report what program
+//		name the window we found has.
+//
+//		If PROFILE is the name of an instance in the
+//		configuration file (see -config), the instance's
+//		settings fill in -U, -G, -pref, -title, -class, and
+//		-host unless you give them too.
+//
+//	-title REGEXP
+//	-class REGEXP
+//		Also require matching Firefox windows to have a title,
+//		or a WM_CLASS instance or class name, that the (Go)
+//		regular expression REGEXP matches. This is for telling
+//		apart Firefoxes whose remote control properties are
+//		the same, such as a kiosk window whose title is always
+//		'Dashboard'. Remember that a Firefox window's title
+//		changes with the page it's showing. Only works with X.
+//
+//	-host HOST
+//		Only match Firefox windows from the machine HOST, going
+//		by their WM_CLIENT_MACHINE property. This is for when
+//		Firefoxes from several machines display on one X
+//		server and their user and profile are the same. A HOST
+//		without a domain matches any fully qualified name that
+//		starts with it, and case doesn't matter. Only works
+//		with X.
+//
+//	-any-owner
+//		Normally, before we change anything on a Firefox window,
+//		we check that the window belongs to a process that's
+//		running as us (using the X server's X-Resource
+//		extension or _NET_WM_PID, and /proc), or if we can't
+//		see its process, that its _MOZILLA_USER is our login
+//		name. This keeps you from driving someone else's
+//		Firefox on a shared X server. -any-owner turns the
+//		check off. Only X needs this; see owner.go.
+//
+//	-display DISPLAY
+//	-xauthority FILE
+//		Talk to the X server DISPLAY, using the X authority
+//		file FILE to authenticate to it, instead of what's in
+//		$DISPLAY and $XAUTHORITY. DISPLAY can be a TCP display
+//		('host:0' or 'tcp/host:0') as well as a local one.
+//		These are handy for cron jobs and daemons, which
+//		usually don't have either environment variable set.
+//
+//	-scan-displays
+//		Look for Firefox on every local X display (everything
+//		with a socket in /tmp/.X11-unix) that we can connect
+//		to, instead of just $DISPLAY, and use the first one
+//		with a matching Firefox. -list lists the Firefox
+//		windows on all of them, and -find and -v report which
+//		display we used. This is handy in multi-seat and
+//		nested X server setups.
+//
+//	-monitor pointer
+//	-monitor OUTPUT
+//		If there are several matching Firefox windows, prefer
+//		one that's on the monitor the mouse pointer is on, or
+//		on the RandR output OUTPUT (eg 'DP-1'; see 'xrandr').
+//		This is for multi-head setups, so that things show up
+//		on the screen you're working on.
+//
+//	-tui	If several Firefox windows match, show a menu of them on
+//		the terminal, with their profile, program, and title,
+//		and use the one you pick (with the arrow keys and Enter,
+//		or by number). We do this automatically if standard
+//		input is a terminal and the matching windows are from
+//		more than one Firefox instance; -tui=false turns that
+//		off. Only works with X. See tui.go.
+//
+//	-spread	Instead of sending everything to the best matching
+//		Firefox, deal the URLs out round-robin across all of
+//		the matching Firefox instances (one window each), and
+//		report what each instance said. Each instance gets one
+//		command line with its share of the URLs, with -new-tab
+//		or -new-window if you gave them. This is for splitting
+//		a batch of tabs across profiles (use a -P glob) or
+//		load testing. It can't search. Only works with X. See
+//		spread.go.
+//
+//	-tee TARGET,TARGET,...
+//		Send the same command line to each TARGET in turn,
+//		where a TARGET is an instance from the configuration
+//		file or a profile (as for -P), and report what each
+//		one said. This is for checking a page in several
+//		profiles or Firefoxes at once. Each target is found on
+//		its own (so one can be on D-Bus and another on X), and
+//		one we can't find doesn't stop the rest. -tee can be
+//		repeated. It can't be used with -P, -window, -spread,
+//		or the other ways of not opening URLs. See tee.go.
+//
+//	-dbus	Talk to Firefox through D-Bus instead of X. This is what
+//		Firefox uses when it's running natively on Wayland. We
+//		also switch to this automatically if we can't find a
+//		Firefox window and we're on Wayland (either the X
+//		server is XWayland or there's no X server at all).
+//		D-Bus doesn't have -U, -force, or responses. This is
+//		the same as '-transport dbus'.
+//
+//	-transport NAME
+//		Talk to Firefox through the transport NAME instead of
+//		the usual one. On Unix there are 'x' (the default) and
+//		'dbus'; on Windows there's only 'windows'. -version
+//		reports which of our options and subcommands the
+//		transport can do. See transports.go.
+//
+//	-ipc	Find Firefox windows by asking i3 or sway (through their
+//		IPC socket) for all of their windows, instead of walking
+//		the X window tree. This is more reliable under sway and
+//		XWayland. If there's no suitable Firefox X window but
+//		sway knows about native Wayland Firefox windows, we talk
+//		to their Firefox through D-Bus instead, picking the bus
+//		name that belongs to the same process.
+//
+//	-serve HANDLER
+//		Instead of talking to Firefox, pretend to be it: create
+//		a window that advertises the profile, user, and program
+//		from -P, -U (your login name if it's blank), and -G,
+//		and for every command line that's sent to it, run
+//		HANDLER with the command line's arguments. The response
+//		is '200 executed command' if HANDLER succeeds. This lets
+//		other programs be remote controlled with the Firefox
+//		protocol. Only works with X. See serve.go.
+//
+//	-app-service APPID
+//		Instead of sending anything now, claim the name APPID
+//		on the D-Bus session bus and implement the standard
+//		org.freedesktop.Application interface there, opening the
+//		URIs that desktop environments and portals give Open()
+//		in the Firefox that the options select (and a new
+//		window for Activate()). This lets a .desktop file with
+//		'DBusActivatable=true' route URLs through ffox-remote.
+//		Requests are queued and sent to Firefox one at a time
+//		in the order they arrived, so callers don't wait on a
+//		slow Firefox or on someone else holding its lock, and
+//		a request isn't lost because the lock was busy.
+//		It runs until killed. See appservice.go.
+//
+//	-socket-activated
+//		Instead of sending anything now, take requests on the
+//		sockets that systemd socket activation hands us (from
+//		a .socket unit) and open them in the Firefox that the
+//		options select: URLs one per line on a Unix socket, or
+//		url parameters in an HTTP POST to /open (with an
+//		'X-Ffox-Remote' header) on a TCP socket. Nothing that
+//		starts with '-' is accepted as a URL. Requests are
+//		queued the way -app-service queues them, and we exit
+//		after five idle minutes, to be started again by systemd
+//		when the next one comes in. See sockets.go.
+//
+//	-bridge PREFIX
+//		Find Firefox as usual, then pretend to be it under the
+//		X property prefix PREFIX (instead of _MOZILLA, or
+//		whatever -pref says) and pass on every command line
+//		sent to us there to the real Firefox, and its responses
+//		back. This runs until killed. With -pref, it can go the
+//		other way: '-bridge _MOZILLA -pref _OTHER' makes a
+//		Firefox that uses _OTHER visible to normal clients.
+//
+//	-watch	Don't send a command to Firefox right away; instead, watch
+//		for matching Firefox windows to appear and disappear
+//		and report when they do, forever. If you give URLs (or
+//		-new-window and so on), they're sent to the first new
+//		Firefox window that appears. Only works with X.
+//
+//	-watch-exec COMMAND
+//		With -watch, run COMMAND with the shell every time a
+//		Firefox window appears or disappears (or is there when
+//		we start), with $FFOX_EVENT set to 'appeared',
+//		'disappeared', or 'present' and $FFOX_WINDOW set to
+//		the window ID.
+//
+//	-record FILE
+//		Append a JSON record of each command line we send to
+//		FILE, with the encoded form we sent, the time, the
+//		profile, and Firefox's response. See 'replay'.
+//
+//	-history FILE
+//		Record every command line we send in the history file
+//		FILE, in the same format as -record, including which
+//		window it went to. You can also turn this on for good
+//		with 'history = FILE' in the configuration file (see
+//		-config). See the 'history' subcommand.
+//
+//	-dry-run
+//		Find Firefox and work out everything we would send it
+//		(after running any pre-hook), but instead of sending
+//		it, print where it would go and the exact bytes we
+//		would set the property to (or pass over D-Bus), as a
+//		hexdump and decoded again. With the legacy protocol,
+//		this is each legacy command. Nothing is locked,
+//		changed, recorded, or audited. This works with the
+//		subcommands that send things too, and with -ping,
+//		-set-prop, unlock, quit, and restart, which say what
+//		they would do. It can't be used with -hold-lock,
+//		-watch, -serve, -bridge, -app-service,
+//		-socket-activated, bench, stress, or screenshot. See
+//		dryrun.go.
+//
+//	-confirm
+//		Before sending each command line to Firefox, show it
+//		(as Firefox will decode it) and which window and profile
+//		it's going to, and ask on the terminal whether to send
+//		it. Anything but 'y' or 'yes' stops us without sending
+//		it or anything after it, and we exit with status 1. If
+//		there's no terminal to ask on, nothing is sent. This is
+//		a checkpoint for when less trusted programs run us.
+//		-confirm can't be used with -via, -wsl, or
+//		-portal-fallback, and doesn't apply to things like
+//		-watch and bench that send command lines on their own.
+//
+//	-audit FILE
+//		Refuse to send anything to Firefox unless we can first
+//		append a record of it to the audit log FILE, and then
+//		append Firefox's response. The records include the
+//		command line, the target window, and our UID, PID,
+//		and parent PID, and each is chained to the one before
+//		it by a SHA-256 hash so that changes to the file are
+//		evident (see 'audit'). -audit can't be used with things
+//		that would send command lines some other way, such as
+//		-via, -wsl, -watch, -bridge, or bench. See audit.go.
 //
 //	-force	Force us to talk to Firefox even if we can't get the
 //		lock for the remote command protocol. This may be
 //		necessary in some situations. We clear the lock if
 //		this is used.
 //
+//	-cwd DIR
+//		Tell Firefox that our working directory is DIR instead
+//		of what it really is, or send no working directory at
+//		all with '-cwd none'. Firefox uses the working directory
+//		to resolve relative file names (although current ones
+//		seem to ignore it), so this is mostly for scripts that
+//		want to control exactly what Firefox sees.
+//
+//	-retry N
+//	-retry-interval DURATION
+//		If we can't find a matching Firefox window, look again
+//		up to N more times, DURATION apart (one second by
+//		default), before giving up. This is useful right after
+//		you log in, when Firefox may still be starting.
+//
+//	-wait-for-firefox DURATION
+//		If there's no matching Firefox window, wait up to
+//		DURATION (eg '30s') for one to appear before going on.
+//		With X we watch for new windows instead of polling, so
+//		we notice Firefox quickly. This is for scripts that
+//		start Firefox and then immediately want to send it
+//		URLs.
+//
+//	-lock-wait DURATION
+//	-steal-lock
+//		If someone else has Firefox locked, wait up to DURATION
+//		(ten seconds by default; 0 means forever) for them to
+//		finish. After that we give up and report who has the
+//		lock, or with -steal-lock we carry on as if we'd been
+//		given -force. A lock held this long generally means
+//		that some other remote control client crashed or got
+//		stuck.
+//
+//	-timeout DURATION
+//		Give up on finding and talking to Firefox if it takes
+//		longer than DURATION in total, including waiting for
+//		the lock, for Firefox's response, and for -retry and
+//		-wait-for-firefox. Being interrupted gives up the same
+//		way, and either way we release Firefox's lock if we
+//		have it. Modes that run until they're killed (-serve,
+//		-watch, -bridge, and so on) ignore this, except that
+//		-app-service and -socket-activated use it as how long
+//		to look for Firefox for each request.
+//
+//	-no-wait
+//		Set the command line on the Firefox window and return
+//		right away, without waiting for Firefox's response (we
+//		still release the lock). This is faster, especially for
+//		things run from hotkeys, but we can't tell if Firefox
+//		failed. The legacy protocol always waits, since it may
+//		have to send several commands in a row, and so does a
+//		command line too long to send in one go, except for its
+//		last piece.
+//
 //	-v	Be verbose; report the Firefox window ID and Firefox's
 //		response to our command.
 //
+//	-vv
+//	-vvv	Be more verbose. -vv also reports the protocol in
+//		detail: every remote control property we read or set
+//		(with command lines decoded) and when we take, wait for,
+//		and release the lock. -vvv adds the X events we get
+//		while we wait. -v=N is the same as N v's.
+//
+//	-quiet	Print nothing on standard error, not even warnings
+//		(such as about a Firefox with an unexpected protocol
+//		version) or why we failed, and turn off -v; only our
+//		exit status (see later) says what happened. This is
+//		for shell pipelines, mailcap entries, and the like.
+//		Output that you ask for, such as from -list or -find,
+//		is still printed.
+//
+//	-tolerant
+//		Ignore options we don't know, empty arguments, and
+//		unfilled placeholders such as '%s' and '%u', instead
+//		of failing, and take options after URLs too. Mail
+//		clients and old URL handlers pass all sorts of things
+//		to browsers, and this lets you point them at ffox-remote
+//		anyway. With -v, we report what we ignored. See
+//		tolerant.go.
+//
+//	-log-format plain|text|json
+//	-log-level debug|info|warn|error|none
+//		Our messages normally go to standard error as plain
+//		lines. -log-format text or json writes them in
+//		log/slog's key=value or JSON formats instead, with
+//		times, levels, and errors as separate fields, for
+//		things like journald and ELK. -log-level sets the least
+//		important messages that are printed; the default is
+//		info. See logging.go.
+//
+//	-version
+//		Print the version of ffox-remote, the protocol versions
+//		it speaks, and, if we can find the Firefox that the
+//		other options select, the protocol version that it
+//		advertises and what we make of that. Not finding
+//		Firefox isn't an error. Please include this in bug
+//		reports.
+//
 //	-find	Don't send a command to Firefox, just report its window
 //		ID. This is mostly useful for debugging purposes.
 //
-//	-pref PREFIX
+//	-find-format kv|json
+//		Implies -find, but print everything we know about the
+//		Firefox we found for scripts to use, either as
+//		KEY=VALUE lines (with the VALUE quoted Go style if it
+//		has spaces or other odd things in it) or as a JSON
+//		object. With X, the keys are window, display, user,
+//		profile, profile_path (the profile itself if it's a
+//		directory, as it is in Firefox 131 and later, and
+//		otherwise from profiles.ini), program, host, protocol,
+//		packaging, pid, title, geometry (WxH+X+Y), and desktop;
+//		ones we can't find out are left out. See findformat.go.
+//
+//	-ping	Send Firefox a command line that doesn't do anything
+//		visible ('-silent') through the whole protocol, with
+//		the lock and waiting for the response, and report how
+//		long it took (and how long getting the lock and waiting
+//		for the response took, with X). If Firefox doesn't
+//		answer or says it failed, we exit with status 1. This
+//		is for monitoring scripts that want to know that remote
+//		control works without opening a tab. Pings aren't
+//		recorded, audited, or passed to hooks. See ping.go.
+//
+//	-hold-lock DURATION
+//		Don't send a command to Firefox; instead take its lock
+//		(in the usual way, so -lock-wait and -steal-lock apply)
+//		and hold it for DURATION or until interrupted, printing
+//		everything that other clients do to the remote control
+//		properties in the meantime: taking over or deleting the
+//		lock, or setting a command line without it. Clients
+//		that wait politely for the lock don't show up, since
+//		they don't do anything until we release it. This is
+//		for studying the locking behavior of Firefox and other
+//		remote control clients. It only works with X. See
+//		holdlock.go.
+//
+//	-dump	Don't send a command to Firefox, just print all of the
+//		remote control X properties on its window, with their
+//		raw values and what they mean (the protocol version,
+//		who holds the lock, the last response, and any command
+//		line that Firefox hasn't picked up yet).
+//
+//	-set-prop NAME=VALUE
+//		Don't send a command to Firefox; instead set the X
+//		property NAME on its window to VALUE, or delete the
+//		property if VALUE is empty. This is for experimenting
+//		with the protocol (for example, planting a lock or
+//		clearing an old response). Only properties with the
+//		remote control prefix can be set unless you also give
+//		-force.
+//
+//	-timings
+//		When we're done, report on standard error how long each
+//		phase of talking to Firefox took: connecting to the X
+//		server, interning atoms, scanning for Firefox windows,
+//		getting the lock, setting the command line, and waiting
+//		for the response.
+//
+//	-trace FILE
+//		Log every X operation we do that matters for the
+//		protocol (property reads and changes, window tree
+//		queries, server grabs, and waits for events) to FILE,
+//		or to standard error if FILE is '-', with when each
+//		started and how long it took. This is for figuring out
+//		where things are hanging or slow.
+//
+//	-list	Don't send a command to Firefox, just list all of the
+//		Firefox windows we can see (regardless of -P, -U, and
+//		-G), with their protocol version, user, program, profile,
+//		and how Firefox was packaged (a Snap, a Flatpak, or
+//		native), if we can tell. With X, we then list the
+//		profiles that their lock files say are running, who has
+//		them locked, and which of the windows are theirs; a
+//		running profile without a window may be on another
+//		display or have been started with -no-remote. When we
+//		can't find Firefox, we also report which profiles are
+//		running. See profilelocks.go.
+//
+//	-list-format FORMAT
+//		Print each -list line in FORMAT instead, with {id} (the
+//		window ID in hex, without '0x'), {version}, {user},
+//		{profile}, {program}, {packaging}, {title}, {display},
+//		and {prefix} replaced by what they are for the window,
+//		and \t and \n by tabs and newlines. This is for feeding
+//		menu programs like dmenu and rofi; for example,
+//		'0x{id}\t{profile}\t{title}'. Only works with X.
+//
+//	-window ID
+//		Send to the Firefox window ID ('0x' and hex, or decimal)
+//		instead of looking for one that matches -P, -U, and -G.
+//		ID can be a whole line of -list or -list-format output
+//		that starts with the ID, such as the line a menu program
+//		printed when you picked it. Only works with X, and only
+//		on $DISPLAY or -display. See listformat.go.
+//
+//	-legacy	Always use the legacy _MOZILLA_COMMAND protocol (the
+//		one behind the old '-remote' argument) to talk to the
+//		browser. This is normally picked automatically for
+//		browsers that advertise an old protocol version; see
+//		later.
+//
+//	-remote COMMAND
+//		Do COMMAND, an old 'firefox -remote' command such as
+//		'openURL(https://example.com/,new-tab)', so that
+//		ancient scripts can run ffox-remote instead of Firefox
+//		unchanged. This is translated into a modern command
+//		line (it has nothing to do with -legacy). We understand
+//		openURL, openFile, xfeDoCommand(openBrowser), and
+//		ping(); see remote.go. It can't be used with other
+//		arguments, subcommands, or the options that pick what
+//		to do.
+//
+//	-protocol-version VERSION
+//		Treat Firefox windows that advertise the _MOZILLA_VERSION
+//		VERSION as speaking the current protocol. Normally we
+//		want exactly 5.1, but we'll try other newer versions
+//		with a warning and use the legacy protocol for older ones;
+//		this lets you bless a new version (or force an old one
+//		to use the current protocol) without the warning.
+//
+//	-via [USER@]HOST
+//		Instead of talking to a Firefox here, run ffox-remote
+//		on HOST over SSH with all of our other options and
+//		arguments, and pass back its output and exit status. The
+//		remote ffox-remote uses :0 as its X display unless SSH
+//		gives it a $DISPLAY. Use -via-cmd to set the remote
+//		command (the default is just 'ffox-remote'). Arguments
+//		are passed as they are, so local files won't work.
+//
+//	-wsl	Always hand the command line off to Windows Firefox,
+//		instead of only doing this when we're running under
+//		WSL and can't find a Firefox through X. See later.
+//
+//	-portal-fallback
+//		If we can't find a Firefox window at all (or can't
+//		talk to the X server), open the URLs through the XDG
+//		desktop portal's OpenURI interface instead. This uses
+//		your default browser (whatever it is) and doesn't
+//		support -search, -new-window, -new-tab, or opening
+//		your default page.
+//
+//	-config FILE
+//		Read the configuration file FILE instead of the default
+//		one. The configuration file gives names to Firefox
+//		instances, so that '-P NAME' can pick out a specific
+//		Firefox by profile, user, program, and X property
+//		prefix; see config.go for the details. It can also set
+//		up the history file (see -history) and commands to run
+//		before and after sending each command line, which can
+//		refuse or rewrite it; see hooks.go.
+//
+//	-pref PREFIX[,PREFIX...]
 //		Use PREFIX as the prefix on the Firefox X property names,
 //		instead of the normal _MOZILLA. This is only really useful
-//		for Chris Siebenmann.
+//		for Chris Siebenmann. If you give more than one prefix
+//		(separated by commas or with several -pref options), we
+//		try each in order when finding Firefox and use the first
+//		that has a matching window; include _MOZILLA to also try
+//		a stock Firefox. -list lists windows under all of them.
+//		Other things, like -watch and -serve, only use the
+//		first prefix.
+//
+// The subcommands, which must come before any options, are:
+//
+//	open [URL ...]
+//	search TERM ...
+//	find
+//	list
+//	ping
+//		The same as the plain form of ffox-remote, -search,
+//		-find, -list, and -ping respectively, except that they
+//		can't be combined with any of the other options that
+//		pick what we do (-search, -search-selection, -find,
+//		-list, -ping, -hold-lock, -dump, -set-prop, -serve,
+//		-watch, -bridge, -app-service, and -socket-activated),
+//		so you can't ask for two things at once by accident.
+//		The plain 'ffox-remote URL ...' is the same as
+//		'ffox-remote open URL ...'; you need 'open' if your
+//		first URL is the name of a subcommand.
+//
+//	firefox [FIREFOX-ARGUMENT ...]
+//		Take Firefox's own arguments instead of ours, such as
+//		'--new-tab URL', '--search TERM', and '-P PROFILE', so
+//		that we can be used in place of Firefox in desktop files
+//		and scripts. Running ffox-remote under the name
+//		'firefox' (or the name of one of the forks we look for),
+//		through a symlink, does the same. Only the Firefox
+//		options that make sense for a running Firefox work; see
+//		firefoxargs.go.
+//
+//	prefs [SECTION]
+//		Open Firefox's settings, or the SECTION of them (such
+//		as privacy, search, home, or sync). SECTION can also be
+//		one of the other about: pages that people often want,
+//		such as addons, downloads, logins, config, profiles, or
+//		support. Like open, this can't be combined with the
+//		options that pick what we do. See prefs.go.
+//
+//	profiles
+//		List the profiles from profiles.ini, marking the
+//		defaults and saying which are running (from their lock
+//		files and, with X, the Firefox windows we can see), and
+//		then open about:profiles in the Firefox that the options
+//		select. Like open, this can't be combined with the
+//		options that pick what we do.
+//
+//	replay FILE ...
+//		Re-send all of the command lines recorded in the FILEs
+//		by -record, in order, to the Firefox that the options
+//		select (not necessarily the one they were originally
+//		sent to). Each command line is sent as it was, so
+//		options like -new-tab and -search are ignored.
+//
+//	monitor
+//		Watch the Firefox window that the options select and
+//		print every command line that any program sends it,
+//		along with Firefox's responses and the lock being
+//		taken and released. This runs until you interrupt it
+//		or the window goes away, and only works with X. Since
+//		Firefox deletes the command line as soon as it's read
+//		it, we may sometimes miss one.
+//
+//	unlock
+//		Delete the remote control lock on the Firefox window
+//		that the options select, after printing who holds it
+//		(and, if it's a process on this machine, whether that
+//		process still exists), without sending anything. This
+//		is how to clean up after a client that died holding the
+//		lock, which is safer than sending something with
+//		-force. It only works with X.
+//
+//	quit
+//	restart
+//		Make the Firefox that the options select quit, or quit
+//		and start again, the way it does from its menu. This
+//		uses Marionette if Firefox is running it (if it was
+//		started with --marionette); otherwise quit closes all of
+//		Firefox's windows, as if you'd closed them yourself,
+//		which means that session restore will only bring back
+//		the last one, and restart fails. quit waits for
+//		Firefox's windows to go away (or -timeout). Firefox may
+//		ask whether to close all your tabs, and you can say no.
+//		This only works with X. See quit.go.
+//
+//	screenshot [URL] -o FILE
+//		Write a PNG screenshot of URL to FILE ('-' for standard
+//		output), using the Firefox that the options select. The
+//		URL is opened in a new background tab, which is closed
+//		again afterward; without a URL, you get the tab you're
+//		looking at. Normally the screenshot is of what fits in
+//		the window; -full-page makes it the whole page. This
+//		needs Firefox to be running Marionette (started with
+//		--marionette) and nothing else to be using it, and only
+//		works with X. See screenshot.go.
+//
+//	decode [FILE ...]
+//		Decode _MOZILLA_COMMANDLINE values (from FILEs or
+//		standard input) and print the working directory and
+//		arguments in them. The input can be either the raw
+//		property value or xprop's output for the property
+//		(eg from 'xprop -id WINDOW _MOZILLA_COMMANDLINE'). We
+//		check that everything in it is valid, including that
+//		it's little-endian. This doesn't talk to Firefox.
+//
+//	bench [-n N] [URL ...]
+//		Send the same command line to Firefox N times (10 by
+//		default) and report the minimum, median, 90th and 99th
+//		percentile, and maximum time it took, both in total
+//		and (with X) for getting the lock and waiting for the
+//		response. Without URLs, we open about:blank each time,
+//		so you'll get N new tabs.
+//
+//	stress [-c C] [-n N] [URL ...]
+//		Start C concurrent senders (4 by default), each with its
+//		own X connection, that each send the command line N
+//		times to the same Firefox window, and report on lost
+//		locks (two senders holding the lock at once), missed
+//		responses, and senders that got stuck. This is for
+//		testing the locking protocol. Like bench, it opens
+//		about:blank if you don't give URLs. It only works with
+//		X.
+//
+//	session [-list] [-pick N[.T],...] FILE|PROFILE-DIR
+//		Reopen the windows and tabs from a Firefox session store
+//		file (sessionstore.jsonlz4 or one of the backups in
+//		sessionstore-backups/), in the running Firefox that the
+//		options select. Given a profile directory, we use the
+//		most recently written session store file in it. Each
+//		window is reopened as a new window (or with -new-tab, as
+//		tabs in the current one). By default we reopen all of
+//		the windows that were open; -pick picks windows N and
+//		single tabs N.T (including recently closed windows) by
+//		their numbers from -list, which lists the session's
+//		windows and tabs without talking to Firefox. This is
+//		handy for moving a session to another profile, or when
+//		Firefox's own session restore fails. See session.go.
+//
+//	mozlz4 [-encode] [FILE ...]
+//		Decompress the mozlz4 FILEs (or standard input) to
+//		standard output. Firefox uses mozlz4, LZ4 with its own
+//		header, for JSON files in the profile such as
+//		sessionstore.jsonlz4 and search.json.mozlz4; the
+//		ordinary lz4 command can't read them. With -encode,
+//		compress a single FILE into mozlz4 instead. This
+//		doesn't talk to Firefox. Other Go programs can use the
+//		mozlz4 package directly.
+//
+//	history [-pick N,...] [FILE]
+//		List the command lines in the history file (or FILE, which
+//		can also be a -record file), numbered, with when and
+//		where they were sent and whether Firefox said they
+//		failed. With -pick, re-send entries N instead, to the
+//		Firefox that the options select; negative numbers count
+//		back from the most recent, so '-pick -1' re-sends the
+//		last command line. This doesn't need Firefox unless
+//		you give -pick. See history.go.
+//
+//	audit FILE ...
+//		Check the hash chains of -audit logs and report the
+//		first record where one is broken, which means that an
+//		earlier record was changed or removed. This doesn't
+//		talk to Firefox.
+//
+//	completion bash|zsh|fish
+//		Print a completion script for the shell, covering all
+//		of our options and subcommands. -P completes to the
+//		instance names from the configuration file and the
+//		profile names from Firefox's profiles.ini, which the
+//		script gets by running 'ffox-remote completion
+//		profiles' each time. For bash, put
+//		'source <(ffox-remote completion bash)' in your
+//		.bashrc; for zsh, save it as _ffox-remote somewhere in
+//		your $fpath; for fish, save it as
+//		~/.config/fish/completions/ffox-remote.fish.
 //
 // To start multiple sessions of Firefox with different profiles that
 // still listen for remote commands, you need to use '-new-instance'
@@ -79,9 +841,44 @@
 // X property that it relied on (they now use a more complicated
 // scheme of transmitting the nominal Firefox command line in
 // _MOZILLA_COMMANDLINE). For a discussion of Firefox's current X
-// property protocol for remote control, see the comment later on in
-// main.go. It may not work for very old versions of Firefox that do not
-// support _MOZILLA_COMMANDLINE at all.
+// property protocol for remote control, see the comment in xremote.go.
+//
+// Very old versions of Firefox (and SeaMonkey) that do not support
+// _MOZILLA_COMMANDLINE at all advertise an older protocol version.
+// When we find one of them we fall back to the old _MOZILLA_COMMAND
+// protocol, sending an 'openURL(URL[,new-tab|new-window])' command
+// for each URL. This protocol can't do -search.
+//
+// On Windows, Firefox doesn't use X; instead it listens for command
+// lines sent to a hidden window as window messages. ffox-remote
+// speaks this too (see windows.go), with the same options, although
+// the X-specific ones (-U, -force, -legacy, -pref, -protocol-version,
+// -trace, and -portal-fallback) do nothing there.
+//
+// Under WSL, if we can't find a Firefox through X we pass the command
+// line to Windows Firefox by running firefox.exe with it (converting
+// arguments that are Linux files to Windows paths). The running
+// Windows Firefox then opens the URLs. Since firefox.exe doesn't
+// know about your Linux -P setting, this always goes to the default
+// Windows Firefox profile.
+//
+// Arguments that are existing files, relative or absolute, are turned
+// into file: URLs before they're sent to Firefox, so that relative
+// names work and a '#fragment' on the end (as in
+// './docs/page.html#section3') opens the file at that anchor.
+//
+// Very large command lines (hundreds of URLs) are more than Firefox
+// will read at once, so with X we split them up into several command
+// lines, each with the same options. With -new-window, this gets you
+// several new windows.
+//
+// ffox-remote exits with status 0 if Firefox accepted the command
+// line (or if we can't tell, because there's no response), 2 if
+// Firefox said it failed (or a pre-hook refused to let us send it),
+// and 1 for other problems, such as not finding Firefox.
+//
+// With -v, we print Firefox's response and what we think it means;
+// otherwise we only print failure responses.
 //
 // BUGS:
 //
@@ -98,468 +895,242 @@ package main
 // Copyright: GPL v3
 
 import (
-	"bytes"
-	"encoding/binary"
+	"context"
 	"flag"
 	"fmt"
-	"io"
-	"log"
+	"log/slog"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
-	//"github.com/BurntSushi/xgb"
-	"github.com/BurntSushi/xgb/xproto"
-	"github.com/BurntSushi/xgbutil"
-	"github.com/BurntSushi/xgbutil/xevent"
-	"github.com/BurntSushi/xgbutil/xprop"
-	"github.com/BurntSushi/xgbutil/xwindow"
+	"github.com/siebenmann/ffox-remote/cmdline"
 )
 
-// The X property names that the Firefox remote control protocol uses.
-//
-// These are vars instead of consts because of a gory hack for Chris's
-// personal use.
-var (
-	lockProp = "_MOZILLA_LOCK"
-	cmdlProp = "_MOZILLA_COMMANDLINE"
-	respProp = "_MOZILLA_RESPONSE"
-	versProp = "_MOZILLA_VERSION"
-	// Mozilla user, profile (usually 'default'), and
-	// program name (usually 'firefox')
-	userProp = "_MOZILLA_USER"
-	profProp = "_MOZILLA_PROFILE"
-	progProp = "_MOZILLA_PROGRAM"
-)
+// listFlag is a flag that can be given more than once and takes
+// comma-separated values, accumulating all of them.
+type listFlag []string
 
-const (
-	// Current value for versProp. This is a *protocol* version, not
-	// a Firefox version.
-	firefoxVersion = "5.1"
-)
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
 
-// FIREFOX'S REMOTE CONTROL PROTOCOL
-//
-// The general remote control protocol goes like this:
-//
-// 1. Find a or the Firefox window. It will have WM_STATE and at least
-//    _MOZILLA_VERSION set on it. Make sure you think you understand
-//    the protocol version; we conservatively insist on it being exactly
-//    5.1.
-//
-// 2. Check that _MOZILLA_PROFILE, _MOZILLA_USER, and _MOZILLA_PROGRAM
-//    match so that you are talking to the right instance with the right
-//    profile. If you have found a Firefox window but it is the wrong
-//    profile et al, continue looking (return to step 1).
-//
-// 3. Obtain the remote control lock by being the person to set
-//    _MOZILLA_LOCK on the window. If you can't, wait for the
-//    _MOZILLA_LOCK property to go away and try again.
-//    (In theory the contents should be something that identify you, for
-//    help in debugging. In practice this doesn't matter; who's going to
-//    look?)
-//    The lock is necessary to prevent two different remote control
-//    clients from stomping over each other's efforts to send Firefox
-//    a command and read its reply. I don't think it's needed otherwise,
-//    but Firefox may look for it to be set or changed as a marker of
-//    something. Someday I may find out.
-//
-// 4. Set _MOZILLA_COMMANDLINE to the encoded Firefox command line. See
-//    the comment later on for how this is encoded, because it is crazy.
-//
-// 5. Wait for _MOZILLA_RESPONSE to be set and read it. In theory it is
-//    a SMTP/HTTP style 'Nxx <message>' response, where a '2xx' reply is
-//    success, a '5xx' is failure, a '1xx' means in progress, and there's
-//    some other prefixes too. In practice current versions of Firefox
-//    only ever send 200 or 5xx responses.
-//
-// 6. Release your ownership of _MOZILLA_LOCK by deleting the property.
-//
-// Note that because unlocking requires actively clearing a property,
-// it's possible for a fumbled remote control attempt to leave Firefox
-// in a 'locked' state. For this reason we support not trying to
-// acquire the lock (and we still clear the lock).
-
-// We use the low level X Atom values for locking and the response, so
-// we look them up at the start and remember them (effectively
-// interning them in the server).
-var lockatom, responseatom xproto.Atom
-
-func getAtom(xu *xgbutil.XUtil, aname string) xproto.Atom {
-	r, e := xprop.Atm(xu, aname)
-	if e != nil {
-		log.Fatal("getAtom:", e)
+func (l *listFlag) Set(v string) error {
+	for _, e := range strings.Split(v, ",") {
+		if e != "" {
+			*l = append(*l, e)
+		}
 	}
-	return r
+	return nil
 }
 
-func getAtoms(xu *xgbutil.XUtil) {
-	lockatom = getAtom(xu, lockProp)
-	responseatom = getAtom(xu, respProp)
+// levelFlag is a flag that looks like a boolean but raises a level
+// to n when it's given, or to a number it's given (as in -v=2).
+type levelFlag struct {
+	level *int
+	n     int
 }
 
-// ClientWindow finds the actual client window underneath what may be
-// a window manager frame. This is an implementation of
-// XmuClientWindow(), based on its documentation; we look through
-// direct children of the window for one with WM_STATE set, and if
-// there isn't one we return the window itself.
-func ClientWindow(xu *xgbutil.XUtil, win xproto.Window) xproto.Window {
-	tree, err := xproto.QueryTree(xu.Conn(), win).Reply()
-	if err != nil {
-		log.Fatal("c_w:", err)
+func (l *levelFlag) String() string {
+	if l.level == nil {
+		return "0"
 	}
-	for _, c := range tree.Children {
-		_, e := xprop.GetProperty(xu, c, "WM_STATE")
-		if e == nil {
-			return c
-		}
-	}
-	// whatever, man. we'll just return the original window as the
-	// best we can do.
-	return win
+	return strconv.Itoa(*l.level)
 }
 
-// propMatch returns true if val is empty or if the X property prop is set
-// to it. It works only for string properties.
-func propMatch(xu *xgbutil.XUtil, win xproto.Window, prop, val string) bool {
-	pv, e := xprop.GetProperty(xu, win, prop)
-	if e != nil {
-		return false
-	}
-	// unset value matches anything
-	return (val == "" || string(pv.Value) == val)
+func (l *levelFlag) IsBoolFlag() bool {
+	return true
 }
 
-// As of Firefox 131 or so, the 'profile' X property value is actually
-// the full path to the profile. This is also true for the D-Bus
-// version of the protocol. We cope by matching a full path if you
-// gave us one or only the suffix otherwise, so you can continue to
-// use plain profile names.
-func profileMatch(xu *xgbutil.XUtil, win xproto.Window, prop, val string) bool {
-	pv, e := xprop.GetProperty(xu, win, prop)
-	if e != nil {
-		return false
-	}
-	// unset value matches anything
-	sv := string(pv.Value)
-	if val == "" || sv == val {
-		return true
-	}
-	// If the property value starts with a /, we are dealing with
-	// the new Firefox 131 format. If the profile value to match
-	// against doesn't start with a /, assuming it is the old
-	// style name and match it against the '.<name>' at the end of
-	// the full profile path.
-	if sv[0] == '/' && val[0] != '/' &&
-		strings.HasSuffix(sv, "."+val) {
-		return true
-	}
-	return false
-}
-
-// Find the Firefox window for a specific user, profile, and program
-// (if they are set). The window must have the exact correct version.
-// On failure we return 0. We print a warning if we found what looks
-// like a Firefox window but it has a _MOZILLA_VERSION with the wrong
-// version; this is for debugging in case the version ever does change
-// again.
-//
-// (<jwz>'s old moz-remote.c preferred an exact match but would take
-// any window with a _MOZILLA_VERSION if it had to. This is no longer
-// fully viable and anyways this way is simpler code.)
-func findFirefox(xu *xgbutil.XUtil, user, profile, program string) xproto.Window {
-	var wrongver string
-	root := xu.RootWin()
-
-	// Find all children of the root window, which nominally will
-	// contain the Firefox window we are looking for.
-	tree, err := xproto.QueryTree(xu.Conn(), root).Reply()
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	for _, c := range tree.Children {
-		win := ClientWindow(xu, c)
-		pv, err := xprop.GetProperty(xu, win, versProp)
-		if err != nil {
-			continue
-		}
-		if string(pv.Value) != firefoxVersion {
-			wrongver = string(pv.Value)
-			continue
+func (l *levelFlag) Set(v string) error {
+	switch v {
+	case "true":
+		if l.n > *l.level {
+			*l.level = l.n
 		}
-		if propMatch(xu, win, userProp, user) &&
-			profileMatch(xu, win, profProp, profile) &&
-			propMatch(xu, win, progProp, program) {
-			return win
-		}
-	}
-	// We only get here if we failed to find a matching window.
-	// Code flow means we'll print this warning if we found both
-	// a wrong-version window and a right-version window with a
-	// mismatch in protocol et al.
-	if wrongver != "" {
-		log.Printf("found a protocol %s Firefox window but no %s one.", wrongver, firefoxVersion)
-	}
-	return 0
-}
-
-// waitForPropChange waits for the X property patom on window win to
-// change or disappear (ie, a PropertyNotify event for it). It returns
-// with the event and true if this happened; it returns with an
-// undefined event and false if the window was deleted instead.
-func waitForPropChange(xu *xgbutil.XUtil, win xproto.Window, patom xproto.Atom) (xevent.PropertyNotifyEvent, bool) {
-	var event xevent.PropertyNotifyEvent
-	good := false
-	done := false
-	// NOTE: these two are type casts, not function calls, because we
-	// have anonymous closures here.
-	xevent.PropertyNotifyFun(
-		func(xu *xgbutil.XUtil, ev xevent.PropertyNotifyEvent) {
-			if ev.Atom != patom {
-				return
-			}
-			event = ev
-			good = true
-			done = true
-			xevent.Quit(xu)
-		}).Connect(xu, win)
-	xevent.DestroyNotifyFun(
-		func(xu *xgbutil.XUtil, ev xevent.DestroyNotifyEvent) {
-			done = true
-			xevent.Quit(xu)
-		}).Connect(xu, win)
-
-	bchan, achan, qchan := xevent.MainPing(xu)
-	for !done {
-		select {
-		case <-bchan:
-			// do nothing.
-		case <-achan:
-			// do nothing
-		case <-qchan:
-			// Just to be sure.
-			done = true
-		}
-	}
-	xevent.Detach(xu, win)
-	xevent.Quit(xu) // just to be sure again
-
-	return event, good
-}
-
-// tryLock makes one attempt to obtain the magic Firefox lock property.
-// The protocol is that lockProp normally does not exist and you take
-// the lock by setting it. This must be done with the X server grabbed
-// so that no one else can do that at the same time.
-func tryLock(xu *xgbutil.XUtil, win xproto.Window) bool {
-	success := false
-	xu.Grab()
-	p, e := xprop.GetProperty(xu, win, lockProp)
-	if e != nil || len(p.Value) == 0 {
-		// In theory we should be informative here with the
-		// value we set. In practice there is no particular
-		// point; you have to go well out of your way to even
-		// see this property and advanced users might as well
-		// use -force to override a broken lock.
-		e = xprop.ChangeProp(xu, win, 8, lockProp, "STRING",
-			[]byte("ffox-remote.go on somewhere"))
-		success = (e == nil)
-	}
-	xu.Ungrab()
-	xu.Sync()
-	return success
-}
-
-// lockFirefox obtains the remote command invocation lock on the Firefox
-// window.
-// TODO: this should have a timeout. But then we'd need an X event
-// timeout. Simpler to punt.
-func lockFirefox(xu *xgbutil.XUtil, win xproto.Window) {
-	for {
-		res := tryLock(xu, win)
-		if res {
-			return
+	case "false":
+		*l.level = 0
+	default:
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return fmt.Errorf("bad level %q", v)
 		}
-		// Someone else has the property active. Wait for a
-		// property change on it.
-		_, good := waitForPropChange(xu, win, lockatom)
-		if !good {
-			log.Fatal("Firefox window disappeared")
-		}
-		// We don't bother checking the event state for
-		// PropertyDelete, because we don't care. If the
-		// property just changed value, we'll find out
-		// when we fail to get the lock.
-	}
-}
-
-// unlockFirefox unconditionally releases the remote command invocation
-// lock on the Firefox window. We are assumed to own it since we have
-// no simple choice.
-func unlockFirefox(xu *xgbutil.XUtil, win xproto.Window) {
-	// xproto does not expose the synchronous delete property of
-	// XGetWindowProperty(), so we assume that we are the owner
-	// and our ownership has not been overwritten.
-	_ = xproto.DeleteProperty(xu.Conn(), win, lockatom)
-}
-
-// getResponse gets the response to our Firefox remote command, which
-// appears in the value of respProp. We return "" if there is some
-// problem.
-// In theory a response starting with '1' is a 'things are in progress'
-// response. In practice modern versions of Firefox never emit this in
-// the first place and we don't really care anyways.
-func getResponse(xu *xgbutil.XUtil, win xproto.Window) string {
-	event, good := waitForPropChange(xu, win, responseatom)
-	if !good || event.State != xproto.PropertyNewValue {
-		return ""
-	}
-	p, r := xprop.GetProperty(xu, win, respProp)
-	if r == nil {
-		return string(p.Value)
-	}
-	return ""
-}
-
-// submitCommand sends our command to the remote Firefox window and
-// waits for its response, returning the response string.
-// We are given the already-encoded commandline property value.
-// Process: obtain lock, set cmdlProp to the value, wait for the response
-// property to be set (or the window to poof), unlock Firefox.
-func submitCommand(xu *xgbutil.XUtil, win xproto.Window, cmd []byte, force bool) string {
-	// We must start listening to PropertyNotify events on the
-	// target window before we start trying to lock the window,
-	// because otherwise there is a race between our lock attempt
-	// failing, the lock holder removing the property, and us
-	// starting to listen to the event that could leave us hanging
-	// with the property unlocked.
-	// The ice is thin here. Let's hope this doesn't come up often.
-	// (Maybe we need to start listening while having the server
-	// grabbed.)
-	// My approach here is at least no worse than existing code that
-	// has worked for years.
-	w := xwindow.New(xu, win)
-	e := w.Listen(xproto.EventMaskPropertyChange, xproto.EventMaskStructureNotify)
-	if e != nil {
-		log.Fatal("listen error:", e)
+		*l.level = n
 	}
+	return nil
+}
 
-	// If we're forced, we don't try to lock Firefox but we will unlock
-	// it. As a side effect this will unstick a Firefox that has been
-	// locked and never unlocked.
-	if !force {
-		lockFirefox(xu, win)
-	}
+// options are the settings from the command line that transports
+// care about.
+type options struct {
+	user, profile string
+	programs      []string
+	force         bool
+	verbose       bool
+	verbosity     int
+	legacy        bool
+	pfixes        []string
+	display       string
+	xauthority    string
+	scanDisplays  bool
+	monitor       string
+	ipc           bool
+	transport     string
+	protover      string
+	trace         string
+	noWait        bool
+	lockWait      time.Duration
+	stealLock     bool
+	history       string
+	audit         *auditLog
+	confirm       bool
+	tui           int
+	listFormat    string
+	window        uint32
+	preHook       string
+	postHook      string
+	dryRun        bool
+	timeout       time.Duration
+	title         *regexp.Regexp
+	class         *regexp.Regexp
+	host          string
+	anyOwner      bool
+	// profileAlt is a profile to try if nothing matches profile,
+	// which is how we fall back to 'default' when we've picked
+	// the default profile from profiles.ini.
+	profileAlt string
+}
 
-	// we can't use 'defer unlockFirefox()' because we're going
-	// to call log.Fatal().
-	e = xprop.ChangeProp(xu, win, 8, cmdlProp, "STRING", cmd)
-	if e != nil {
-		unlockFirefox(xu, win)
-		log.Fatal("command line change:", e)
-	}
+// When to ask which Firefox to use, for options.tui; see tui.go.
+const (
+	tuiAuto   = iota // when stdin is a terminal and instances differ
+	tuiAlways        // whenever there's a choice
+	tuiNever
+)
 
-	resp := getResponse(xu, win)
-	unlockFirefox(xu, win)
-	xu.Sync()
-	return resp
+// A transport is a way of getting a running Firefox to run a command
+// line for us. On Unix this is the X property protocol (xremote.go);
+// on Windows it's window messages (windows.go). newTransport creates
+// the transport for the platform we're on.
+type transport interface {
+	// list prints all of the Firefox instances we can find.
+	list()
+	// find locates the Firefox instance to talk to, or fails.
+	find(ctx context.Context) error
+	// describe prints what find found, for -find and -v.
+	describe()
+	// send has Firefox run the command line args (args[0] is the
+	// program) with the working directory cwd, returning its
+	// response (which may be no response). Waiting for Firefox
+	// (for its lock or its response) stops if ctx is done.
+	send(ctx context.Context, cwd string, args []string) (response, error)
 }
 
-// _MOZILLA_COMMANDLINE encoding
-// The following comment is taken from
-// toolkit/components/remote/nsXRemoteService.cpp :
-//
-// the commandline property is constructed as an array of int32_t
-// followed by a series of null-terminated strings:
-//
-// [argc][offsetargv0][offsetargv1...]<workingdir>\0<argv[0]>\0argv[1]...\0
-// (offset is from the beginning of the buffer)
-//
-// ---
-// Although not documented, the integers are little-endian.
-// In practice the pwd is ignored by Firefox right now (from what I can
-// tell).
-
-// addArgStr appends an argument to the argument buffer, returning its
-// length plus the trailing 0 byte.
-func addArgStr(w io.Writer, s string) int {
-	n, e := w.Write([]byte(s))
-	if e != nil {
-		log.Fatal("encoding", e)
+// splitCommandLine splits a command line that encodes to more than
+// max bytes into several smaller ones that don't. Each of them gets
+// the program and the leading options (such as -new-tab); the rest
+// of the arguments are divided up between them.
+func splitCommandLine(pwd string, args []string, max int) ([][]string, error) {
+	nopts := 1
+	for nopts < len(args) && strings.HasPrefix(args[nopts], "-") {
+		nopts++
 	}
-	n2, e := w.Write([]byte{0})
-	if e != nil {
-		log.Fatal("encoding 0", e)
-	}
-	return n + n2
-}
-
-// encodeCommandLine encodes a command line as summarized above.
-// We encode in two passes. In the first pass we create a string
-// of all of the arguments and set up the array of offsets. In
-// the second pass we encode the offsets themselves and concatenate
-// the encoded argument string on the end.
-func encodeCommandLine(pwd string, args []string) []byte {
-	buf := new(bytes.Buffer)
-	arenc := new(bytes.Buffer)
-
-	arr := make([]uint32, len(args)+1)
-	// arr[0] is argc. arr[i > 0] is the offset of args[i-1] in
-	// the argument string.
-	arr[0] = uint32(len(args))
-
-	// the arr argument position array takes up four bytes per
-	// element, so this is the initial offset of the start of the
-	// argument strings.
-	off := len(arr) * 4
-
-	// build the argument string, remembering our running offset.
-	// The working directory does not appear in the array, but it
-	// has to be encoded anyways.
-	off += addArgStr(arenc, pwd)
-	for i := range args {
-		arr[i+1] = uint32(off)
-		off += addArgStr(arenc, args[i])
-	}
-
-	// Build the final result with the little endian encoded arr
-	// on the front and then the argument strings.
-	e := binary.Write(buf, binary.LittleEndian, arr)
-	if e != nil {
-		log.Fatal("encode arrray", e)
-	}
-	_, e = buf.Write(arenc.Bytes())
-	if e != nil {
-		log.Fatal("encode add arguments", e)
+	head := args[:nopts:nopts]
+	var cls [][]string
+	cur := head
+	for _, a := range args[nopts:] {
+		next := append(cur[:len(cur):len(cur)], a)
+		if len(cmdline.Encode(pwd, next)) <= max {
+			cur = next
+			continue
+		}
+		if len(cur) == len(head) {
+			return nil, fmt.Errorf("argument too long for Firefox: %.40q...", a)
+		}
+		cls = append(cls, cur)
+		cur = append(head, a)
+		if len(cmdline.Encode(pwd, cur)) > max {
+			return nil, fmt.Errorf("argument too long for Firefox: %.40q...", a)
+		}
 	}
-	return buf.Bytes()
-}
-
-// Rewrite all of our property names to have a different prefix.
-// This is a gory hack to keep the rest of the code simple because
-// Chris can't think of a better way right now.
-func fixupPref(pfix string, elems ...*string) {
-	plen := len("_MOZILLA")
-	for _, e := range elems {
-		us := *e
-		ns := fmt.Sprintf("%s%s", pfix, us[plen:])
-		*e = ns
+	if len(cur) > len(head) || len(cls) == 0 {
+		cls = append(cls, cur)
 	}
+	return cls, nil
 }
 
 func main() {
 	// Set Unix-like logging: to stderr, no timestamps, and our program
-	// name as a prefix.
-	log.SetPrefix("ffox-remote: ")
-	log.SetFlags(0)
+	// name as a prefix. -log-format and -log-level can change this
+	// once we've parsed our arguments; see logging.go.
+	_ = setupLogging("plain", "info")
+	defer cleanup()
 
-	user := flag.String("U", "", "Firefox user to match against")
+	user := flag.String("U", "", "Firefox user to match against ('auto' for you)")
 	profile := flag.String("P", "default", "Firefox profile to match against")
 	program := flag.String("G", "firefox", "Firefox program name to match against")
+	anyowner := flag.Bool("any-owner", false, "Talk to a Firefox even if it isn't running as us")
 	force := flag.Bool("force", false, "Force us to go on even without the X window lock")
-	pfix := flag.String("pref", "", "Non-default X property prefix (hack)")
+	nowait := flag.Bool("no-wait", false, "Don't wait for Firefox's response")
+	lockwait := flag.Duration("lock-wait", 10*time.Second, "How long to wait for someone else's lock on Firefox (0 is forever)")
+	steal := flag.Bool("steal-lock", false, "Take over Firefox's lock if -lock-wait runs out")
+	display := flag.String("display", "", "X display to use instead of $DISPLAY")
+	xauth := flag.String("xauthority", "", "X authority file to use instead of $XAUTHORITY")
+	scan := flag.Bool("scan-displays", false, "Look for Firefox on all local X displays")
+	titlere := flag.String("title", "", "Only match Firefox windows with titles matching this regular expression")
+	classre := flag.String("class", "", "Only match Firefox windows with a WM_CLASS matching this regular expression")
+	hostf := flag.String("host", "", "Only match Firefox windows from this machine (their WM_CLIENT_MACHINE)")
+	monitor := flag.String("monitor", "", "Prefer Firefox windows on this monitor ('pointer' for the pointer's)")
+	dbus := flag.Bool("dbus", false, "Talk to Firefox through D-Bus instead of X")
+	transportf := flag.String("transport", "", "Talk to Firefox through this transport (one of: "+strings.Join(transportNames(), ", ")+")")
+	ipc := flag.Bool("ipc", false, "Find Firefox windows through i3 or sway's IPC")
+	serve := flag.String("serve", "", "Act as a Firefox remote control window and run this for each command line")
+	appsvc := flag.String("app-service", "", "Serve org.freedesktop.Application on the session bus as this application ID")
+	sockact := flag.Bool("socket-activated", false, "Serve requests on the sockets that systemd socket activation passes us")
+	bridge := flag.String("bridge", "", "Pass on command lines sent under this property prefix to Firefox")
+	watch := flag.Bool("watch", false, "Watch for matching Firefox windows appearing and disappearing")
+	watchcmd := flag.String("watch-exec", "", "Shell command to run when -watch sees something")
+	cfgfile := flag.String("config", "", "Configuration file to use instead of the default")
+	recfile := flag.String("record", "", "Append a record of each command line sent to this file")
+	listfmt := flag.String("list-format", "", "Format for -list lines, eg '0x{id}\\t{profile}\\t{program}'")
+	window := flag.String("window", "", "Use this Firefox window (an ID or a line from -list) instead of looking")
+	tui := flag.Bool("tui", false, "Ask which Firefox to use with a menu on the terminal")
+	dryrun := flag.Bool("dry-run", false, "Find Firefox and show exactly what we would send it, without sending anything")
+	confirm := flag.Bool("confirm", false, "Show each command line and ask on the terminal before sending it")
+	auditfile := flag.String("audit", "", "Refuse to send anything unless it can be recorded in this audit log")
+	histfile := flag.String("history", "", "The history file to record command lines in and for history")
+	var pfixes listFlag
+	flag.Var(&pfixes, "pref", "Non-default X property prefix or prefixes to try (hack)")
+	retries := flag.Int("retry", 0, "Look for Firefox this many more times if it's not there")
+	retryint := flag.Duration("retry-interval", time.Second, "How long to wait between -retry attempts")
+	waitfox := flag.Duration("wait-for-firefox", 0, "Wait up to this long for a matching Firefox to appear")
 	find := flag.Bool("find", false, "Find the Firefox window and exit")
-	verb := flag.Bool("v", false, "extra verbosity")
+	findfmt := flag.String("find-format", "", "Report what -find found as 'kv' (KEY=VALUE lines) or 'json'")
+	dump := flag.Bool("dump", false, "Print the Firefox window's remote control properties and exit")
+	setprop := flag.String("set-prop", "", "Set the Firefox window's property NAME=VALUE and exit")
+	list := flag.Bool("list", false, "List all Firefox windows and exit")
+	var verbosity int
+	flag.Var(&levelFlag{&verbosity, 1}, "v", "extra verbosity (-vv and -vvv for more)")
+	flag.Var(&levelFlag{&verbosity, 2}, "vv", "Also report protocol details, such as property changes and locking")
+	flag.Var(&levelFlag{&verbosity, 3}, "vvv", "Also report X event details")
+	cwdflag := flag.String("cwd", "", "Working directory to send to Firefox ('none' for none)")
+	benchn := flag.Int("n", 10, "How many command lines to send for bench and stress")
+	stressc := flag.Int("c", 4, "How many concurrent senders to use for stress")
+	var tees listFlag
+	flag.Var(&tees, "tee", "Send the same thing to each of these instances or profiles")
+	var pick listFlag
+	encode := flag.Bool("encode", false, "Compress into mozlz4 instead of decompressing, for mozlz4")
+	flag.Var(&pick, "pick", "Windows (N) or tabs (N.T) to reopen for session, or entries to re-send for history")
+	timeit := flag.Bool("timings", false, "Report how long each phase of talking to Firefox took")
+	quiet := flag.Bool("quiet", false, "Print nothing, not even errors; only the exit status says what happened")
+	logfmt := flag.String("log-format", "plain", "How to format log messages: plain, text, or json")
+	loglevel := flag.String("log-level", "info", "The lowest level of log messages to print: debug, info, warn, or error")
+	tracefile := flag.String("trace", "", "Log X operations to this file ('-' for standard error)")
 	// In theory we could make users type 'ffox-remote ... -- -new-window'
 	// in order to have -new-window and -new-tab be passed to Firefox.
 	// In practice that is user-hostile, so we accept them as arguments
@@ -567,31 +1138,431 @@ func main() {
 	nw := flag.Bool("new-window", false, "Pass -new-window to Firefox")
 	nt := flag.Bool("new-tab", false, "Pass -new-tab to Firefox")
 	search := flag.Bool("search", false, "Pass -search to Firefox to do a search")
+	extract := flag.Bool("extract", false, "Open all of the URLs found in standard input")
+	searchsel := flag.Bool("search-selection", false, "Search for the X PRIMARY selection (or the clipboard with -clipboard)")
+	selectionf := flag.Bool("selection", false, "Open the X PRIMARY selection (what's highlighted) as a URL")
+	clipboard := flag.Bool("clipboard", false, "Open the X clipboard as a URL")
+	kiosk := flag.Bool("kiosk", false, "Pass -kiosk to Firefox, after checking that it's running in kiosk mode")
+	clean := flag.Bool("clean", false, "Strip terminal hyperlink escapes, brackets, quotes, and trailing punctuation from URLs")
+	markdown := flag.String("markdown", "", "Open all of the links in this Markdown file ('-' for standard input)")
+	mdheading := flag.String("heading", "", "With -markdown, only open links under this heading")
+	opml := flag.String("opml", "", "Open the sites of all of the feeds in this OPML file (FILE or FILE:TITLE)")
+	opmlfeeds := flag.Bool("opml-feeds", false, "With -opml, open the feeds themselves instead of their sites")
+	bookmarks := flag.String("bookmarks", "", "Open all of the links in this bookmarks file (FILE or FILE:FOLDER)")
+	legacy := flag.Bool("legacy", false, "Always use the legacy _MOZILLA_COMMAND protocol")
+	remotef := flag.String("remote", "", "Do this old 'firefox -remote' command, eg 'openURL(URL,new-tab)'")
+	via := flag.String("via", "", "Run ffox-remote on this SSH destination instead")
+	viacmd := flag.String("via-cmd", "ffox-remote", "The ffox-remote command to run for -via")
+	wsl := flag.Bool("wsl", false, "Always hand off to Windows Firefox under WSL")
+	portal := flag.Bool("portal-fallback", false, "Open URLs through the desktop portal if there's no Firefox window")
+	protover := flag.String("protocol-version", "", "Also accept this _MOZILLA_VERSION as the current protocol")
+	holdlock := flag.Duration("hold-lock", 0, "Take Firefox's lock and hold it this long, reporting what other clients do")
+	pingf := flag.Bool("ping", false, "Check that Firefox answers a command line that does nothing, and report how long it took")
+	spreadf := flag.Bool("spread", false, "Spread the URLs round-robin across all matching Firefox instances")
+	shotout := flag.String("o", "", "With screenshot, write the PNG to this file ('-' for standard output)")
+	fullpage := flag.Bool("full-page", false, "With screenshot, capture the whole page instead of what fits in the window")
+	timeout := flag.Duration("timeout", 0, "Give up on finding and talking to Firefox after this long (0 is never)")
+	// -tolerant is dealt with before we parse the options; see
+	// tolerant.go.
+	flag.Bool("tolerant", false, "Ignore unknown options, empty arguments, and unfilled placeholders like '%s'")
+	showver := flag.Bool("version", false, "Print our version and the protocol versions we and Firefox speak, then exit")
+
+	// Subcommands come before any options, so we have to pull
+	// them off ourselves.
+	// Run as 'firefox', we take Firefox's arguments instead of
+	// ours and have no subcommands (see firefoxargs.go).
+	sub := ""
+	argv := os.Args[1:]
+	asFirefox := invokedAsFirefox()
+	if len(argv) > 0 && subcommands[argv[0]] && !asFirefox {
+		sub = argv[0]
+		argv = argv[1:]
+	}
+	if asFirefox || sub == "firefox" {
+		fa, err := firefoxArgs(argv)
+		if err != nil {
+			fatal("", "err", err)
+		}
+		sub, argv = "", fa
+	}
+	var dropped []string
+	if wantsTolerant(argv) {
+		argv, dropped = tolerantArgs(flag.CommandLine, argv)
+	}
+	_ = flag.CommandLine.Parse(argv)
+	// 'screenshot URL -o FILE' is the natural order, but Go's flag
+	// parsing stops at the URL, so we pick it off and parse the
+	// rest again.
+	shotURL := ""
+	if sub == "screenshot" && flag.NArg() > 0 {
+		shotURL = flag.Arg(0)
+		_ = flag.CommandLine.Parse(flag.Args()[1:])
+	}
+	// -vv and -vvv log their details below info level; see
+	// trace.go.
+	switch {
+	case *quiet:
+		*loglevel = "none"
+		verbosity = 0
+	case *loglevel != "info":
+	case verbosity >= 3:
+		*loglevel = levelEvent.String()
+	case verbosity == 2:
+		*loglevel = levelProto.String()
+	}
+	if err := setupLogging(*logfmt, *loglevel); err != nil {
+		fatal("", "err", err)
+	}
+	if len(dropped) > 0 && verbosity > 0 {
+		slog.Info("ignoring arguments", "args", fmt.Sprintf("%q", dropped))
+	}
+
+	// The mode subcommands are the plain form and the mode
+	// options under other names, so once we've checked that
+	// nothing else asks for another mode, we turn them into
+	// the options and forget about them. prefs is opening the
+	// settings page it names, and profiles is opening
+	// about:profiles after listing the profiles.
+	prefsPage := ""
+	showProfiles := false
+	if modeSubcommands[sub] {
+		flag.Visit(func(f *flag.Flag) {
+			if modeFlags[f.Name] {
+				fatal(sub+" can't be used with", "err", "-"+f.Name)
+			}
+		})
+		switch sub {
+		case "search":
+			*search = true
+		case "find":
+			*find = true
+		case "list":
+			*list = true
+		case "ping":
+			*pingf = true
+		case "prefs":
+			u, err := prefsURL(flag.Args())
+			if err != nil {
+				fatal("prefs", "err", err)
+			}
+			prefsPage = u
+		case "profiles":
+			if flag.NArg() > 0 {
+				fatal("profiles: takes no arguments")
+			}
+			prefsPage, showProfiles = prefPages["profiles"], true
+		}
+		sub = ""
+	}
+
+	// -search-selection is -search of the selection, normally
+	// PRIMARY but the clipboard if you ask for it.
+	if *searchsel {
+		*search = true
+		*selectionf = !*clipboard
+	}
+
+	// 'completion profiles' is for the completion scripts
+	// themselves, to get -P's possible values.
+	if sub == "completion" {
+		if flag.NArg() != 1 {
+			fatal("completion: need one of bash, zsh, or fish")
+		}
+		if flag.Arg(0) == "profiles" {
+			cfg, err := loadConfig(*cfgfile)
+			if err != nil {
+				fatal("config", "err", err)
+			}
+			for _, n := range completionProfiles(cfg) {
+				fmt.Println(n)
+			}
+			return
+		}
+		if err := writeCompletion(os.Stdout, flag.Arg(0)); err != nil {
+			fatal("completion", "err", err)
+		}
+		return
+	}
+
+	if sub == "audit" {
+		if err := auditFiles(flag.Args()); err != nil {
+			fatal("audit", "err", err)
+		}
+		return
+	}
+
+	// -remote gets turned into the options and arguments it
+	// means.
+	fargs := flag.Args()
+	if prefsPage != "" {
+		fargs = []string{prefsPage}
+	}
+	if *remotef != "" {
+		if sub != "" || prefsPage != "" || flag.NArg() > 0 {
+			fatal("-remote can't be used with subcommands or other arguments")
+		}
+		flag.Visit(func(f *flag.Flag) {
+			if modeFlags[f.Name] || f.Name == "new-tab" || f.Name == "new-window" || f.Name == "spread" || f.Name == "selection" || f.Name == "clipboard" {
+				fatal("-remote can't be used with", "err", "-"+f.Name)
+			}
+		})
+		rc, err := parseRemote(*remotef)
+		if err != nil {
+			fatal("-remote", "err", err)
+		}
+		*pingf = rc.ping
+		*nw = rc.where == "new-window"
+		*nt = rc.where == "new-tab"
+		fargs = rc.args
+	}
+
+	// With -audit, everything we send has to go through sendOne
+	// so that it gets recorded, and we can't hand things off to
+	// anything else.
+	// -confirm is the same for the other ways of opening URLs.
+	if *confirm && (*via != "" || *wsl || *portal) {
+		fatal("-confirm can't be used with -via, -wsl, or -portal-fallback")
+	}
+	// -dry-run can show what we'd send, set, or quit, but the
+	// things that run for a while or only make sense if they
+	// really happen can't be done halfway.
+	if *dryrun {
+		if sub == "bench" || sub == "stress" || sub == "screenshot" {
+			fatal("-dry-run can't be used with", "err", sub)
+		}
+		flag.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "hold-lock", "watch", "serve", "bridge", "app-service", "socket-activated":
+				fatal("-dry-run can't be used with", "err", "-"+f.Name)
+			}
+		})
+	}
+	if *selectionf && *clipboard {
+		fatal("-selection and -clipboard can't be used together")
+	}
+	if *spreadf && (*search || sub != "") {
+		fatal("-spread can only open URLs, not search or run subcommands")
+	}
+	if *kiosk && (*search || sub != "") {
+		fatal("-kiosk can only open URLs, not search or run subcommands")
+	}
+	if len(tees) > 0 {
+		flag.Visit(func(f *flag.Flag) {
+			if (modeFlags[f.Name] && f.Name != "search" && f.Name != "search-selection") || f.Name == "P" || f.Name == "window" || f.Name == "spread" {
+				fatal("-tee can't be used with", "err", "-"+f.Name)
+			}
+		})
+		if *find || *list || *pingf || sub != "" {
+			fatal("-tee can only open URLs or search, not find, list, ping, or run subcommands")
+		}
+	}
+	if *auditfile != "" {
+		switch {
+		case *via != "" || *wsl || *portal:
+			fatal("-audit can't be used with -via, -wsl, or -portal-fallback")
+		case *watch || *bridge != "" || *setprop != "":
+			fatal("-audit can't be used with -watch, -bridge, or -set-prop")
+		case sub == "bench" || sub == "stress":
+			fatal("-audit can't be used with", "err", sub)
+		}
+	}
 
-	flag.Parse()
+	if *via != "" {
+		runVia(*via, *viacmd, sub)
+	}
 
-	// This is a gory hack. Don't ask.
-	if *pfix != "" {
-		fixupPref(*pfix, &lockProp, &cmdlProp, &respProp, &versProp, &userProp, &profProp, &progProp)
+	if sub == "decode" {
+		if err := decodeFiles(flag.Args()); err != nil {
+			fatal("decode", "err", err)
+		}
+		return
 	}
 
-	xu, err := xgbutil.NewConn()
+	if sub == "mozlz4" {
+		if *encode && flag.NArg() > 1 {
+			fatal("mozlz4: can only -encode one file at a time")
+		}
+		if err := mozlz4Files(*encode, flag.Args()); err != nil {
+			fatal("mozlz4", "err", err)
+		}
+		return
+	}
+
+	// We read the session store before anything else so that
+	// 'session -list' doesn't need Firefox at all.
+	var sessGroups [][]string
+	if sub == "session" {
+		if flag.NArg() != 1 {
+			fatal("session: need exactly one FILE or PROFILE-DIRECTORY")
+		}
+		sess, e := readSession(flag.Arg(0))
+		if e != nil {
+			fatal("session", "err", e)
+		}
+		if *list {
+			sess.list()
+			return
+		}
+		if sessGroups, e = sess.pick(pick); e != nil {
+			fatal("session", "err", e)
+		}
+		if len(sessGroups) == 0 {
+			fatal("session: nothing to reopen")
+		}
+		if *search {
+			fatal("session: can't search")
+		}
+	}
+
+	// If we weren't given an explicit program name, we look for
+	// Firefox forks too.
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	programs := forkPrograms
+	if set["G"] {
+		programs = []string{*program}
+	}
+	o := &options{user: *user, profile: *profile, programs: programs,
+		force: *force, verbose: verbosity > 0, verbosity: verbosity, legacy: *legacy,
+		pfixes: pfixes, protover: *protover, trace: *tracefile, noWait: *nowait,
+		lockWait: *lockwait, stealLock: *steal, history: *histfile,
+		confirm: *confirm, listFormat: *listfmt, dryRun: *dryrun, timeout: *timeout,
+		display: *display, xauthority: *xauth,
+		scanDisplays: *scan, monitor: *monitor, ipc: *ipc, host: *hostf,
+		anyOwner: *anyowner, transport: *transportf}
+
+	// -P may name an instance from the configuration file, which
+	// fills in everything that wasn't given explicitly.
+	cfg, err := loadConfig(*cfgfile)
 	if err != nil {
-		log.Fatal("X connection:", err)
+		fatal("config", "err", err)
+	}
+	if *findfmt != "" {
+		if err := checkFindFormat(*findfmt); err != nil {
+			fatal("-find-format", "err", err)
+		}
+		*find = true
+	}
+	if *listfmt != "" {
+		if err := checkListFormat(*listfmt); err != nil {
+			fatal("-list-format", "err", err)
+		}
+	}
+	if strings.ContainsAny(*profile, "*?[") {
+		if _, err := filepath.Match(*profile, ""); err != nil {
+			fatal("-P", "err", err)
+		}
+	}
+	if *titlere != "" {
+		if o.title, err = regexp.Compile(*titlere); err != nil {
+			fatal("-title", "err", err)
+		}
+	}
+	if *classre != "" {
+		if o.class, err = regexp.Compile(*classre); err != nil {
+			fatal("-class", "err", err)
+		}
+	}
+	if *window != "" {
+		id, err := parseWindowID(*window)
+		if err != nil {
+			fatal("-window", "err", err)
+		}
+		o.window = id
 	}
-	getAtoms(xu)
 
-	// Locate the command window (or a command window) for the running
-	// Firefox.
-	foxwin := findFirefox(xu, *user, *profile, *program)
-	if foxwin == 0 {
-		log.Fatal("can't find a running Firefox window.")
+	if *dbus {
+		if set["transport"] && *transportf != "dbus" {
+			fatal("-dbus can't be used with", "err", "-transport "+*transportf)
+		}
+		o.transport = "dbus"
 	}
-	if *find || *verb {
-		fmt.Printf("firefox window: 0x%x\n", foxwin)
-		if *find {
+
+	// -tui=false turns off the automatic menu.
+	switch {
+	case set["tui"] && *tui:
+		o.tui = tuiAlways
+	case set["tui"]:
+		o.tui = tuiNever
+	}
+
+	o.preHook, o.postHook = cfg.preHook, cfg.postHook
+	if cfg.user != "" && !set["U"] {
+		o.user = cfg.user
+	}
+	if in := cfg.instances[*profile]; in != nil {
+		in.apply(o, set)
+	}
+	if o.user == "auto" {
+		o.user = loginName()
+	}
+	// Without -P, we want Firefox's own default profile, which
+	// hasn't been called 'default' for a long time. Windows
+	// Firefox doesn't put the profile in its remote window's
+	// name unless you give it one, so there we want the window
+	// without a profile name (see windows.go).
+	switch {
+	case set["P"] || cfg.instances[*profile] != nil:
+	case runtime.GOOS == "windows":
+		o.profile = ""
+	default:
+		if p, ok := defaultProfile(); ok {
+			if o.verbose {
+				slog.Info("using the default profile from profiles.ini", "profile", p.name, "path", p.path)
+			}
+			o.profile, o.profileAlt = p.path, o.profile
+		}
+	}
+	if o.history == "" {
+		o.history = cfg.history
+	}
+	if *auditfile != "" {
+		if o.audit, err = openAudit(*auditfile); err != nil {
+			fatal("audit", "err", err)
+		}
+		atExit(func() { o.audit.close() })
+	}
+
+	// Finding and talking to Firefox gives up if we're
+	// interrupted or -timeout runs out. The things that run until
+	// they're killed call stop() first, so that signals kill them
+	// the usual way.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	if *showver {
+		showVersion(ctx, o)
+		return
+	}
+
+	// Listing the history doesn't need Firefox.
+	var histRecs []record
+	if sub == "history" {
+		fn := o.history
+		if flag.NArg() > 0 {
+			fn = flag.Arg(0)
+		}
+		if fn == "" {
+			fatal("history: no history file (use -history or set one in the configuration file)")
+		}
+		recs, e := readRecords(fn)
+		if e != nil {
+			fatal("history", "err", e)
+		}
+		if len(pick) == 0 {
+			listHistory(recs)
 			return
 		}
+		if histRecs, e = pickRecords(recs, pick); e != nil {
+			fatal("history", "err", e)
+		}
 	}
 
 	args := []string{"firefox"}
@@ -608,29 +1579,708 @@ func main() {
 		args = append(args, "-search")
 		count++
 	}
+	if *kiosk {
+		args = append(args, "-kiosk")
+	}
 	if count > 1 {
-		log.Fatal("conflicting arguments:", strings.Join(args[1:], " "))
+		fatal("conflicting arguments", "args", strings.Join(args[1:], " "))
+	}
+
+	// Some options read URLs from somewhere and open them all as
+	// tabs in a new window (unless -new-tab says to use the current
+	// window instead).
+	var inputURLs []string
+	if *bookmarks != "" {
+		urls, e := bookmarkURLs(*bookmarks)
+		if e != nil {
+			fatal("bookmarks", "err", e)
+		}
+		inputURLs = append(inputURLs, urls...)
+	}
+	if *extract {
+		urls, e := readURLs(os.Stdin, *clean)
+		if e != nil {
+			fatal("extract", "err", e)
+		}
+		if len(urls) == 0 {
+			fatal("extract: no URLs found")
+		}
+		inputURLs = append(inputURLs, urls...)
+	}
+	if *markdown != "" {
+		urls, e := markdownFileURLs(*markdown, *mdheading)
+		if e != nil {
+			fatal("markdown", "err", e)
+		}
+		inputURLs = append(inputURLs, urls...)
+	}
+	if *opml != "" {
+		urls, e := opmlFileURLs(*opml, *opmlfeeds)
+		if e != nil {
+			fatal("opml", "err", e)
+		}
+		inputURLs = append(inputURLs, urls...)
+	}
+	if len(inputURLs) > 0 {
+		if *search {
+			fatal("can't search for URLs read from files")
+		}
+		if count == 0 {
+			args = append(args, "-new-window")
+		}
 	}
 
 	cwd, e := os.Getwd()
 	if e != nil {
-		log.Print("cannot get current directory:", e)
+		slog.Warn("cannot get current directory", "err", e)
 		cwd = "/"
 	}
+	switch *cwdflag {
+	case "":
+	case "none":
+		cwd = ""
+	default:
+		if cwd, e = filepath.Abs(*cwdflag); e != nil {
+			fatal("-cwd", "err", e)
+		}
+	}
 	// If we are given -search we do the convenient thing by
 	// turning all of the rest of the arguments into a single
 	// search term. Otherwise Firefox searches for the first
 	// argument and opens the rest of them as URLs, which is
 	// not really what you generally want.
+	nopts := len(args)
+	if *selectionf || *clipboard {
+		which := "PRIMARY"
+		if *clipboard {
+			which = "CLIPBOARD"
+		}
+		text, e := readSelection(o, which)
+		if e != nil {
+			fatal("selection", "err", e)
+		}
+		if text = strings.TrimSpace(text); text == "" {
+			fatal("the " + which + " selection is empty")
+		}
+		// Highlighted text often spans lines, which a search
+		// doesn't want.
+		if *search {
+			text = strings.Join(strings.Fields(text), " ")
+		}
+		fargs = append(fargs, text)
+	}
+	if *clean && !*search {
+		fargs = cleanURLs(fargs)
+	}
 	if *search {
-		args = append(args, strings.Join(flag.Args(), " "))
+		args = append(args, strings.Join(fargs, " "))
 	} else {
-		args = append(args, flag.Args()...)
+		args = append(args, fargs...)
+		args = append(args, inputURLs...)
+	}
+
+	// If we can't find Firefox at all, we may be able to hand
+	// things off to Windows Firefox under WSL (see wsl.go) or be
+	// allowed to fall back to the desktop portal (see portal.go).
+	// Neither makes sense for -find, -list, or subcommands, and the
+	// portal can't search. Windows Firefox wants the original
+	// arguments, not the file: URLs we may turn them into later.
+	plainArgs := args
+	noFirefox := func(msg string) {
+		var e error
+		switch {
+		case *find || *list || *pingf || *holdlock > 0 || *dryrun || *dump || *setprop != "" || sub != "":
+			fatal(msg)
+		case inWSL():
+			if o.verbose {
+				slog.Info(msg + "; handing off to Windows Firefox")
+			}
+			e = wslOpen(plainArgs[1:])
+		case *portal && !*search:
+			if o.verbose {
+				slog.Info(msg + "; falling back to the desktop portal")
+			}
+			e = portalOpen(fargs)
+		default:
+			fatal(msg)
+		}
+		if e != nil {
+			fatal("", "err", e)
+		}
+		exit(0)
+	}
+
+	if *wsl && !*find && !*list && !*pingf && !*dryrun && sub == "" {
+		if e := wslOpen(args[1:]); e != nil {
+			fatal("", "err", e)
+		}
+		return
+	}
+
+	// Files (with or without fragments) become file: URLs; see
+	// files.go. A search term is never a file.
+	if !*search {
+		args = fileArgs(cwd, args)
+	}
+
+	if *timeit {
+		startTimings()
+		atExit(reportTimings)
+	}
+
+	if len(tees) > 0 {
+		if !tee(ctx, tees, o, cfg, set, *recfile, cwd, args) {
+			exit(2)
+		}
+		return
+	}
+
+	// If we can't set up the transport or find Firefox through
+	// it, there may be another transport that will work (on
+	// Wayland, D-Bus).
+	t, err := newTransport(o)
+	if err != nil {
+		if t = altTransport(o, nil); t == nil {
+			noFirefox(err.Error())
+		}
+	}
+
+	if showProfiles {
+		var states map[string]string
+		if pr, ok := t.(profileReporter); ok {
+			states = pr.profileStates()
+		}
+		printProfiles(states)
+	}
+
+	if *list {
+		t.list()
+		return
+	}
+
+	if *serve != "" {
+		stop()
+		s, ok := t.(server)
+		if !ok {
+			fatal("-serve is not supported by this transport")
+		}
+		if err := s.serve(*serve); err != nil {
+			fatal("serve", "err", err)
+		}
+		return
+	}
+
+	if *watch {
+		stop()
+		w, ok := t.(watcher)
+		if !ok {
+			fatal("-watch is not supported by this transport")
+		}
+		if err := w.watch(*watchcmd, cwd, args, len(args) > 1); err != nil {
+			fatal("watch", "err", err)
+		}
+		return
+	}
+
+	if *appsvc != "" {
+		stop()
+		if err := runAppService(t, o, *recfile, cwd, *appsvc); err != nil {
+			fatal("app-service", "err", err)
+		}
+		return
+	}
+
+	if *sockact {
+		stop()
+		if err := runSocketService(t, o, *recfile, cwd); err != nil {
+			fatal("socket-activated", "err", err)
+		}
+		return
+	}
+
+	if *waitfox > 0 {
+		if err := waitForFirefox(ctx, t, *waitfox); err != nil && o.verbose {
+			slog.Warn("", "err", err)
+		}
+	}
+	if err := findRetrying(ctx, t, *retries, *retryint, o.verbose); err != nil {
+		alt := altTransport(o, t)
+		if alt == nil || alt.find(ctx) != nil {
+			noFirefox(err.Error())
+		}
+		t = alt
+	}
+	if *findfmt != "" {
+		if err := printFound(t, *findfmt); err != nil {
+			fatal("find", "err", err)
+		}
+		return
+	}
+	if *find || o.verbose {
+		t.describe()
+		if *find {
+			return
+		}
+	}
+
+	if *pingf {
+		if o.dryRun {
+			err = dryRun(t, cwd, pingArgs)
+		} else {
+			err = ping(ctx, t, cwd)
+		}
+		if err != nil {
+			fatal("ping", "err", err)
+		}
+		return
+	}
+
+	if *dump {
+		d, ok := t.(dumper)
+		if !ok {
+			fatal("-dump is not supported by this transport")
+		}
+		if err := d.dump(); err != nil {
+			fatal("dump", "err", err)
+		}
+		return
+	}
+
+	if *setprop != "" {
+		ps, ok := t.(propSetter)
+		if !ok {
+			fatal("-set-prop is not supported by this transport")
+		}
+		nv := strings.SplitN(*setprop, "=", 2)
+		if len(nv) != 2 {
+			fatal("-set-prop: must be NAME=VALUE")
+		}
+		if err := ps.setProp(nv[0], nv[1]); err != nil {
+			fatal("set-prop", "err", err)
+		}
+		return
+	}
+
+	if *holdlock > 0 {
+		h, ok := t.(lockHolder)
+		if !ok {
+			fatal("-hold-lock is not supported by this transport")
+		}
+		if err := h.holdLock(ctx, *holdlock); err != nil {
+			fatal("hold-lock", "err", err)
+		}
+		return
+	}
+
+	if sub == "unlock" {
+		u, ok := t.(unlocker)
+		if !ok {
+			fatal("unlock: not supported by this transport")
+		}
+		if err := u.unlock(); err != nil {
+			fatal("unlock", "err", err)
+		}
+		return
+	}
+
+	if sub == "quit" || sub == "restart" {
+		q, ok := t.(quitter)
+		if !ok {
+			fatal(sub + ": not supported by this transport")
+		}
+		if flag.NArg() > 0 {
+			fatal(sub + ": takes no arguments")
+		}
+		if err := q.quit(ctx, sub == "restart"); err != nil {
+			fatal(sub, "err", err)
+		}
+		return
+	}
+
+	if sub == "screenshot" {
+		pl, ok := t.(profileLocator)
+		if !ok {
+			fatal("screenshot: not supported by this transport")
+		}
+		if flag.NArg() > 0 {
+			fatal("screenshot: only one URL at a time")
+		}
+		if err := screenshot(ctx, pl.foundProfileDir(), cwd, shotURL, *fullpage, *shotout); err != nil {
+			fatal("screenshot", "err", err)
+		}
+		return
+	}
+
+	if *bridge != "" {
+		stop()
+		b, ok := t.(bridger)
+		if !ok {
+			fatal("-bridge is not supported by this transport")
+		}
+		if err := b.bridge(*bridge); err != nil {
+			fatal("bridge", "err", err)
+		}
+		return
+	}
+
+	if sub == "monitor" {
+		stop()
+		pm, ok := t.(protoMonitor)
+		if !ok {
+			fatal("monitor: not supported by this transport")
+		}
+		if err := pm.monitorProtocol(); err != nil {
+			fatal("monitor", "err", err)
+		}
+		return
+	}
+
+	if sub == "bench" {
+		bench(ctx, t, *benchn, cwd, args)
+		return
+	}
+
+	if sub == "stress" {
+		st, ok := t.(stresser)
+		if !ok {
+			fatal("stress: not supported by this transport")
+		}
+		if err := st.stress(ctx, *stressc, *benchn, cwd, args); err != nil {
+			fatal("stress", "err", err)
+		}
+		return
+	}
+
+	if sub == "replay" {
+		replay(ctx, t, o, *recfile, flag.Args())
+		return
+	}
+
+	if sub == "history" {
+		if !sendRecords(ctx, t, o, *recfile, histRecs) {
+			exit(2)
+		}
+		return
+	}
+
+	// Each window from the session is reopened as a new window,
+	// unless -new-tab says to put everything in the current one.
+	if sub == "session" {
+		where := "-new-window"
+		if *nt {
+			where = "-new-tab"
+		}
+		failed := false
+		for _, urls := range sessGroups {
+			sargs := append([]string{"firefox", where}, urls...)
+			if !sendOne(ctx, t, o, *recfile, cwd, sargs) {
+				failed = true
+			}
+		}
+		if failed {
+			exit(2)
+		}
+		return
+	}
+
+	// Firefox only goes into kiosk mode when it starts, so -kiosk
+	// is no use unless it did; see kiosk.go.
+	if *kiosk {
+		kc, ok := t.(kioskChecker)
+		if !ok {
+			slog.Warn("-kiosk: can't check whether Firefox is in kiosk mode with this transport")
+		} else if in, err := kc.kioskMode(); err != nil {
+			slog.Warn("-kiosk: can't tell whether Firefox is in kiosk mode", "err", err)
+		} else if !in {
+			fatal("-kiosk: the Firefox we found wasn't started in kiosk mode, and a running Firefox can't be switched into it; restart it with 'firefox --kiosk'")
+		}
+	}
+
+	if *spreadf {
+		sp, ok := t.(spreader)
+		if !ok {
+			fatal("-spread is not supported by this transport")
+		}
+		if len(args) == nopts {
+			fatal("-spread: no URLs to spread")
+		}
+		if !spread(ctx, sp.instances(), o, *recfile, cwd, args[:nopts], args[nopts:]) {
+			exit(2)
+		}
+		return
+	}
+
+	if !sendOne(ctx, t, o, *recfile, cwd, args) {
+		exit(2)
 	}
-	enc := encodeCommandLine(cwd, args)
+}
+
+// findRetrying finds Firefox through t, trying again up to retries
+// times, interval apart, if it's not there (yet). We stop early if
+// ctx is done.
+func findRetrying(ctx context.Context, t transport, retries int, interval time.Duration, verbose bool) error {
+	err := t.find(ctx)
+	for i := 0; err != nil && i < retries; i++ {
+		if verbose {
+			slog.Info("trying again", "err", err, "in", interval)
+		}
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return err
+		}
+		err = t.find(ctx)
+	}
+	return err
+}
+
+// A firefoxWaiter is a transport that can wait for Firefox to start
+// without polling.
+type firefoxWaiter interface {
+	waitForFirefox(ctx context.Context, timeout time.Duration) error
+}
+
+// waitForFirefox waits up to timeout for a matching Firefox to show
+// up through t, polling every second if t can't do better.
+func waitForFirefox(ctx context.Context, t transport, timeout time.Duration) error {
+	if w, ok := t.(firefoxWaiter); ok {
+		return w.waitForFirefox(ctx, timeout)
+	}
+	return findRetrying(ctx, t, int(timeout/time.Second), time.Second, false)
+}
+
+// subcommands are the subcommands we know about.
+var subcommands = map[string]bool{
+	"replay":  true,
+	"monitor": true,
+	"decode":  true,
+	"bench":   true,
+	"stress":  true,
+	"session": true,
+	"mozlz4":  true,
+	"history": true,
+	"audit":   true,
+
+	"completion": true,
+	"firefox":    true,
+	"screenshot": true,
+
+	"open":     true,
+	"search":   true,
+	"find":     true,
+	"list":     true,
+	"ping":     true,
+	"unlock":   true,
+	"quit":     true,
+	"restart":  true,
+	"prefs":    true,
+	"profiles": true,
+}
+
+// modeSubcommands are the subcommands that are just other names for
+// the plain form and some options.
+var modeSubcommands = map[string]bool{
+	"open": true, "search": true, "find": true, "list": true,
+	"ping": true, "prefs": true, "profiles": true,
+}
+
+// modeFlags are the options that pick what we do instead of opening
+// URLs, which can't be used with the mode subcommands.
+var modeFlags = map[string]bool{
+	"search": true, "find": true, "list": true, "dump": true,
+	"set-prop": true, "serve": true, "watch": true, "bridge": true,
+	"app-service": true, "ping": true, "hold-lock": true,
+	"search-selection": true, "socket-activated": true,
+}
+
+// A server is a transport that can pretend to be Firefox.
+type server interface {
+	serve(handler string) error
+}
+
+// A dumper is a transport that can show the raw state of the Firefox
+// it found.
+type dumper interface {
+	dump() error
+}
+
+// A propSetter is a transport that can set raw properties on the
+// Firefox it found.
+type propSetter interface {
+	setProp(name, value string) error
+}
+
+// A stresser is a transport that can stress test its locking.
+type stresser interface {
+	stress(ctx context.Context, senders, n int, cwd string, args []string) error
+}
+
+// A bridger is a transport that can pass on command lines sent to it
+// under another name.
+type bridger interface {
+	bridge(pfix string) error
+}
+
+// A watcher is a transport that can watch for Firefox instances to
+// come and go.
+type watcher interface {
+	watch(cmd, cwd string, args []string, deliver bool) error
+}
+
+// A targeter is a transport that can describe the Firefox it found
+// in a few words, for records.
+type targeter interface {
+	target() string
+}
+
+// An unlocker is a transport that can clear someone else's lock on
+// the Firefox it found.
+type unlocker interface {
+	unlock() error
+}
+
+// A profileLocator is a transport that can say where the profile
+// of the Firefox it found is, for talking to it through Marionette.
+type profileLocator interface {
+	foundProfileDir() string
+}
+
+// A kioskChecker is a transport that can tell whether the Firefox it
+// found is in kiosk mode.
+type kioskChecker interface {
+	kioskMode() (bool, error)
+}
+
+// A quitter is a transport that can make Firefox quit or restart.
+type quitter interface {
+	quit(ctx context.Context, restart bool) error
+}
+
+// A lockHolder is a transport that can take Firefox's lock and sit
+// on it.
+type lockHolder interface {
+	holdLock(ctx context.Context, d time.Duration) error
+}
 
-	resp := submitCommand(xu, foxwin, enc, *force)
-	if *verb {
+// A protoMonitor is a transport that can watch other people talking
+// to Firefox.
+type protoMonitor interface {
+	monitorProtocol() error
+}
+
+// sendOne sends a command line to Firefox, reporting the response if
+// we're verbose or it's a failure. It returns false if Firefox said
+// it failed or a pre-hook refused to let us send it.
+func sendOne(ctx context.Context, t transport, o *options, recfile, cwd string, args []string) bool {
+	resp, err := sendRecorded(ctx, t, o, recfile, cwd, args)
+	if _, ok := err.(refusedError); ok {
+		slog.Warn("", "err", err)
+		return false
+	}
+	if err != nil {
+		fatal("", "err", err)
+	}
+	if o.verbose {
 		fmt.Printf("response: %s\n", resp)
+		fmt.Printf("meaning: %s\n", resp.explain())
+	}
+	if resp.failed() {
+		if !o.verbose {
+			slog.Error("Firefox says: "+resp.raw, "meaning", resp.explain())
+		}
+		return false
+	}
+	return true
+}
+
+// A refusedError is what sendRecorded returns when a pre-hook
+// refused to let us send a command line.
+type refusedError struct {
+	error
+}
+
+// sendRecorded sends a command line to Firefox along with everything
+// that goes around that: the hooks, -confirm, -audit, and recording
+// it if we're supposed to.
+func sendRecorded(ctx context.Context, t transport, o *options, recfile, cwd string, args []string) (response, error) {
+	target := ""
+	if tg, ok := t.(targeter); ok {
+		target = tg.target()
+	}
+	if o.preHook != "" {
+		nargs, e := runPreHook(o.preHook, o, target, cwd, args)
+		if e != nil {
+			return response{}, refusedError{e}
+		}
+		args = nargs
+	}
+	if o.dryRun {
+		return response{}, dryRun(t, cwd, args)
+	}
+	if o.confirm {
+		if e := confirmSend(o, target, cwd, args); e != nil {
+			return response{}, e
+		}
+	}
+	if o.audit != nil {
+		if e := o.audit.write(auditRecord{Event: "send", Target: target, Cwd: cwd, Args: args}); e != nil {
+			fatal("audit: not sending", "err", e)
+		}
+	}
+	resp, err := t.send(ctx, cwd, args)
+	if o.audit != nil {
+		rec := auditRecord{Event: "result", Target: target, Args: args, Response: resp.raw}
+		if err != nil {
+			rec.Error = err.Error()
+		}
+		if e := o.audit.write(rec); e != nil {
+			fatal("audit", "err", e)
+		}
+	}
+	for _, fn := range []string{recfile, o.history} {
+		if fn == "" {
+			continue
+		}
+		if e := writeRecord(fn, o, target, cwd, args, resp.raw, err); e != nil {
+			slog.Warn("recording to "+fn, "err", e)
+		}
+	}
+	if o.postHook != "" {
+		if e := runPostHook(o.postHook, o, target, cwd, args, resp, err); e != nil {
+			slog.Warn("", "err", e)
+		}
+	}
+	return resp, err
+}
+
+// replay re-sends all of the command lines recorded in files.
+func replay(ctx context.Context, t transport, o *options, recfile string, files []string) {
+	if len(files) == 0 {
+		fatal("replay: no files given")
+	}
+	failed := false
+	for _, fn := range files {
+		recs, err := readRecords(fn)
+		if err != nil {
+			fatal("", "err", err)
+		}
+		if !sendRecords(ctx, t, o, recfile, recs) {
+			failed = true
+		}
+	}
+	if failed {
+		exit(2)
+	}
+}
+
+// sendRecords re-sends the command lines in recs, returning false if
+// Firefox said any of them failed.
+func sendRecords(ctx context.Context, t transport, o *options, recfile string, recs []record) bool {
+	ok := true
+	for _, rec := range recs {
+		if !sendOne(ctx, t, o, recfile, rec.Cwd, rec.Args) {
+			ok = false
+		}
 	}
+	return ok
 }
@@ -0,0 +1,198 @@
+package main
+
+// Talking to Firefox through Marionette, for the quit, restart, and
+// screenshot subcommands.
+//
+// Marionette is Firefox's remote automation protocol (geckodriver
+// uses it). It's only there if Firefox was started with --marionette
+// (or has marionette.enabled set), and then Firefox listens on a TCP
+// port on localhost and writes the port number to the file
+// 'MarionetteActivePort' in its profile directory, which is how we
+// find it. The protocol is JSON messages, each sent as its length in
+// decimal, a ':', and the JSON. When we connect Firefox sends a hello
+// message; after that we send commands as '[0, ID, NAME, PARAMS]'
+// and get back '[1, ID, ERROR, RESULT]'. Marionette:Quit doesn't
+// need a session, which is good because someone else may have one;
+// everything else does, and there can only be one at a time.
+//
+// The quit flags are those of nsIAppStartup. eAttemptQuit is a
+// normal quit, which asks everything first (so for example Firefox
+// may ask if you really want to close all those tabs) and can be
+// refused; adding eRestart makes it a restart. Firefox answers once
+// it's started shutting down.
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errNoMarionette is what dialMarionette returns if the profile
+// doesn't have Marionette running.
+var errNoMarionette = errors.New("Marionette isn't running (start Firefox with --marionette)")
+
+// marionetteTimeout is how long we wait for Firefox to answer over
+// Marionette if there's no -timeout.
+const marionetteTimeout = time.Minute
+
+// profileDir returns the directory of the profile in a profile
+// property value, which is the directory itself in modern Firefox
+// but just the profile's name in older ones.
+func profileDir(prof string) string {
+	if prof == "" || filepath.IsAbs(prof) {
+		return prof
+	}
+	return profilePath(prof)
+}
+
+// marionettePort returns the port that Marionette is listening on
+// for the profile in dir, or errNoMarionette.
+func marionettePort(dir string) (int, error) {
+	if dir == "" {
+		return 0, errNoMarionette
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "MarionetteActivePort"))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, errNoMarionette
+	}
+	if err != nil {
+		return 0, err
+	}
+	port, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil || port <= 0 || port > 65535 {
+		return 0, fmt.Errorf("%s: bad port %q", filepath.Join(dir, "MarionetteActivePort"), strings.TrimSpace(string(b)))
+	}
+	return port, nil
+}
+
+// A marionette is a connection to Marionette.
+type marionette struct {
+	conn net.Conn
+	r    *bufio.Reader
+	port int
+	id   int
+}
+
+// dialMarionette connects to the Marionette of the Firefox running
+// the profile in dir. Everything we do on the connection has to be
+// done by ctx's deadline, or in marionetteTimeout.
+func dialMarionette(ctx context.Context, dir string) (*marionette, error) {
+	port, err := marionettePort(dir)
+	if err != nil {
+		return nil, err
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		return nil, fmt.Errorf("Marionette: %w", err)
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(marionetteTimeout)
+	}
+	conn.SetDeadline(deadline)
+
+	m := &marionette{conn: conn, r: bufio.NewReader(conn), port: port}
+	var hello struct {
+		ApplicationType    string `json:"applicationType"`
+		MarionetteProtocol int    `json:"marionetteProtocol"`
+	}
+	if err := m.read(&hello); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("Marionette hello: %w", err)
+	}
+	if hello.ApplicationType != "gecko" {
+		conn.Close()
+		return nil, fmt.Errorf("Marionette: port %d isn't Firefox (%q)", port, hello.ApplicationType)
+	}
+	return m, nil
+}
+
+// Close closes the connection, which ends any session we started.
+func (m *marionette) Close() error {
+	return m.conn.Close()
+}
+
+// call sends the command name with params and waits for the answer,
+// which goes in result if that isn't nil.
+func (m *marionette) call(name string, params map[string]interface{}, result interface{}) error {
+	if params == nil {
+		params = map[string]interface{}{}
+	}
+	m.id++
+	msg, err := json.Marshal([]interface{}{0, m.id, name, params})
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	if _, err := fmt.Fprintf(m.conn, "%d:%s", len(msg), msg); err != nil {
+		return fmt.Errorf("Marionette: %w", err)
+	}
+	var reply []json.RawMessage
+	if err := m.read(&reply); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	protoLog("Marionette", "port", m.port, "command", name, "time", time.Since(start))
+	if len(reply) != 4 {
+		return fmt.Errorf("%s: odd reply %.100q", name, reply)
+	}
+	var merr *struct {
+		Error   string `json:"error"`
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(reply[2], &merr); err != nil {
+		return fmt.Errorf("%s: odd error %.100q", name, reply[2])
+	}
+	if merr != nil {
+		return fmt.Errorf("%s: %s: %s", name, merr.Error, merr.Message)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(reply[3], result)
+}
+
+// marionetteQuit asks the Firefox running the profile in dir to quit,
+// or to restart.
+func marionetteQuit(ctx context.Context, dir string, restart bool) error {
+	m, err := dialMarionette(ctx, dir)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	flags := []string{"eAttemptQuit"}
+	if restart {
+		flags = append(flags, "eRestart")
+	}
+	return m.call("Marionette:Quit", map[string]interface{}{"flags": flags}, nil)
+}
+
+// maxMarionetteMsg is the largest Marionette message we'll read.
+// Screenshots of long pages are big.
+const maxMarionetteMsg = 256 << 20
+
+// read reads one Marionette message into v.
+func (m *marionette) read(v interface{}) error {
+	ls, err := m.r.ReadString(':')
+	if err != nil {
+		return err
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(ls, ":"))
+	if err != nil || n < 0 || n > maxMarionetteMsg {
+		return fmt.Errorf("bad message length %q", ls)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(m.r, b); err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
@@ -0,0 +1,102 @@
+package main
+
+// Opening the links in Markdown, for -markdown.
+//
+// We don't parse Markdown properly; we find the link forms that
+// people actually use in notes files, which are inline links and
+// images ('[text](URL "title")'), reference definitions
+// ('[ref]: URL'), and autolinks ('<https://...>'). We skip fenced
+// code blocks, where things that look like links usually aren't.
+//
+// If you give a heading, we only look at the section under the first
+// heading with that text (ignoring case), up to the next heading at
+// the same or a higher level.
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+)
+
+var (
+	mdHeading = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+	mdFence   = regexp.MustCompile("^\\s*(```|~~~)")
+	mdLink    = regexp.MustCompile(`!?\[[^\]]*\]\(\s*<?([^\s)>]+)>?(?:\s+["'(][^)]*)?\s*\)|<([a-zA-Z][a-zA-Z0-9+.-]*:[^\s>]+)>`)
+	mdRefDef  = regexp.MustCompile(`^\s{0,3}\[[^\]]+\]:\s*<?(\S+?)>?(?:\s+.*)?$`)
+)
+
+// markdownURLs returns the link destinations in text, in order and
+// without duplicates, restricted to the section under heading if it
+// isn't "".
+func markdownURLs(text, heading string) []string {
+	var urls []string
+	seen := make(map[string]bool)
+	add := func(u string) {
+		// Skip links to anchors in the same document.
+		if u != "" && u[0] != '#' && !seen[u] {
+			seen[u] = true
+			urls = append(urls, u)
+		}
+	}
+
+	inFence := false
+	inSection := heading == ""
+	level := 0
+	for _, line := range strings.Split(text, "\n") {
+		if mdFence.MatchString(line) {
+			inFence = !inFence
+			continue
+		}
+		if inFence {
+			continue
+		}
+		if m := mdHeading.FindStringSubmatch(line); m != nil && heading != "" {
+			switch {
+			case !inSection && level == 0 && strings.EqualFold(m[2], heading):
+				inSection, level = true, len(m[1])
+			case inSection && len(m[1]) <= level:
+				// The end of our section; there's nothing
+				// more for us.
+				return urls
+			}
+			continue
+		}
+		if !inSection {
+			continue
+		}
+		if m := mdRefDef.FindStringSubmatch(line); m != nil {
+			add(m[1])
+			continue
+		}
+		for _, m := range mdLink.FindAllStringSubmatch(line, -1) {
+			add(m[1] + m[2])
+		}
+	}
+	return urls
+}
+
+// markdownFileURLs reads Markdown from fname (or standard input if
+// fname is "-") and returns the link destinations in it, or only
+// under heading.
+func markdownFileURLs(fname, heading string) ([]string, error) {
+	var b []byte
+	var err error
+	if fname == "-" {
+		b, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		b, err = ioutil.ReadFile(fname)
+	}
+	if err != nil {
+		return nil, err
+	}
+	urls := markdownURLs(string(b), heading)
+	if len(urls) == 0 {
+		if heading != "" {
+			return nil, fmt.Errorf("no links under heading %q", heading)
+		}
+		return nil, fmt.Errorf("no links found")
+	}
+	return urls, nil
+}
@@ -0,0 +1,111 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+// Picking a Firefox window by what monitor it's on.
+//
+// In a multi-head setup you may have Firefox windows on several
+// monitors, and you'd like URLs to show up on the one you're looking
+// at. We find the monitor's geometry through RandR and then prefer a
+// Firefox window whose center is on it. If RandR isn't available or
+// nothing is on the monitor, we fall back to the normal choice.
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/BurntSushi/xgb/randr"
+	"github.com/BurntSushi/xgb/xproto"
+	"github.com/BurntSushi/xgbutil"
+)
+
+// A monitor is a RandR output that's currently showing something.
+type monitor struct {
+	name       string
+	x, y, w, h int
+}
+
+func (m monitor) contains(x, y int) bool {
+	return x >= m.x && x < m.x+m.w && y >= m.y && y < m.y+m.h
+}
+
+// monitors returns all active RandR outputs.
+func monitors(xu *xgbutil.XUtil) ([]monitor, error) {
+	c := xu.Conn()
+	if err := randr.Init(c); err != nil {
+		return nil, err
+	}
+	res, err := randr.GetScreenResourcesCurrent(c, xu.RootWin()).Reply()
+	if err != nil {
+		return nil, err
+	}
+	var mons []monitor
+	for _, o := range res.Outputs {
+		oi, err := randr.GetOutputInfo(c, o, res.ConfigTimestamp).Reply()
+		if err != nil || oi.Crtc == 0 {
+			continue
+		}
+		ci, err := randr.GetCrtcInfo(c, oi.Crtc, res.ConfigTimestamp).Reply()
+		if err != nil {
+			continue
+		}
+		mons = append(mons, monitor{string(oi.Name), int(ci.X), int(ci.Y), int(ci.Width), int(ci.Height)})
+	}
+	return mons, nil
+}
+
+// findMonitor finds the monitor the pointer is on (for "pointer") or
+// the one with the given output name.
+func findMonitor(xu *xgbutil.XUtil, name string) (monitor, error) {
+	mons, err := monitors(xu)
+	if err != nil {
+		return monitor{}, err
+	}
+	var px, py int
+	if name == "pointer" {
+		p, err := xproto.QueryPointer(xu.Conn(), xu.RootWin()).Reply()
+		if err != nil {
+			return monitor{}, err
+		}
+		px, py = int(p.RootX), int(p.RootY)
+	}
+	for _, m := range mons {
+		if (name == "pointer" && m.contains(px, py)) || m.name == name {
+			return m, nil
+		}
+	}
+	return monitor{}, fmt.Errorf("no monitor %s", name)
+}
+
+// windowCenter returns the center of win in root window coordinates.
+func windowCenter(xu *xgbutil.XUtil, win xproto.Window) (int, int, error) {
+	g, err := xproto.GetGeometry(xu.Conn(), xproto.Drawable(win)).Reply()
+	if err != nil {
+		return 0, 0, err
+	}
+	tc, err := xproto.TranslateCoordinates(xu.Conn(), win, xu.RootWin(), 0, 0).Reply()
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(tc.DstX) + int(g.Width)/2, int(tc.DstY) + int(g.Height)/2, nil
+}
+
+// pickByMonitor picks the first candidate that's on the named
+// monitor, or the first candidate if none of them are.
+func pickByMonitor(xu *xgbutil.XUtil, cands []foxCandidate, name string, verbose bool) foxCandidate {
+	m, err := findMonitor(xu, name)
+	if err != nil {
+		if verbose {
+			slog.Info("monitor "+name, "err", err)
+		}
+		return cands[0]
+	}
+	for _, c := range cands {
+		x, y, err := windowCenter(xu, c.win)
+		if err == nil && m.contains(x, y) {
+			return c
+		}
+	}
+	return cands[0]
+}
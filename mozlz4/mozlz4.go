@@ -0,0 +1,215 @@
+// Package mozlz4 reads and writes the "mozlz4" files that Firefox uses
+// for compressed JSON, such as sessionstore.jsonlz4 and
+// search.json.mozlz4 in a profile directory.
+//
+// A mozlz4 file is the magic "mozLz40\0", the uncompressed size as a
+// little-endian uint32, and then the data compressed as a single raw
+// LZ4 block (not the LZ4 frame format that the lz4 command uses, which
+// is why lz4 can't read these files). Everything has to fit in memory,
+// but since Firefox reads and writes these files in one go too, that's
+// not a problem in practice.
+package mozlz4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Magic starts every mozlz4 file.
+const Magic = "mozLz40\x00"
+
+// headerLen is the length of the magic and the size.
+const headerLen = len(Magic) + 4
+
+var (
+	// ErrNotMozLz4 is returned by Decode for data that doesn't
+	// start with Magic.
+	ErrNotMozLz4 = errors.New("mozlz4: not a mozlz4 file")
+	// ErrCorrupt is returned by Decode for compressed data that
+	// doesn't decompress properly.
+	ErrCorrupt = errors.New("mozlz4: corrupt LZ4 data")
+)
+
+// IsMozLz4 reports whether b starts like a mozlz4 file.
+func IsMozLz4(b []byte) bool {
+	return len(b) >= headerLen && bytes.HasPrefix(b, []byte(Magic))
+}
+
+// Decode decompresses the contents of a mozlz4 file.
+func Decode(b []byte) ([]byte, error) {
+	if !IsMozLz4(b) {
+		return nil, ErrNotMozLz4
+	}
+	size := int(binary.LittleEndian.Uint32(b[len(Magic):]))
+	// Nothing decompresses to more than maxRatio times its size,
+	// so a bigger size is corrupt, and we don't want to allocate
+	// it.
+	if size > maxRatio*(len(b)-headerLen) {
+		return nil, ErrCorrupt
+	}
+	return decodeBlock(b[headerLen:], size)
+}
+
+// maxRatio is more than the most that LZ4 can expand data by, which
+// is about 255 to 1 (a match can only add 255 bytes for each length
+// byte).
+const maxRatio = 256
+
+// Encode compresses b into the contents of a mozlz4 file.
+func Encode(b []byte) []byte {
+	dst := make([]byte, headerLen, headerLen+len(b)/2+16)
+	copy(dst, Magic)
+	binary.LittleEndian.PutUint32(dst[len(Magic):], uint32(len(b)))
+	return encodeBlock(dst, b)
+}
+
+// decodeBlock decompresses a raw LZ4 block into size bytes.
+func decodeBlock(src []byte, size int) ([]byte, error) {
+	dst := make([]byte, 0, size)
+	// length reads the extra bytes of an LZ4 length.
+	length := func(n int, i *int) (int, error) {
+		for {
+			if *i >= len(src) {
+				return 0, ErrCorrupt
+			}
+			b := src[*i]
+			*i++
+			n += int(b)
+			if b != 255 {
+				return n, nil
+			}
+		}
+	}
+	for i := 0; i < len(src); {
+		token := src[i]
+		i++
+		// Literals.
+		n := int(token >> 4)
+		if n == 15 {
+			var err error
+			if n, err = length(n, &i); err != nil {
+				return nil, err
+			}
+		}
+		if i+n > len(src) || len(dst)+n > size {
+			return nil, ErrCorrupt
+		}
+		dst = append(dst, src[i:i+n]...)
+		i += n
+		// The last sequence has only literals.
+		if i == len(src) {
+			break
+		}
+		// The match.
+		if i+2 > len(src) {
+			return nil, ErrCorrupt
+		}
+		off := int(src[i]) | int(src[i+1])<<8
+		i += 2
+		if off == 0 || off > len(dst) {
+			return nil, ErrCorrupt
+		}
+		n = int(token & 15)
+		if n == 15 {
+			var err error
+			if n, err = length(n, &i); err != nil {
+				return nil, err
+			}
+		}
+		n += minMatch
+		if len(dst)+n > size {
+			return nil, ErrCorrupt
+		}
+		// Matches can overlap what they're producing, so we
+		// have to copy a byte at a time.
+		start := len(dst) - off
+		for j := 0; j < n; j++ {
+			dst = append(dst, dst[start+j])
+		}
+	}
+	if len(dst) != size {
+		return nil, fmt.Errorf("mozlz4: data is %d bytes instead of %d", len(dst), size)
+	}
+	return dst, nil
+}
+
+// LZ4 block format limits. Matches are at least minMatch bytes, the
+// last match has to start at least matchLimit bytes before the end,
+// and the last lastLiterals bytes are always literals.
+const (
+	minMatch     = 4
+	matchLimit   = 12
+	lastLiterals = 5
+	maxOffset    = 65535
+)
+
+// encodeBlock appends src compressed as a raw LZ4 block to dst. This
+// is a simple greedy compressor with a single hash table, which is
+// nowhere near as good as the real LZ4 library but makes files that
+// Firefox (and anyone else) can read.
+func encodeBlock(dst, src []byte) []byte {
+	n := len(src)
+	anchor := 0
+	if n > matchLimit {
+		// table holds the last position (plus one) that had
+		// each hash of four bytes.
+		var table [1 << 16]int
+		for i := 0; i <= n-matchLimit; {
+			v := binary.LittleEndian.Uint32(src[i:])
+			h := (v * 2654435761) >> 16
+			ref := table[h] - 1
+			table[h] = i + 1
+			if ref < 0 || i-ref > maxOffset || binary.LittleEndian.Uint32(src[ref:]) != v {
+				i++
+				continue
+			}
+			ml := minMatch
+			for i+ml < n-lastLiterals && src[ref+ml] == src[i+ml] {
+				ml++
+			}
+			dst = appendSequence(dst, src[anchor:i], i-ref, ml)
+			i += ml
+			anchor = i
+		}
+	}
+	return appendSequence(dst, src[anchor:], 0, 0)
+}
+
+// appendSequence appends an LZ4 sequence of lits followed by a match
+// of ml bytes at off back, or just lits if ml is 0.
+func appendSequence(dst, lits []byte, off, ml int) []byte {
+	ll := len(lits)
+	token := byte(min15(ll) << 4)
+	if ml > 0 {
+		token |= byte(min15(ml - minMatch))
+	}
+	dst = append(dst, token)
+	dst = appendLength(dst, ll)
+	dst = append(dst, lits...)
+	if ml > 0 {
+		dst = append(dst, byte(off), byte(off>>8))
+		dst = appendLength(dst, ml-minMatch)
+	}
+	return dst
+}
+
+// appendLength appends the extra bytes for an LZ4 length of n, if
+// it needs any.
+func appendLength(dst []byte, n int) []byte {
+	if n < 15 {
+		return dst
+	}
+	for n -= 15; n >= 255; n -= 255 {
+		dst = append(dst, 255)
+	}
+	return append(dst, byte(n))
+}
+
+func min15(n int) int {
+	if n > 15 {
+		return 15
+	}
+	return n
+}
@@ -0,0 +1,94 @@
+package mozlz4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// header makes a mozlz4 header for size bytes.
+func header(size uint32) []byte {
+	return binary.LittleEndian.AppendUint32([]byte(Magic), size)
+}
+
+func TestRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	noise := make([]byte, 200000)
+	r.Read(noise)
+	// Noise repeated at more than maxOffset back, which the
+	// encoder can't refer to.
+	far := append(append([]byte(nil), noise[:maxOffset+1000]...), noise[:maxOffset+1000]...)
+	for _, c := range []struct {
+		name string
+		b    []byte
+	}{
+		{"empty", nil},
+		{"one byte", []byte("x")},
+		{"short", []byte("hello, world")},
+		{"just past matchLimit", []byte("abcdabcdabcdabcd")},
+		{"repetitive", []byte(strings.Repeat("abc", 30000))},
+		{"zeros", make([]byte, 100000)},
+		{"json", []byte(strings.Repeat(`{"url":"https://example.org/","title":"Example"},`, 2000))},
+		{"noise", noise},
+		{"past maxOffset", far},
+	} {
+		enc := Encode(c.b)
+		if !IsMozLz4(enc) {
+			t.Errorf("%s: Encode's output doesn't look like mozlz4", c.name)
+			continue
+		}
+		dec, err := Decode(enc)
+		if err != nil {
+			t.Errorf("%s: %s", c.name, err)
+			continue
+		}
+		if !bytes.Equal(dec, c.b) {
+			t.Errorf("%s: Decode(Encode(b)) isn't b", c.name)
+		}
+	}
+}
+
+// Repetitive data should actually get smaller.
+func TestCompresses(t *testing.T) {
+	b := []byte(strings.Repeat("abc", 30000))
+	if enc := Encode(b); len(enc) > len(b)/50 {
+		t.Errorf("%d bytes encoded to %d", len(b), len(enc))
+	}
+}
+
+func TestCorrupt(t *testing.T) {
+	good := Encode([]byte("hello, hello, hello, world"))
+	body := good[headerLen:]
+	for _, c := range []struct {
+		name string
+		b    []byte
+		want error // if nil, any error will do
+	}{
+		{"not mozlz4", []byte("{\"json\": true}"), ErrNotMozLz4},
+		{"truncated header", good[:headerLen-1], ErrNotMozLz4},
+		// One literal and then a match from before the start.
+		{"offset past the start", append(header(20), 0x10, 'a', 5, 0, 0x50, 'a', 'a', 'a', 'a', 'a'), ErrCorrupt},
+		{"zero offset", append(header(20), 0x10, 'a', 0, 0, 0x50, 'a', 'a', 'a', 'a', 'a'), ErrCorrupt},
+		{"truncated offset", append(header(20), 0x10, 'a', 1), ErrCorrupt},
+		{"truncated literal length", append(header(20), 0xf0), ErrCorrupt},
+		{"truncated literal length run", append(header(600), 0xf0, 255, 255), ErrCorrupt},
+		{"truncated match length", append(header(40), 0x1f, 'a', 1, 0), ErrCorrupt},
+		{"truncated literals", append(header(20), 0x50, 'a', 'b'), ErrCorrupt},
+		{"truncated body", good[:len(good)-3], nil},
+		{"size too small", append(header(5), body...), ErrCorrupt},
+		{"size too big", append(header(uint32(len(body))*4), body...), nil},
+		{"huge size", append(header(0xffffffff), body...), ErrCorrupt},
+		{"size with no data", header(1), ErrCorrupt},
+	} {
+		_, err := Decode(c.b)
+		switch {
+		case err == nil:
+			t.Errorf("%s: Decode accepted it", c.name)
+		case c.want != nil && !errors.Is(err, c.want):
+			t.Errorf("%s: got %v, want %v", c.name, err, c.want)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+// 'ffox-remote mozlz4', for looking at (and making) the mozlz4 files
+// that Firefox keeps compressed JSON in. See the mozlz4 package.
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/siebenmann/ffox-remote/mozlz4"
+)
+
+// mozlz4Files decompresses the mozlz4 files (or standard input if
+// there are none) to standard output, or with encode compresses them
+// instead.
+func mozlz4Files(encode bool, files []string) error {
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	for _, fn := range files {
+		var b []byte
+		var err error
+		if fn == "-" {
+			b, err = ioutil.ReadAll(os.Stdin)
+		} else {
+			b, err = ioutil.ReadFile(fn)
+		}
+		if err != nil {
+			return err
+		}
+		if encode {
+			b = mozlz4.Encode(b)
+		} else if b, err = mozlz4.Decode(b); err != nil {
+			return fmt.Errorf("%s: %s", fn, err)
+		}
+		if _, err = os.Stdout.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
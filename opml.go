@@ -0,0 +1,86 @@
+package main
+
+// Opening the feeds in an OPML subscription list, for -opml.
+//
+// Feed readers export their subscriptions as OPML, which is XML with
+// a tree of <outline> elements. Feeds have an xmlUrl (the feed
+// itself) and usually an htmlUrl (the site); folders are outlines
+// that just have other outlines in them. For each feed we open the
+// site if we know it and the feed otherwise (or always the feed, with
+// -opml-feeds). With a title, we only open feeds with that title or
+// in a folder with that title.
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// An opmlOutline is one <outline> element. OPML has both text and
+// title attributes, which are normally the same; some programs only
+// set one of them.
+type opmlOutline struct {
+	Text     string        `xml:"text,attr"`
+	Title    string        `xml:"title,attr"`
+	XMLURL   string        `xml:"xmlUrl,attr"`
+	HTMLURL  string        `xml:"htmlUrl,attr"`
+	Outlines []opmlOutline `xml:"outline"`
+}
+
+// matches returns true if the outline's title is title.
+func (o opmlOutline) matches(title string) bool {
+	return strings.EqualFold(o.Title, title) || strings.EqualFold(o.Text, title)
+}
+
+type opmlDoc struct {
+	Outlines []opmlOutline `xml:"body>outline"`
+}
+
+// opmlURLs returns the URLs to open for all of the feeds in outlines,
+// restricted to ones under or with title if it isn't "". in is true
+// if we're already under an outline with that title.
+func opmlURLs(outlines []opmlOutline, title string, feeds, in bool) []string {
+	var urls []string
+	for _, o := range outlines {
+		oin := in || title == "" || o.matches(title)
+		switch {
+		case !oin:
+		case o.HTMLURL != "" && !feeds:
+			urls = append(urls, o.HTMLURL)
+		case o.XMLURL != "":
+			urls = append(urls, o.XMLURL)
+		}
+		urls = append(urls, opmlURLs(o.Outlines, title, feeds, oin)...)
+	}
+	return urls
+}
+
+// opmlFileURLs reads the OPML file named by spec, which is FILE or
+// FILE:TITLE, and returns the URLs to open for its feeds. If feeds
+// is set, these are always the feed URLs.
+func opmlFileURLs(spec string, feeds bool) ([]string, error) {
+	fname, title := spec, ""
+	if i := strings.LastIndexByte(spec, ':'); i > 0 {
+		if _, e := os.Stat(spec); e != nil {
+			fname, title = spec[:i], spec[i+1:]
+		}
+	}
+	b, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	var doc opmlDoc
+	if err := xml.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %s", fname, err)
+	}
+	urls := opmlURLs(doc.Outlines, title, feeds, false)
+	if len(urls) == 0 {
+		if title != "" {
+			return nil, fmt.Errorf("%s: no feeds under %q", fname, title)
+		}
+		return nil, fmt.Errorf("%s: no feeds found", fname)
+	}
+	return urls, nil
+}
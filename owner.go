@@ -0,0 +1,130 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+// Making sure that the Firefox we found is ours.
+//
+// On an X server that several people share, everyone's Firefox
+// windows are visible to everyone, and nothing in the remote control
+// protocol stops you from sending your command lines to someone
+// else's Firefox. -U helps, but only if you remember it. So before we
+// change any properties on a window, we check that it belongs to a
+// process that's running as us.
+//
+// The best way to find the process is the X-Resource extension,
+// which asks the X server for the PID of the client that created the
+// window; the server gets this from the kernel, so it's right even
+// for sandboxed Firefoxes (where _NET_WM_PID is a PID in the
+// sandbox's namespace). But it's a PID on the X server's machine, so
+// we only use it if we're talking to the X server over a local
+// socket; over SSH X forwarding, for instance, it's the PID of
+// something on the other end. Otherwise we use _NET_WM_PID, but only
+// if WM_CLIENT_MACHINE says the window is from this machine. Then
+// /proc tells us who the process is running as.
+//
+// If we can't find the process at all (for example, Firefox is
+// running on another machine), the best we can do is to check that
+// _MOZILLA_USER, which Firefox sets from $LOGNAME or $USER, is our
+// login name.
+//
+// -any-owner turns all of this off.
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strings"
+	"syscall"
+
+	"github.com/BurntSushi/xgb/res"
+	"github.com/BurntSushi/xgb/xproto"
+	"github.com/BurntSushi/xgbutil"
+	"github.com/BurntSushi/xgbutil/ewmh"
+	"github.com/BurntSushi/xgbutil/icccm"
+)
+
+// localDisplay reports whether display (or $DISPLAY, if display is
+// "") is an X server on this machine that we reach through a Unix
+// socket. A forwarded display looks like 'localhost:10.0'.
+func localDisplay(display string) bool {
+	if display == "" {
+		display = os.Getenv("DISPLAY")
+	}
+	i := strings.LastIndexByte(display, ':')
+	if i < 0 {
+		return false
+	}
+	host := display[:i]
+	return host == "" || host == "unix" || strings.HasPrefix(host, "/")
+}
+
+// windowPID returns the PID of the local process that owns win, or 0
+// if we can't tell. local says whether the X server is on this
+// machine (see localDisplay).
+func windowPID(xu *xgbutil.XUtil, win xproto.Window, local bool) int {
+	if local && res.Init(xu.Conn()) == nil {
+		spec := res.ClientIdSpec{Client: uint32(win), Mask: res.ClientIdMaskLocalClientPID}
+		r, err := res.QueryClientIds(xu.Conn(), 1, []res.ClientIdSpec{spec}).Reply()
+		if err == nil {
+			for _, id := range r.Ids {
+				if id.Spec.Mask&res.ClientIdMaskLocalClientPID != 0 && len(id.Value) > 0 {
+					return int(id.Value[0])
+				}
+			}
+		}
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		return 0
+	}
+	if cm, err := icccm.WmClientMachineGet(xu, win); err != nil || cm != host {
+		return 0
+	}
+	pid, err := ewmh.WmPidGet(xu, win)
+	if err != nil {
+		return 0
+	}
+	return int(pid)
+}
+
+// checkOwner returns an error unless win belongs to a process running
+// as us (or, failing that, says it's ours).
+func checkOwner(xu *xgbutil.XUtil, win xproto.Window, local bool) error {
+	if pid := windowPID(xu, win, local); pid > 0 {
+		fi, err := os.Stat(fmt.Sprintf("/proc/%d", pid))
+		if err == nil {
+			st, ok := fi.Sys().(*syscall.Stat_t)
+			if ok && int(st.Uid) == os.Getuid() {
+				return nil
+			}
+			if ok {
+				who := fmt.Sprintf("UID %d", st.Uid)
+				if u, err := user.LookupId(fmt.Sprint(st.Uid)); err == nil {
+					who = u.Username
+				}
+				return fmt.Errorf("Firefox window 0x%x belongs to process %d, which is running as %s, not us (use -any-owner if you really mean it)", win, pid, who)
+			}
+		}
+	}
+	me := loginName()
+	fu := propValue(liveX{xu}, win, userProp)
+	if me != "" && fu == me {
+		return nil
+	}
+	return fmt.Errorf("can't tell that Firefox window 0x%x is ours: its process isn't visible here and its user is %q, not %q (use -any-owner if you really mean it)", win, fu, me)
+}
+
+// checkOwned does checkOwner for the window t found, once, unless
+// we've been told not to.
+func (t *xTransport) checkOwned() error {
+	if t.o.anyOwner || t.owned == t.win {
+		return nil
+	}
+	if err := checkOwner(t.xu, t.win, localDisplay(t.displayName())); err != nil {
+		return err
+	}
+	protoLog("the Firefox window is ours", "window", hexWin(t.win))
+	t.owned = t.win
+	return nil
+}
@@ -0,0 +1,115 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+// Snap and Flatpak Firefox.
+//
+// Sandboxed Firefoxes speak the same X remote control protocol as
+// native ones, but their profiles live in different places and
+// their processes look different, which is useful to know when
+// you're trying to figure out which of several Firefoxes is which.
+// Since Firefox 131 or so the profile property is the full path
+// to the profile, which makes this easy; profileMatch already
+// copes with the unusual paths by matching on the end of them.
+// For older Firefoxes we have to look at the process itself, which
+// we can only do if it's running on this machine.
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/BurntSushi/xgb/xproto"
+	"github.com/BurntSushi/xgbutil"
+	"github.com/BurntSushi/xgbutil/ewmh"
+	"github.com/BurntSushi/xgbutil/icccm"
+)
+
+// packaging returns how the Firefox behind win was packaged, "snap",
+// "flatpak", or "native", or "" if we can't tell.
+func packaging(xu *xgbutil.XUtil, win xproto.Window) string {
+	if pk := profilePackaging(propValue(liveX{xu}, win, profProp)); pk != "" {
+		return pk
+	}
+
+	// The PID is only meaningful if Firefox is on this machine.
+	host, e := os.Hostname()
+	if e != nil {
+		return ""
+	}
+	cm, e := icccm.WmClientMachineGet(xu, win)
+	if e != nil || cm != host {
+		return ""
+	}
+	pid, e := ewmh.WmPidGet(xu, win)
+	if e != nil {
+		return ""
+	}
+	return pidPackaging(pid)
+}
+
+// profilePackaging is packaging for a Firefox with the profile prof,
+// which only tells us anything if it's a full path.
+func profilePackaging(prof string) string {
+	switch {
+	case strings.Contains(prof, "/snap/"):
+		return "snap"
+	case strings.Contains(prof, "/.var/app/"):
+		return "flatpak"
+	case strings.HasPrefix(prof, "/"):
+		return "native"
+	}
+	return ""
+}
+
+// pidPackaging is packaging for a process on this machine, which we
+// can tell from the cgroup that Snap or Flatpak put it in.
+func pidPackaging(pid uint) string {
+	cg, e := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if e != nil {
+		return ""
+	}
+	switch {
+	case strings.Contains(string(cg), "/snap."):
+		return "snap"
+	case strings.Contains(string(cg), "/app-flatpak-"):
+		return "flatpak"
+	}
+	return "native"
+}
+
+// listFirefox prints a line about every Firefox window we can see,
+// starting each one with prefix. If format isn't "", we print lines
+// in that format instead (see listformat.go), with fields holding
+// the fields that aren't about the window itself.
+func listFirefox(xu *xgbutil.XUtil, prefix, format string, fields map[string]string) {
+	xc := liveX{xu}
+	for _, win := range candidateWindows(xc) {
+		ver := propValue(xc, win, versProp)
+		if ver == "" {
+			continue
+		}
+		pk := packaging(xu, win)
+		if pk == "" {
+			pk = "unknown"
+		}
+		if format != "" {
+			fields["id"] = fmt.Sprintf("%x", win)
+			fields["version"] = ver
+			fields["user"] = propValue(xc, win, userProp)
+			fields["profile"] = propValue(xc, win, profProp)
+			fields["program"] = propValue(xc, win, progProp)
+			fields["packaging"] = pk
+			fields["title"] = windowTitle(xc, win)
+			fmt.Println(formatListLine(format, fields))
+			continue
+		}
+		fmt.Printf("%s0x%x %s user=%s program=%s profile=%s packaging=%s\n",
+			prefix, win, ver,
+			propValue(xc, win, userProp),
+			propValue(xc, win, progProp),
+			propValue(xc, win, profProp), pk)
+	}
+}
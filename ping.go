@@ -0,0 +1,65 @@
+package main
+
+// Checking that Firefox answers, for -ping.
+//
+// A ping goes through the whole remote control cycle (finding
+// Firefox, getting the lock, setting the command line, and waiting
+// for the response) with a command line that doesn't do anything
+// visible. That command line is '-silent', which Firefox handles by
+// not opening any windows; a command line with no arguments at all
+// would open a new window, which is what we're trying to avoid.
+//
+// Since it doesn't open anything, a ping isn't recorded, audited, or
+// passed through hooks.
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// pingArgs is the command line we send for a ping.
+var pingArgs = []string{"firefox", "-silent"}
+
+// ping sends Firefox pingArgs and reports how long the round trip
+// took (and its phases, if the transport tells us). It returns an
+// error if the send fails or Firefox doesn't say it succeeded.
+func ping(ctx context.Context, t transport, cwd string) error {
+	reporting := timings != nil
+	if !reporting {
+		startTimings()
+		defer func() { timings = nil }()
+	}
+	start := time.Now()
+	resp, err := t.send(ctx, cwd, pingArgs)
+	total := time.Since(start).Round(time.Microsecond)
+	if err != nil {
+		return err
+	}
+	// Transports without responses can only tell us that the
+	// command line was delivered, which will have to do.
+	if resp.raw != "" && !resp.succeeded() {
+		return fmt.Errorf("Firefox answered %q (%s) after %v", resp.raw, resp.explain(), total)
+	}
+	phases := ""
+	if d, ok := timings.times["lock acquisition"]; ok {
+		phases += fmt.Sprintf(", lock %v", d.Round(time.Microsecond))
+	}
+	if d, ok := timings.times["response wait"]; ok {
+		phases += fmt.Sprintf(", response %v", d.Round(time.Microsecond))
+	}
+	answer := resp.raw
+	if answer == "" {
+		answer = "(none)"
+	}
+	fmt.Printf("ping: %s answered %s in %v%s\n", pingTarget(t), answer, total, phases)
+	return nil
+}
+
+// pingTarget names what we pinged.
+func pingTarget(t transport) string {
+	if tg, ok := t.(targeter); ok {
+		return tg.target()
+	}
+	return "Firefox"
+}
@@ -0,0 +1,65 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+// Falling back to the XDG desktop portal.
+//
+// Under Wayland (especially with a Flatpak Firefox) there may be no
+// X remote control window to find at all. As a last resort we can
+// hand URLs to the desktop portal's OpenURI interface, which opens
+// them in whatever the user's default browser is. This is not the
+// same as talking to Firefox (you can't pick the profile, do a
+// search, or ask for a new window or tab), but the URL does get
+// opened somewhere.
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	portalDest  = "org.freedesktop.portal.Desktop"
+	portalPath  = "/org/freedesktop/portal/desktop"
+	portalIface = "org.freedesktop.portal.OpenURI"
+)
+
+// portalURI turns an argument into an absolute URI, since unlike
+// Firefox the portal won't guess. Existing files become file: URLs
+// and anything else without a scheme is assumed to be a web site.
+func portalURI(arg string) string {
+	if u, e := url.Parse(arg); e == nil && u.Scheme != "" {
+		return arg
+	}
+	if _, e := os.Stat(arg); e == nil {
+		if abs, e := filepath.Abs(arg); e == nil {
+			return (&url.URL{Scheme: "file", Path: abs}).String()
+		}
+	}
+	return "https://" + arg
+}
+
+// portalOpen opens all of the URLs through the desktop portal.
+func portalOpen(urls []string) error {
+	if len(urls) == 0 {
+		return errors.New("the desktop portal needs a URL to open")
+	}
+	conn, e := dbus.SessionBus()
+	if e != nil {
+		return fmt.Errorf("D-Bus session bus: %s", e)
+	}
+	obj := conn.Object(portalDest, portalPath)
+	for _, u := range urls {
+		opts := map[string]dbus.Variant{}
+		call := obj.Call(portalIface+".OpenURI", 0, "", portalURI(u), opts)
+		if call.Err != nil {
+			return fmt.Errorf("portal OpenURI %s: %s", u, call.Err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+// Friendly names for Firefox's own pages, for the prefs subcommand.
+//
+// Firefox's settings and other internal pages live at about: URLs,
+// and the sections of the settings are fragments that nobody
+// remembers ('about:preferences#privacy'). 'ffox-remote prefs
+// privacy' opens that for you. Besides the sections of the settings,
+// we know the other about: pages that people go to often.
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// prefPages maps the names that prefs takes to URLs.
+var prefPages = map[string]string{
+	"general":      "about:preferences#general",
+	"home":         "about:preferences#home",
+	"search":       "about:preferences#search",
+	"privacy":      "about:preferences#privacy",
+	"sync":         "about:preferences#sync",
+	"containers":   "about:preferences#containers",
+	"experimental": "about:preferences#experimental",
+
+	"addons":     "about:addons",
+	"extensions": "about:addons",
+	"downloads":  "about:downloads",
+	"logins":     "about:logins",
+	"passwords":  "about:logins",
+	"config":     "about:config",
+	"profiles":   "about:profiles",
+	"support":    "about:support",
+}
+
+// prefsURL returns the URL for the prefs subcommand's arguments,
+// which are nothing (the settings) or one name from prefPages.
+func prefsURL(args []string) (string, error) {
+	switch len(args) {
+	case 0:
+		return "about:preferences", nil
+	case 1:
+		if u, ok := prefPages[strings.ToLower(args[0])]; ok {
+			return u, nil
+		}
+		return "", fmt.Errorf("no settings page %q (we know %s)", args[0], strings.Join(prefNames(), ", "))
+	}
+	return "", fmt.Errorf("only one SECTION at a time")
+}
+
+// prefNames returns the names in prefPages, in order.
+func prefNames() []string {
+	var names []string
+	for n := range prefPages {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,204 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+// Finding out which profiles are running from their lock files.
+//
+// A running Firefox locks its profile, and on Unix one of the lock
+// files is a dangling symlink called 'lock' in the profile directory
+// whose target says who has the lock, as 'IP:+PID' (the IP address
+// of the machine's hostname and the process ID; the '+' means that
+// Firefox also holds an fcntl() lock on '.parentlock'). Firefox
+// removes the symlink when it exits normally, but if it crashes the
+// symlink is left behind, so for a local lock we check whether the
+// process is still there.
+//
+// This tells us which profiles are running even when we can't find
+// their windows (for example, because they're on another display or
+// were started with -no-remote), which helps -list and our
+// complaints when we can't find Firefox.
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/BurntSushi/xgb/xproto"
+	"github.com/BurntSushi/xgbutil"
+)
+
+// A runningProfile is a profile with a lock symlink.
+type runningProfile struct {
+	prof  foxProfile
+	host  string // the IP address in the lock
+	pid   int
+	local bool // host is this machine
+	alive bool // local and the process exists
+}
+
+// localAddrs returns the IP addresses that mean this machine.
+func localAddrs() map[string]bool {
+	addrs := make(map[string]bool)
+	if h, err := os.Hostname(); err == nil {
+		if l, err := net.LookupHost(h); err == nil {
+			for _, a := range l {
+				addrs[a] = true
+			}
+		}
+	}
+	if ias, err := net.InterfaceAddrs(); err == nil {
+		for _, a := range ias {
+			if n, ok := a.(*net.IPNet); ok {
+				addrs[n.IP.String()] = true
+			}
+		}
+	}
+	return addrs
+}
+
+// runningProfiles returns all of the profiles from profiles.ini that
+// have lock symlinks, including stale ones.
+func runningProfiles() []runningProfile {
+	var rps []runningProfile
+	local := localAddrs()
+	for _, pi := range allProfilesInis() {
+		for _, p := range pi.profiles {
+			target, err := os.Readlink(filepath.Join(p.path, "lock"))
+			if err != nil {
+				continue
+			}
+			i := strings.LastIndexByte(target, ':')
+			if i < 0 {
+				continue
+			}
+			pid, err := strconv.Atoi(strings.TrimPrefix(target[i+1:], "+"))
+			if err != nil {
+				continue
+			}
+			rp := runningProfile{prof: p, host: target[:i], pid: pid}
+			rp.local = local[rp.host]
+			rp.alive = rp.local && syscall.Kill(pid, 0) != syscall.ESRCH
+			rps = append(rps, rp)
+		}
+	}
+	sort.SliceStable(rps, func(i, j int) bool { return rps[i].prof.name < rps[j].prof.name })
+	return rps
+}
+
+// status describes who has the profile locked.
+func (rp runningProfile) status() string {
+	switch {
+	case !rp.local:
+		return fmt.Sprintf("pid %d on %s", rp.pid, rp.host)
+	case rp.alive:
+		return fmt.Sprintf("pid %d", rp.pid)
+	}
+	return fmt.Sprintf("stale lock (pid %d isn't running)", rp.pid)
+}
+
+// windows returns the Firefox windows out of wins (with properties
+// fps) that have rp's profile.
+func (rp runningProfile) windows(wins []xproto.Window, fps []foxProps) []xproto.Window {
+	var res []xproto.Window
+	for i, fp := range fps {
+		if fp.ver.set && fp.prof.val != "" && (fp.prof.val == rp.prof.path || profileMatch(fp.prof, rp.prof.name)) {
+			res = append(res, wins[i])
+		}
+	}
+	return res
+}
+
+// listRunning prints the profiles that their lock files say are
+// running, and which of the Firefox windows on xu are theirs.
+func listRunning(xu *xgbutil.XUtil) {
+	xc := liveX{xu}
+	rps := runningProfiles()
+	if len(rps) == 0 {
+		return
+	}
+	wins := candidateWindows(xc)
+	fps := fetchFoxProps(xc, wins)
+	fmt.Printf("running profiles, from their lock files:\n")
+	for _, rp := range rps {
+		where := "no window here"
+		if ws := rp.windows(wins, fps); len(ws) > 0 {
+			var ids []string
+			for _, w := range ws {
+				ids = append(ids, fmt.Sprintf("0x%x", w))
+			}
+			where = "windows " + strings.Join(ids, " ")
+		}
+		if rp.local && !rp.alive {
+			where = ""
+		}
+		fmt.Printf("  %s %s: %s", rp.prof.name, rp.prof.path, rp.status())
+		if where != "" {
+			fmt.Printf(", %s", where)
+		}
+		fmt.Printf("\n")
+	}
+}
+
+// runningHint returns something to add to 'can't find Firefox' to say
+// which profiles seem to be running, or "".
+func runningHint() string {
+	var names []string
+	for _, rp := range runningProfiles() {
+		if !rp.local || rp.alive {
+			names = append(names, rp.prof.name+" ("+rp.status()+")")
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	return "; the lock files say that these profiles are running: " + strings.Join(names, ", ")
+}
+
+// profileStates reports which profiles are running, from their lock
+// files and from the Firefox windows that we can see, for the
+// profiles subcommand.
+func (t *xTransport) profileStates() map[string]string {
+	var wins []xproto.Window
+	var fps []foxProps
+	// When we're scanning displays, we don't have a display to
+	// look at until we've found Firefox.
+	if t.xu != nil {
+		xc := liveX{t.xu}
+		wins = candidateWindows(xc)
+		fps = fetchFoxProps(xc, wins)
+	}
+	locks := make(map[string]runningProfile)
+	for _, rp := range runningProfiles() {
+		locks[rp.prof.path] = rp
+	}
+	states := make(map[string]string)
+	for _, pi := range allProfilesInis() {
+		for _, p := range pi.profiles {
+			var parts []string
+			if rp, ok := locks[p.path]; ok {
+				if rp.local && !rp.alive {
+					parts = append(parts, rp.status())
+				} else {
+					parts = append(parts, "running as "+rp.status())
+				}
+			}
+			if ws := (runningProfile{prof: p}).windows(wins, fps); len(ws) > 0 {
+				var ids []string
+				for _, w := range ws {
+					ids = append(ids, fmt.Sprintf("0x%x", w))
+				}
+				parts = append(parts, "windows "+strings.Join(ids, " "))
+			}
+			if len(parts) > 0 {
+				states[p.path] = strings.Join(parts, ", ")
+			}
+		}
+	}
+	return states
+}
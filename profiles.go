@@ -0,0 +1,286 @@
+package main
+
+// Reading Firefox's profiles.ini.
+//
+// Firefox keeps the list of your profiles in profiles.ini in its
+// data directory, which is ~/.mozilla/firefox on Unix (or under
+// ~/snap or ~/.var/app for Snap and Flatpak Firefox, and possibly
+// $XDG_CONFIG_HOME/mozilla/firefox for recent Firefoxes) and
+// %APPDATA%\Mozilla\Firefox on Windows. It looks like:
+//
+//	[Profile0]
+//	Name=default
+//	IsRelative=1
+//	Path=abcd1234.default
+//	Default=1
+//
+//	[Install4F96D1932A9F858E]
+//	Default=abcd1234.default-release
+//
+// Each [ProfileN] section is a profile. The [InstallXXX] sections
+// say which profile each Firefox installation uses by default, and
+// so do the sections of installs.ini in the same directory (which is
+// where Firefox looks first). Which profile is the default used to
+// be the one with Default=1, but modern Firefox gives each
+// installation its own default, normally a 'default-release'
+// profile.
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// A foxProfile is a profile from profiles.ini.
+type foxProfile struct {
+	name      string
+	path      string // absolute
+	isDefault bool   // Default=1 in its section
+}
+
+// A profilesIni is the contents of one profiles.ini.
+type profilesIni struct {
+	file     string
+	profiles []foxProfile
+	// installDefaults are the profile paths from [Install...]
+	// sections, which are the profiles that modern Firefox
+	// actually starts by default.
+	installDefaults []string
+}
+
+// profilesDirs returns the directories that may have a profiles.ini,
+// most likely first.
+func profilesDirs() []string {
+	if runtime.GOOS == "windows" {
+		if ad := os.Getenv("APPDATA"); ad != "" {
+			return []string{filepath.Join(ad, "Mozilla", "Firefox")}
+		}
+		return nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil
+	}
+	dirs := []string{
+		filepath.Join(home, ".mozilla", "firefox"),
+		filepath.Join(home, "snap", "firefox", "common", ".mozilla", "firefox"),
+		filepath.Join(home, ".var", "app", "org.mozilla.firefox", ".mozilla", "firefox"),
+	}
+	if cd, err := os.UserConfigDir(); err == nil {
+		dirs = append(dirs, filepath.Join(cd, "mozilla", "firefox"))
+	}
+	return dirs
+}
+
+// readProfilesIni reads the profiles.ini in dir.
+func readProfilesIni(dir string) (*profilesIni, error) {
+	fname := filepath.Join(dir, "profiles.ini")
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	pi := &profilesIni{file: fname}
+	var cur *foxProfile
+	relative := true
+	install := false
+	finish := func() {
+		if cur != nil && cur.name != "" && cur.path != "" {
+			if relative {
+				cur.path = filepath.Join(dir, filepath.FromSlash(cur.path))
+			}
+			pi.profiles = append(pi.profiles, *cur)
+		}
+		cur = nil
+	}
+	scan := bufio.NewScanner(f)
+	for scan.Scan() {
+		line := strings.TrimSpace(scan.Text())
+		if line == "" || line[0] == ';' || line[0] == '#' {
+			continue
+		}
+		if line[0] == '[' && line[len(line)-1] == ']' {
+			finish()
+			sect := line[1 : len(line)-1]
+			install = strings.HasPrefix(sect, "Install")
+			if strings.HasPrefix(sect, "Profile") {
+				cur = &foxProfile{}
+				relative = true
+			}
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		k, v := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		switch {
+		case install && k == "Default":
+			pi.installDefaults = append(pi.installDefaults, installPath(dir, v))
+		case cur == nil:
+		case k == "Name":
+			cur.name = v
+		case k == "Path":
+			cur.path = v
+		case k == "IsRelative":
+			relative = v == "1"
+		case k == "Default":
+			cur.isDefault = v == "1"
+		}
+	}
+	finish()
+	if err := scan.Err(); err != nil {
+		return nil, err
+	}
+	pi.installDefaults = append(pi.installDefaults, readInstallsIni(dir)...)
+	return pi, nil
+}
+
+// installPath turns an install's Default= value into the profile's
+// directory. It's normally relative to dir, but it's absolute if the
+// profile itself isn't (IsRelative=0).
+func installPath(dir, v string) string {
+	v = filepath.FromSlash(v)
+	if filepath.IsAbs(v) {
+		return v
+	}
+	return filepath.Join(dir, v)
+}
+
+// readInstallsIni returns the default profile paths from the
+// installs.ini in dir, if there is one.
+func readInstallsIni(dir string) []string {
+	f, err := os.Open(filepath.Join(dir, "installs.ini"))
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var defs []string
+	scan := bufio.NewScanner(f)
+	for scan.Scan() {
+		kv := strings.SplitN(strings.TrimSpace(scan.Text()), "=", 2)
+		if len(kv) == 2 && strings.TrimSpace(kv[0]) == "Default" {
+			defs = append(defs, installPath(dir, strings.TrimSpace(kv[1])))
+		}
+	}
+	return defs
+}
+
+// defaultProfile returns the default profile, if there's a single
+// one. If there are several Firefox installations with different
+// defaults, we can't tell which one you mean, so there's no default.
+func defaultProfile() (foxProfile, bool) {
+	var defs, legacy []foxProfile
+	seen := make(map[string]bool)
+	for _, pi := range allProfilesInis() {
+		for _, d := range pi.installDefaults {
+			if seen[d] {
+				continue
+			}
+			seen[d] = true
+			p := foxProfile{name: filepath.Base(d), path: d, isDefault: true}
+			for _, pp := range pi.profiles {
+				if pp.path == d {
+					p.name = pp.name
+				}
+			}
+			defs = append(defs, p)
+		}
+		for _, p := range pi.profiles {
+			if p.isDefault {
+				legacy = append(legacy, p)
+			}
+		}
+	}
+	switch {
+	case len(defs) == 1:
+		return defs[0], true
+	case len(defs) == 0 && len(legacy) == 1:
+		return legacy[0], true
+	}
+	return foxProfile{}, false
+}
+
+// allProfilesInis reads every profiles.ini we can find.
+func allProfilesInis() []*profilesIni {
+	var pis []*profilesIni
+	for _, d := range profilesDirs() {
+		if pi, err := readProfilesIni(d); err == nil {
+			pis = append(pis, pi)
+		}
+	}
+	return pis
+}
+
+// profileNames returns the names of all of the profiles in all of the
+// profiles.ini files we can find, without duplicates.
+func profileNames() []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, pi := range allProfilesInis() {
+		for _, p := range pi.profiles {
+			if !seen[p.name] {
+				seen[p.name] = true
+				names = append(names, p.name)
+			}
+		}
+	}
+	return names
+}
+
+// profilePath returns the directory of the profile called name, or
+// "" if we can't find it.
+func profilePath(name string) string {
+	for _, pi := range allProfilesInis() {
+		for _, p := range pi.profiles {
+			if p.name == name {
+				return p.path
+			}
+		}
+	}
+	return ""
+}
+
+// A profileReporter is a transport that can say which profiles are
+// running, for the profiles subcommand. profileStates returns a
+// description of each running profile, keyed by profile path.
+type profileReporter interface {
+	profileStates() map[string]string
+}
+
+// printProfiles prints every profile in every profiles.ini, marking
+// the defaults. If states isn't nil, it's what we know about which
+// profiles are running (see profileReporter), and we say for each.
+func printProfiles(states map[string]string) {
+	pis := allProfilesInis()
+	if len(pis) == 0 {
+		fmt.Printf("no profiles.ini found\n")
+		return
+	}
+	for _, pi := range pis {
+		fmt.Printf("profiles in %s:\n", pi.file)
+		for _, p := range pi.profiles {
+			fmt.Printf("  %s %s", p.name, p.path)
+			for _, d := range pi.installDefaults {
+				if d == p.path {
+					p.isDefault = true
+				}
+			}
+			if p.isDefault {
+				fmt.Printf(" (default)")
+			}
+			if states != nil {
+				st := states[p.path]
+				if st == "" {
+					st = "not running"
+				}
+				fmt.Printf(": %s", st)
+			}
+			fmt.Printf("\n")
+		}
+	}
+}
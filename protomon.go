@@ -0,0 +1,90 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+// Watching other programs talk to Firefox, for 'ffox-remote monitor'.
+//
+// This is the same property change watching that we do when we send
+// a command ourselves, except that we never stop and we report on
+// everything. We can't stop Firefox from deleting the command line
+// property as soon as it sees it, so if Firefox gets there first
+// all we can report is that a command line went by.
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/BurntSushi/xgb/xproto"
+	"github.com/BurntSushi/xgbutil"
+	"github.com/BurntSushi/xgbutil/xevent"
+	"github.com/BurntSushi/xgbutil/xwindow"
+	"github.com/siebenmann/ffox-remote/cmdline"
+)
+
+// monitorProtocol reports on every remote control property change on
+// the Firefox window until it goes away.
+func (t *xTransport) monitorProtocol() error {
+	xu, win := t.xu, t.win
+	xc := liveX{xu}
+	w := xwindow.New(xu, win)
+	if e := w.Listen(xproto.EventMaskPropertyChange, xproto.EventMaskStructureNotify); e != nil {
+		return e
+	}
+	cmdlatom := getAtom(xc, cmdlProp)
+	cmdatom := getAtom(xc, cmdProp)
+
+	stamp := func() string {
+		return time.Now().Format("15:04:05.000")
+	}
+	fmt.Printf("%s monitoring window 0x%x\n", stamp(), win)
+
+	xevent.PropertyNotifyFun(
+		func(xu *xgbutil.XUtil, ev xevent.PropertyNotifyEvent) {
+			deleted := ev.State == xproto.PropertyDelete
+			switch ev.Atom {
+			case lockatom:
+				if deleted {
+					fmt.Printf("%s unlocked\n", stamp())
+				} else {
+					fmt.Printf("%s locked by: %s\n", stamp(), propValue(xc, win, lockProp))
+				}
+			case responseatom:
+				if !deleted {
+					fmt.Printf("%s response: %s\n", stamp(), propValue(xc, win, respProp))
+				}
+			case cmdatom:
+				if !deleted {
+					fmt.Printf("%s legacy command: %s\n", stamp(), propValue(xc, win, cmdProp))
+				}
+			case cmdlatom:
+				if !deleted {
+					reportCommandLine(xu, win, stamp())
+				}
+			}
+		}).Connect(xu, win)
+	xevent.DestroyNotifyFun(
+		func(xu *xgbutil.XUtil, ev xevent.DestroyNotifyEvent) {
+			fmt.Printf("%s window went away\n", stamp())
+			xevent.Quit(xu)
+		}).Connect(xu, win)
+
+	xevent.Main(xu)
+	return nil
+}
+
+// reportCommandLine reads and prints the current command line
+// property, if it's still there.
+func reportCommandLine(xu *xgbutil.XUtil, win xproto.Window, stamp string) {
+	p, e := getProp(liveX{xu}, win, cmdlProp)
+	if e != nil || len(p.Value) == 0 {
+		fmt.Printf("%s command line: (already taken by Firefox)\n", stamp)
+		return
+	}
+	pwd, args, e := cmdline.DecodeStrict(p.Value)
+	if e != nil {
+		fmt.Printf("%s command line: undecodable (%s): %q\n", stamp, e, p.Value)
+		return
+	}
+	fmt.Printf("%s command line: %q (in %s)\n", stamp, args, pwd)
+}
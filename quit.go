@@ -0,0 +1,150 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+// Making the Firefox we found quit or restart, for the quit and
+// restart subcommands.
+//
+// The clean way is Marionette (see marionette.go), which is Firefox
+// quitting the way it does from its menu, session and all. If
+// Marionette isn't running we fall back to doing what the window
+// manager does when you close a window: we send WM_DELETE_WINDOW to
+// every window of the instance, and Firefox exits when its last
+// window is closed. This is still a normal exit (Firefox may ask
+// about closing tabs, and it cleans up its profile), but since the
+// windows close one by one, session restore will only bring back the
+// last one. There's no way to restart Firefox like this, so restart
+// needs Marionette.
+//
+// Either way, we wait for the windows to go away before we're done,
+// so that scripts can go on to do things to the profile.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/BurntSushi/xgb/xproto"
+	"github.com/BurntSushi/xgbutil/icccm"
+	"github.com/BurntSushi/xgbutil/xevent"
+)
+
+// instanceWindows returns all of the windows of the Firefox instance
+// we found.
+func (t *xTransport) instanceWindows() []xproto.Window {
+	xc := liveX{t.xu}
+	if t.ident == nil {
+		return []xproto.Window{t.win}
+	}
+	id := foxIdent(*t.ident)
+	wins := candidateWindows(xc)
+	var res []xproto.Window
+	for i, fp := range fetchFoxProps(xc, wins) {
+		if fp.ver.set && foxIdent(fp) == id {
+			res = append(res, wins[i])
+		}
+	}
+	return res
+}
+
+// quit makes the Firefox we found quit or restart.
+func (t *xTransport) quit(ctx context.Context, restart bool) error {
+	if err := t.checkOwned(); err != nil {
+		return err
+	}
+	xc := liveX{t.xu}
+	wins := t.instanceWindows()
+	if t.o.dryRun {
+		what := "quit"
+		if restart {
+			what = "restart"
+		}
+		fmt.Printf("%s: would %s the Firefox with %d windows, through Marionette or (for quit) by closing them\n", t.target(), what, len(wins))
+		return nil
+	}
+	for _, w := range wins {
+		if err := xc.listen(w); err != nil {
+			return err
+		}
+	}
+
+	err := marionetteQuit(ctx, t.foundProfileDir(), restart)
+	switch {
+	case err == nil && restart:
+		// The restarted Firefox has new windows, and the old
+		// ones may be gone before we notice; there's nothing
+		// to wait for.
+		return nil
+	case err == nil:
+		return waitForWindowsGone(ctx, xc, wins)
+	case !errors.Is(err, errNoMarionette):
+		return err
+	case restart:
+		return fmt.Errorf("can't restart Firefox: %w", err)
+	}
+	if t.o.verbose {
+		slog.Info("no Marionette, closing Firefox's windows", "windows", len(wins))
+	}
+	if err := closeWindows(xc, wins); err != nil {
+		return err
+	}
+	return waitForWindowsGone(ctx, xc, wins)
+}
+
+// closeWindows sends WM_DELETE_WINDOW to all of wins. If any of them
+// don't take it, we don't send it to any, since closing only some of
+// Firefox's windows isn't quitting.
+func closeWindows(xc liveX, wins []xproto.Window) error {
+	xu := xc.xu
+	for _, w := range wins {
+		protos, err := icccm.WmProtocolsGet(xu, w)
+		if err != nil {
+			return fmt.Errorf("window 0x%x: WM_PROTOCOLS: %s", w, err)
+		}
+		found := false
+		for _, p := range protos {
+			found = found || p == "WM_DELETE_WINDOW"
+		}
+		if !found {
+			return fmt.Errorf("window 0x%x doesn't take WM_DELETE_WINDOW", w)
+		}
+	}
+	wmProtocols, wmDelete := getAtom(xc, "WM_PROTOCOLS"), getAtom(xc, "WM_DELETE_WINDOW")
+	for _, w := range wins {
+		cm, err := xevent.NewClientMessage(32, w, wmProtocols, int(wmDelete), int(xproto.TimeCurrentTime))
+		if err != nil {
+			return err
+		}
+		err = xproto.SendEventChecked(xu.Conn(), false, w, xproto.EventMaskNoEvent, string(cm.Bytes())).Check()
+		if err != nil {
+			return fmt.Errorf("window 0x%x: sending WM_DELETE_WINDOW: %s", w, err)
+		}
+		protoLog("sent WM_DELETE_WINDOW", "window", hexWin(w))
+	}
+	return nil
+}
+
+// waitForWindowsGone waits until all of wins have been destroyed.
+func waitForWindowsGone(ctx context.Context, xc xConn, wins []xproto.Window) error {
+	left := make(map[xproto.Window]bool)
+	for _, w := range wins {
+		left[w] = true
+	}
+	events := xc.events()
+	for len(left) > 0 {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				return errors.New("X connection closed")
+			}
+			if e, ok := ev.(xproto.DestroyNotifyEvent); ok {
+				delete(left, e.Window)
+			}
+		case <-ctx.Done():
+			return fmt.Errorf("gave up waiting for Firefox to exit: %w", ctx.Err())
+		}
+	}
+	return nil
+}
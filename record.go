@@ -0,0 +1,89 @@
+package main
+
+// Recording and replaying commands.
+//
+// With -record FILE, we append a JSON line to FILE for every command
+// line we send, with both the decoded form and the encoded property
+// value we sent, plus some information about where we sent it and
+// what happened. 'ffox-remote replay FILE ...' sends all of the
+// recorded command lines again, in order. This is useful both for
+// reproducing problems with Firefox's remote control and for
+// re-opening a batch of things after a browser restart.
+//
+// The history file (-history or 'history =' in the configuration
+// file) is the same thing, except that it's meant to be left on all
+// the time; 'ffox-remote history' lists it and can re-send entries
+// from it.
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/siebenmann/ffox-remote/cmdline"
+)
+
+// A record is one recorded command line. Encoded is what the command
+// line encodes to for _MOZILLA_COMMANDLINE and D-Bus; it's for people
+// debugging things, and replay ignores it.
+type record struct {
+	Time     time.Time `json:"time"`
+	User     string    `json:"user,omitempty"`
+	Profile  string    `json:"profile,omitempty"`
+	Target   string    `json:"target,omitempty"`
+	Cwd      string    `json:"cwd"`
+	Args     []string  `json:"args"`
+	Encoded  []byte    `json:"encoded"`
+	Response string    `json:"response,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// writeRecord appends a record of sending args to target to fname.
+func writeRecord(fname string, o *options, target, cwd string, args []string, resp string, err error) error {
+	rec := record{
+		Time: time.Now(), User: o.user, Profile: o.profile, Target: target,
+		Cwd: cwd, Args: args, Encoded: cmdline.Encode(cwd, args),
+		Response: resp,
+	}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	b, e := json.Marshal(rec)
+	if e != nil {
+		return e
+	}
+	f, e := os.OpenFile(fname, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if e != nil {
+		return e
+	}
+	_, e = f.Write(append(b, '\n'))
+	if e2 := f.Close(); e == nil {
+		e = e2
+	}
+	return e
+}
+
+// readRecords reads all of the records in fname.
+func readRecords(fname string) ([]record, error) {
+	f, e := os.Open(fname)
+	if e != nil {
+		return nil, e
+	}
+	defer f.Close()
+	var recs []record
+	scan := bufio.NewScanner(f)
+	scan.Buffer(nil, 16*1024*1024)
+	for ln := 1; scan.Scan(); ln++ {
+		var rec record
+		if e := json.Unmarshal(scan.Bytes(), &rec); e != nil {
+			return nil, fmt.Errorf("%s:%d: %s", fname, ln, e)
+		}
+		if len(rec.Args) == 0 {
+			return nil, fmt.Errorf("%s:%d: no command line", fname, ln)
+		}
+		recs = append(recs, rec)
+	}
+	return recs, scan.Err()
+}
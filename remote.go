@@ -0,0 +1,89 @@
+package main
+
+// Accepting the old '-remote' syntax, for -remote.
+//
+// Before Firefox had remote command lines, you remote controlled it
+// with 'firefox -remote "openURL(http://example.com/,new-tab)"' (see
+// legacy.go for the protocol this used). Firefox dropped -remote
+// long ago, but old scripts and programs still run it, and with
+// -remote they can run us instead, unchanged. We translate the old
+// command into the modern command line and send that.
+//
+// We understand the commands that open things:
+//
+//	openURL(URL[,new-window|new-tab][,noraise])
+//	openFile(FILE[,new-window|new-tab][,noraise])
+//	openURL()
+//	xfeDoCommand(openBrowser)
+//
+// plus ping(), which is our ping. An empty openURL() and
+// openBrowser both open a new browser window. noraise is accepted
+// and ignored, since modern Firefox decides that for itself. The
+// others (mailto(), saveAs(), and so on) are long gone from Firefox,
+// so we reject them.
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A remoteCommand is an old -remote command translated into modern
+// terms.
+type remoteCommand struct {
+	ping  bool
+	where string   // "", "new-window", or "new-tab"
+	args  []string // what to open, if anything
+}
+
+// parseRemote parses an old -remote command such as
+// 'openURL(http://example.com/,new-tab)'.
+func parseRemote(s string) (remoteCommand, error) {
+	var rc remoteCommand
+	s = strings.TrimSpace(s)
+	i := strings.IndexByte(s, '(')
+	if i < 0 || !strings.HasSuffix(s, ")") {
+		return rc, fmt.Errorf("%q is not a -remote command, which looks like 'openURL(URL)'", s)
+	}
+	name := strings.TrimSpace(s[:i])
+	var params []string
+	if inner := strings.TrimSpace(s[i+1 : len(s)-1]); inner != "" {
+		params = strings.Split(inner, ",")
+	}
+
+	// Old Mozillas split the arguments on commas, so a URL with
+	// commas in it never worked reliably. We're more forgiving:
+	// we take the options off the end and treat everything
+	// before them as the URL.
+	for len(params) > 0 {
+		opt := strings.ToLower(strings.TrimSpace(params[len(params)-1]))
+		if opt == "new-window" || opt == "new-tab" {
+			rc.where = opt
+		} else if opt != "noraise" && opt != "raise" {
+			break
+		}
+		params = params[:len(params)-1]
+	}
+	target := strings.TrimSpace(strings.Join(params, ","))
+
+	switch strings.ToLower(name) {
+	case "openurl", "openfile":
+		if target != "" {
+			rc.args = []string{target}
+		} else if rc.where == "" {
+			rc.where = "new-window"
+		}
+	case "xfedocommand":
+		if !strings.EqualFold(target, "openBrowser") {
+			return rc, fmt.Errorf("xfeDoCommand(%s) is not supported, only xfeDoCommand(openBrowser)", target)
+		}
+		rc.where = "new-window"
+	case "ping":
+		if target != "" || rc.where != "" {
+			return rc, fmt.Errorf("ping() takes no arguments")
+		}
+		rc.ping = true
+	default:
+		return rc, fmt.Errorf("the -remote command %s() is not supported (only openURL, openFile, xfeDoCommand(openBrowser), and ping)", name)
+	}
+	return rc, nil
+}
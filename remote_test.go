@@ -0,0 +1,46 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRemote(t *testing.T) {
+	for _, c := range []struct {
+		in   string
+		want remoteCommand
+		err  bool
+	}{
+		{"openURL(http://example.com/)", remoteCommand{args: []string{"http://example.com/"}}, false},
+		{"openURL(http://example.com/,new-tab)", remoteCommand{where: "new-tab", args: []string{"http://example.com/"}}, false},
+		// Commas stay in the URL; only the options come off the end.
+		{"openURL(a,b,new-tab,noraise)", remoteCommand{where: "new-tab", args: []string{"a,b"}}, false},
+		{"openURL(https://e.org/?q=1,2)", remoteCommand{args: []string{"https://e.org/?q=1,2"}}, false},
+		{" openURL( https://e.org/ , NEW-WINDOW ) ", remoteCommand{where: "new-window", args: []string{"https://e.org/"}}, false},
+		{"OPENURL(https://e.org/,raise)", remoteCommand{args: []string{"https://e.org/"}}, false},
+		{"openURL()", remoteCommand{where: "new-window"}, false},
+		{"openURL(noraise)", remoteCommand{where: "new-window"}, false},
+		{"openURL(new-tab)", remoteCommand{where: "new-tab"}, false},
+		{"openFile(/tmp/x.html,new-window)", remoteCommand{where: "new-window", args: []string{"/tmp/x.html"}}, false},
+		{"xfeDoCommand(openBrowser)", remoteCommand{where: "new-window"}, false},
+		{"ping()", remoteCommand{ping: true}, false},
+
+		{"xfeDoCommand(openInbox)", remoteCommand{}, true},
+		{"ping(x)", remoteCommand{}, true},
+		{"ping(new-tab)", remoteCommand{}, true},
+		{"mailto(cks@example.org)", remoteCommand{}, true},
+		{"openURL", remoteCommand{}, true},
+		{"openURL(http://e.org/", remoteCommand{}, true},
+		{"", remoteCommand{}, true},
+	} {
+		got, err := parseRemote(c.in)
+		switch {
+		case c.err && err == nil:
+			t.Errorf("parseRemote(%q) = %+v, want an error", c.in, got)
+		case !c.err && err != nil:
+			t.Errorf("parseRemote(%q): %s", c.in, err)
+		case !c.err && !reflect.DeepEqual(got, c.want):
+			t.Errorf("parseRemote(%q) = %+v, want %+v", c.in, got, c.want)
+		}
+	}
+}
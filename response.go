@@ -0,0 +1,111 @@
+package main
+
+// Making sense of Firefox's responses.
+//
+// Responses are in the SMTP/HTTP style 'Nxx message' form, where the
+// first digit is the important part: 1xx is 'in progress', 2xx is
+// success, and 5xx is failure (3xx and 4xx aren't used). Firefox's
+// messages are terse, so we explain the ones we know about.
+//
+// Everything that sends command lines hands back a parsed response,
+// so nothing else needs to look at the digits itself.
+
+import (
+	"strconv"
+	"strings"
+)
+
+// A response is a parsed response. Code is 0 if there was no
+// response at all or it wasn't in the Nxx form. The zero response
+// is no response, which is what transports without responses (and
+// -no-wait) give you.
+type response struct {
+	raw  string
+	code int
+	msg  string
+}
+
+// Response classes, from the first digit of the code.
+const (
+	respNone       = 0 // no response, or not one we can parse
+	respInProgress = 1
+	respSuccess    = 2
+	respFailure    = 5
+)
+
+// parseResponse parses a raw response.
+func parseResponse(raw string) response {
+	r := response{raw: raw}
+	f := strings.SplitN(strings.TrimSpace(raw), " ", 2)
+	if len(f[0]) != 3 {
+		return r
+	}
+	code, err := strconv.Atoi(f[0])
+	if err != nil || code < 100 || code > 599 {
+		return r
+	}
+	r.code = code
+	if len(f) == 2 {
+		r.msg = f[1]
+	}
+	return r
+}
+
+// class returns the class of the response. Everything that's not
+// 1xx or 2xx is a failure.
+func (r response) class() int {
+	switch {
+	case r.code == 0:
+		return respNone
+	case r.code < 200:
+		return respInProgress
+	case r.code < 300:
+		return respSuccess
+	}
+	return respFailure
+}
+
+// succeeded returns true if Firefox said the command line worked.
+func (r response) succeeded() bool {
+	return r.class() == respSuccess
+}
+
+// failed returns true if Firefox said the command line didn't work.
+// No response at all isn't a failure, since we may not get one.
+func (r response) failed() bool {
+	return r.class() == respFailure
+}
+
+// String returns the raw response, so that responses print as
+// what Firefox said.
+func (r response) String() string {
+	return r.raw
+}
+
+// explanations are what we know about specific response codes from
+// Firefox (and from ffox-remote -serve).
+var explanations = map[int]string{
+	500: "Firefox couldn't decode the command line (it may not speak the same protocol version)",
+	501: "Firefox didn't recognize the command (it may not support the legacy protocol)",
+	502: "Firefox couldn't run the command",
+	509: "Firefox failed to run the command line; it may be starting up or shutting down, or its profile may be locked by another Firefox",
+}
+
+// explain returns a more readable explanation of the response.
+func (r response) explain() string {
+	if e, ok := explanations[r.code]; ok {
+		return e
+	}
+	switch r.class() {
+	case respNone:
+		if r.raw == "" {
+			return "no response (this transport doesn't have them, or Firefox didn't answer)"
+		}
+		return "a response we don't understand"
+	case respInProgress:
+		return "Firefox is still working on it"
+	case respSuccess:
+		return "success"
+	}
+	return "Firefox failed to run the command line"
+}
@@ -0,0 +1,84 @@
+package main
+
+// Taking a screenshot of a page, for the screenshot subcommand.
+//
+// The remote control protocol can only hand Firefox command lines,
+// and nothing on a command line gets you a picture of a page, so
+// this uses Marionette (see marionette.go), which means Firefox has
+// to be running with --marionette. With a URL, we open it in a new
+// background tab, wait for it to load, take the screenshot, and
+// close the tab again; without one, we take a screenshot of the tab
+// you're looking at. Marionette only allows one session at a time,
+// so this fails if something else (geckodriver, say) is driving
+// Firefox.
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// absoluteURL turns u into something that Marionette will navigate
+// to. Unlike Firefox's command line, Marionette doesn't fix up file
+// names or bare 'host/path' URLs.
+func absoluteURL(cwd, u string) string {
+	u = fileURL(cwd, u)
+	if strings.Contains(u, "://") || strings.HasPrefix(u, "about:") || strings.HasPrefix(u, "data:") {
+		return u
+	}
+	return "https://" + u
+}
+
+// screenshot writes a PNG screenshot of u (or the current tab if u
+// is "") in the Firefox running the profile in dir to the file out,
+// or standard output if out is "-". If full is set, it's of the
+// whole page instead of what fits in the window.
+func screenshot(ctx context.Context, dir, cwd, u string, full bool, out string) error {
+	if out == "" {
+		return errors.New("no output file (use -o)")
+	}
+	m, err := dialMarionette(ctx, dir)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	if err := m.call("WebDriver:NewSession", nil, nil); err != nil {
+		return err
+	}
+	defer m.call("WebDriver:DeleteSession", nil, nil)
+
+	if u != "" {
+		var tab struct {
+			Handle string `json:"handle"`
+		}
+		if err := m.call("WebDriver:NewWindow", map[string]interface{}{"type": "tab", "focus": false}, &tab); err != nil {
+			return err
+		}
+		defer m.call("WebDriver:CloseWindow", nil, nil)
+		if err := m.call("WebDriver:SwitchToWindow", map[string]interface{}{"handle": tab.Handle, "focus": false}, nil); err != nil {
+			return err
+		}
+		if err := m.call("WebDriver:Navigate", map[string]interface{}{"url": absoluteURL(cwd, u)}, nil); err != nil {
+			return err
+		}
+	}
+
+	var shot struct {
+		Value string `json:"value"`
+	}
+	if err := m.call("WebDriver:TakeScreenshot", map[string]interface{}{"full": full}, &shot); err != nil {
+		return err
+	}
+	png, err := base64.StdEncoding.DecodeString(shot.Value)
+	if err != nil {
+		return fmt.Errorf("screenshot: %w", err)
+	}
+	if out == "-" {
+		_, err = os.Stdout.Write(png)
+		return err
+	}
+	return os.WriteFile(out, png, 0644)
+}
@@ -0,0 +1,102 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+// Reading the X selection, for -selection and -clipboard.
+//
+// X doesn't store the selection anywhere; whoever owns it has it.
+// To get it, we ask the X server to have the owner convert it to
+// text and put it in a property on a window of ours (the
+// ConvertSelection request), wait for the SelectionNotify event
+// that says it's there (or that the owner couldn't or there isn't
+// one), and then read and delete the property. We ask for
+// UTF8_STRING first and fall back to STRING for old clients. We
+// don't do the INCR protocol for very large selections, since a
+// URL or a search is never that large.
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/BurntSushi/xgb"
+	"github.com/BurntSushi/xgb/xproto"
+	"github.com/BurntSushi/xgbutil/xwindow"
+)
+
+// selectionTimeout is how long we wait for the selection owner to
+// give us the selection.
+const selectionTimeout = 2 * time.Second
+
+// readSelection returns the text of the X selection which (PRIMARY,
+// for what's highlighted, or CLIPBOARD) on the display that the
+// options pick.
+func readSelection(o *options, which string) (string, error) {
+	if o.xauthority != "" {
+		os.Setenv("XAUTHORITY", o.xauthority)
+	}
+	xu, err := xconnect(o.display)
+	if err != nil {
+		return "", err
+	}
+	defer xu.Conn().Close()
+	xc := liveX{xu}
+
+	win, err := xwindow.Generate(xu)
+	if err != nil {
+		return "", err
+	}
+	err = win.CreateChecked(xu.RootWin(), 0, 0, 1, 1, xproto.CwEventMask, xproto.EventMaskPropertyChange)
+	if err != nil {
+		return "", err
+	}
+	sel := getAtom(xc, which)
+	prop := getAtom(xc, "FFOX_REMOTE_SELECTION")
+	events := xevents(xu)
+	for _, target := range []string{"UTF8_STRING", "STRING"} {
+		start := time.Now()
+		xproto.ConvertSelection(xu.Conn(), win.Id, sel, getAtom(xc, target), prop, xproto.TimeCurrentTime)
+		ok, err := waitForSelection(events, win.Id)
+		trace(start, "ConvertSelection %s to %s: %v %v", which, target, ok, err)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			continue
+		}
+		p, err := readProp(xc, win.Id, prop, true)
+		if err != nil {
+			return "", err
+		}
+		if p.Format == 0 {
+			continue
+		}
+		if n, _ := xc.atomName(p.Type); n == "INCR" {
+			return "", fmt.Errorf("the %s selection is too large", which)
+		}
+		return string(p.Value), nil
+	}
+	return "", fmt.Errorf("there is no %s selection, or its owner can't give it to us as text", which)
+}
+
+// waitForSelection waits for the SelectionNotify event for win,
+// reporting whether the selection was converted.
+func waitForSelection(events chan xgb.Event, win xproto.Window) (bool, error) {
+	tmo := time.NewTimer(selectionTimeout)
+	defer tmo.Stop()
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				return false, errors.New("X connection closed")
+			}
+			if e, ok := ev.(xproto.SelectionNotifyEvent); ok && e.Requestor == win {
+				return e.Property != xproto.AtomNone, nil
+			}
+		case <-tmo.C:
+			return false, errors.New("timed out waiting for the selection's owner")
+		}
+	}
+}
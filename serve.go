@@ -0,0 +1,200 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+// Being the receiving end of the remote control protocol, for -serve
+// and -bridge.
+//
+// With -serve, we create our own (unmapped) window with the _MOZILLA_*
+// identification properties that Firefox would set, using -P, -U,
+// and -G for the profile, user, and program. Then we wait for remote
+// control clients (including other copies of ffox-remote, or Firefox
+// itself) to send us command lines. For each one we run the handler
+// command with the command line's arguments (without the program
+// name) as its arguments, in the command line's working directory if
+// it exists here. The response is '200 executed command' if the
+// handler succeeds and a 5xx error otherwise. Like current Firefox,
+// we don't support the legacy _MOZILLA_COMMAND protocol.
+//
+// This lets you put something else behind the Firefox remote control
+// protocol, such as a script that picks a browser based on the URL.
+//
+// With -bridge PREFIX, we find the real Firefox as usual and then
+// serve under the property prefix PREFIX with the same identity as
+// it, passing every command line we get on to it and its response
+// back. Combined with -pref, this lets you put a Firefox behind a
+// different prefix (or put something in front of one).
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"os/user"
+
+	"github.com/BurntSushi/xgb/xproto"
+	"github.com/BurntSushi/xgbutil"
+	"github.com/BurntSushi/xgbutil/xevent"
+	"github.com/BurntSushi/xgbutil/xprop"
+	"github.com/BurntSushi/xgbutil/xwindow"
+	"github.com/siebenmann/ffox-remote/cmdline"
+)
+
+// serveProps are the names of the properties that we serve with,
+// which aren't necessarily the ones that we talk to Firefox with.
+type serveProps struct {
+	vers, user, prof, prog string
+	cmdl, cmd, resp        string
+}
+
+// servePropsFor returns the property names for a prefix, or the
+// names we use to talk to Firefox if the prefix is "".
+func servePropsFor(pfix string) serveProps {
+	if pfix == "" {
+		return serveProps{versProp, userProp, profProp, progProp, cmdlProp, cmdProp, respProp}
+	}
+	return serveProps{pfix + "_VERSION", pfix + "_USER", pfix + "_PROFILE", pfix + "_PROGRAM",
+		pfix + "_COMMANDLINE", pfix + "_COMMAND", pfix + "_RESPONSE"}
+}
+
+// serveIdentity returns the identification property values for our
+// window for -serve.
+func serveIdentity(o *options) [4]string {
+	usr := o.user
+	if usr == "" {
+		if u, e := user.Current(); e == nil {
+			usr = u.Username
+		}
+	}
+	ver := firefoxVersion
+	if o.protover != "" {
+		ver = o.protover
+	}
+	return [4]string{ver, usr, o.profile, o.programs[0]}
+}
+
+// runHandler runs the handler on a command line and returns the
+// response to send back.
+func runHandler(handler, pwd string, args []string) string {
+	if len(args) == 0 {
+		return "509 empty command line"
+	}
+	cmd := exec.Command(handler, args[1:]...)
+	if fi, e := os.Stat(pwd); e == nil && fi.IsDir() {
+		cmd.Dir = pwd
+	}
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if e := cmd.Run(); e != nil {
+		return fmt.Sprintf("509 handler failed: %s", e)
+	}
+	return "200 executed command"
+}
+
+// serve pretends to be Firefox until we're killed, running handler
+// for each command line.
+func (t *xTransport) serve(handler string) error {
+	return t.serveAs(servePropsFor(""), serveIdentity(t.o),
+		func(pwd string, args []string) string {
+			return runHandler(handler, pwd, args)
+		})
+}
+
+// bridge pretends to be the Firefox we found under a different
+// property prefix, passing command lines on to it.
+func (t *xTransport) bridge(pfix string) error {
+	xc := liveX{t.xu}
+	sp := servePropsFor(pfix)
+	if sp.vers == versProp {
+		return errors.New("can't bridge to the same property prefix")
+	}
+	if err := t.checkOwned(); err != nil {
+		return err
+	}
+	ident := [4]string{t.ver, propValue(xc, t.win, userProp),
+		propValue(xc, t.win, profProp), propValue(xc, t.win, progProp)}
+	return t.serveAs(sp, ident, func(pwd string, args []string) string {
+		resp, err := t.deliver(t.win, t.ver, pwd, args)
+		if err != nil {
+			slog.Warn("", "err", err)
+			return "509 can't pass on the command line"
+		}
+		return resp.raw
+	})
+}
+
+// serveAs creates a window with the given properties and identity
+// (version, user, profile, and program) and then handles every
+// command line sent to it with handle, which returns the response.
+func (t *xTransport) serveAs(sp serveProps, ident [4]string, handle func(string, []string) string) error {
+	xu := t.xu
+	if xu == nil {
+		return fmt.Errorf("can't serve with -scan-displays")
+	}
+	xc := liveX{xu}
+	win, err := xwindow.Generate(xu)
+	if err != nil {
+		return err
+	}
+	err = win.CreateChecked(xu.RootWin(), 0, 0, 1, 1, xproto.CwEventMask, xproto.EventMaskPropertyChange)
+	if err != nil {
+		return err
+	}
+	for i, p := range []string{sp.vers, sp.user, sp.prof, sp.prog} {
+		if err := xprop.ChangeProp(xu, win.Id, 8, p, "STRING", []byte(ident[i])); err != nil {
+			return err
+		}
+	}
+	cmdlatom := getAtom(xc, sp.cmdl)
+	cmdatom := getAtom(xc, sp.cmd)
+	if t.o.verbose {
+		fmt.Printf("serving on window: 0x%x\n", win.Id)
+	}
+
+	// Firefox reads and deletes the command line in one operation,
+	// so that it can't see the same command line twice.
+	take := func(a xproto.Atom) []byte {
+		p, err := readProp(xc, win.Id, a, true)
+		if err != nil {
+			return nil
+		}
+		return p.Value
+	}
+	respond := func(resp string) {
+		if err := xprop.ChangeProp(xu, win.Id, 8, sp.resp, "STRING", []byte(resp)); err != nil {
+			slog.Warn("setting response", "err", err)
+		}
+	}
+
+	xevent.PropertyNotifyFun(
+		func(xu *xgbutil.XUtil, ev xevent.PropertyNotifyEvent) {
+			if ev.State != xproto.PropertyNewValue {
+				return
+			}
+			switch ev.Atom {
+			case cmdlatom:
+				val := take(cmdlatom)
+				if val == nil {
+					return
+				}
+				// Anyone can send us a command line, so
+				// we're strict about what we'll take.
+				pwd, args, err := cmdline.DecodeStrict(val)
+				if err != nil {
+					slog.Warn("rejected a command line", "err", err)
+					respond("500 command not parseable")
+					return
+				}
+				if t.o.verbose {
+					fmt.Printf("command line: %q (in %s)\n", args, pwd)
+				}
+				respond(handle(pwd, args))
+			case cmdatom:
+				take(cmdatom)
+				respond("501 legacy commands are not supported")
+			}
+		}).Connect(xu, win.Id)
+	xevent.Main(xu)
+	return nil
+}
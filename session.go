@@ -0,0 +1,205 @@
+package main
+
+// Reopening tabs from Firefox session store files, for 'ffox-remote
+// session'.
+//
+// Firefox saves your session in sessionstore.jsonlz4 in the profile
+// directory when it exits, and while it's running keeps copies in
+// sessionstore-backups/ (recovery.jsonlz4, recovery.baklz4, and
+// previous.jsonlz4 from the last session). These are JSON compressed
+// with LZ4 in Mozilla's own framing; see the mozlz4 package.
+//
+// We only care about a little bit of the JSON: the windows, their
+// tabs, and the current history entry of each tab. For each window
+// that we reopen, we send one command line that opens all of its
+// tabs in a new window.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/siebenmann/ffox-remote/mozlz4"
+)
+
+// The parts of a session store that we care about.
+type sessEntry struct {
+	URL   string `json:"url"`
+	Title string `json:"title"`
+}
+type sessTab struct {
+	Entries []sessEntry `json:"entries"`
+	// Index is the 1-based index of the current entry.
+	Index int `json:"index"`
+}
+type sessWindow struct {
+	Tabs []sessTab `json:"tabs"`
+}
+type session struct {
+	Windows       []sessWindow `json:"windows"`
+	ClosedWindows []sessWindow `json:"_closedWindows"`
+}
+
+// current returns the current history entry of the tab.
+func (t sessTab) current() (sessEntry, bool) {
+	if len(t.Entries) == 0 {
+		return sessEntry{}, false
+	}
+	i := t.Index - 1
+	if i < 0 || i >= len(t.Entries) {
+		i = len(t.Entries) - 1
+	}
+	return t.Entries[i], true
+}
+
+// urls returns the URLs of the current entries of all of the
+// window's tabs.
+func (w sessWindow) urls() []string {
+	var urls []string
+	for _, t := range w.Tabs {
+		if e, ok := t.current(); ok && e.URL != "" {
+			urls = append(urls, e.URL)
+		}
+	}
+	return urls
+}
+
+// sessionFiles are the session store files in a profile, most
+// preferred first if they're equally recent.
+var sessionFiles = []string{
+	"sessionstore.jsonlz4",
+	"sessionstore-backups/recovery.jsonlz4",
+	"sessionstore-backups/recovery.baklz4",
+	"sessionstore-backups/previous.jsonlz4",
+}
+
+// sessionFile returns the session store file to read for name, which
+// is either a file or a profile directory. For a directory, we use
+// the most recently written session store file in it.
+func sessionFile(name string) (string, error) {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return "", err
+	}
+	if !fi.IsDir() {
+		return name, nil
+	}
+	best := ""
+	var bestfi os.FileInfo
+	for _, f := range sessionFiles {
+		fn := filepath.Join(name, f)
+		fi, err := os.Stat(fn)
+		if err != nil {
+			continue
+		}
+		if bestfi == nil || fi.ModTime().After(bestfi.ModTime()) {
+			best, bestfi = fn, fi
+		}
+	}
+	if best == "" {
+		return "", fmt.Errorf("%s: no session store files", name)
+	}
+	return best, nil
+}
+
+// readSession reads a session store file (or the best one in a
+// profile directory).
+func readSession(name string) (*session, error) {
+	fn, err := sessionFile(name)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	// Old Firefoxes wrote plain JSON.
+	if mozlz4.IsMozLz4(b) {
+		if b, err = mozlz4.Decode(b); err != nil {
+			return nil, fmt.Errorf("%s: %s", fn, err)
+		}
+	}
+	var s session
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, fmt.Errorf("%s: %s", fn, err)
+	}
+	return &s, nil
+}
+
+// allWindows returns the open windows and then the closed ones.
+func (s *session) allWindows() []sessWindow {
+	return append(append([]sessWindow{}, s.Windows...), s.ClosedWindows...)
+}
+
+// list prints the windows and tabs in the session, numbered
+// the way that pick wants them.
+func (s *session) list() {
+	for i, w := range s.allWindows() {
+		closed := ""
+		if i >= len(s.Windows) {
+			closed = " (closed)"
+		}
+		fmt.Printf("window %d%s: %d tabs\n", i+1, closed, len(w.Tabs))
+		for j, t := range w.Tabs {
+			if e, ok := t.current(); ok {
+				fmt.Printf("  %d.%d\t%s\t%s\n", i+1, j+1, e.URL, e.Title)
+			}
+		}
+	}
+}
+
+// pick returns the URLs to reopen for sel, grouped by window. Each
+// element of sel is either a window number from list, for all of its
+// tabs, or WINDOW.TAB for a single tab; tabs from the same window are
+// grouped together. An empty sel picks all of the open windows.
+func (s *session) pick(sel []string) ([][]string, error) {
+	all := s.allWindows()
+	if len(sel) == 0 {
+		var groups [][]string
+		for _, w := range s.Windows {
+			if urls := w.urls(); len(urls) > 0 {
+				groups = append(groups, urls)
+			}
+		}
+		return groups, nil
+	}
+	var groups [][]string
+	where := make(map[int]int)
+	for _, n := range sel {
+		wn, tn := strings.TrimSpace(n), ""
+		if i := strings.IndexByte(wn, '.'); i >= 0 {
+			wn, tn = wn[:i], wn[i+1:]
+		}
+		wi, err := strconv.Atoi(wn)
+		if err != nil || wi < 1 || wi > len(all) {
+			return nil, fmt.Errorf("no window %q in the session", n)
+		}
+		w := all[wi-1]
+		var urls []string
+		if tn == "" {
+			urls = w.urls()
+		} else {
+			ti, err := strconv.Atoi(tn)
+			if err != nil || ti < 1 || ti > len(w.Tabs) {
+				return nil, fmt.Errorf("no tab %q in the session", n)
+			}
+			if e, ok := w.Tabs[ti-1].current(); ok && e.URL != "" {
+				urls = []string{e.URL}
+			}
+		}
+		if len(urls) == 0 {
+			continue
+		}
+		if g, ok := where[wi]; ok {
+			groups[g] = append(groups[g], urls...)
+		} else {
+			where[wi] = len(groups)
+			groups = append(groups, urls)
+		}
+	}
+	return groups, nil
+}
@@ -0,0 +1,251 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+// Taking requests on sockets from systemd, for -socket-activated.
+//
+// With systemd socket activation, systemd listens on sockets for us
+// and only starts us when someone connects to one, passing us the
+// listening sockets as file descriptors 3 and up, with LISTEN_FDS
+// saying how many there are and LISTEN_PID saying that they're for
+// us (and not some parent of ours). So nothing runs until something
+// actually wants to open a URL. We take two sorts of socket:
+//
+//   - a Unix stream socket, where each connection sends URLs, one
+//     per line, and then shuts down its side; we answer 'ok' or
+//     'error: ...' and close the connection. With no URLs, we open
+//     a new window. 'echo URL | socat - UNIX-CONNECT:PATH' will do.
+//   - a TCP socket, which speaks HTTP. A POST to /open with as many
+//     url parameters as you want (and optionally where=new-tab or
+//     where=new-window) opens the URLs; there's nothing else. Anyone
+//     who can connect can open things in your Firefox, so only
+//     listen on localhost.
+//
+// Nothing that starts with '-' is taken as a URL, since Firefox would
+// take it as an option, and whoever is on the other end of a socket
+// doesn't get to pick Firefox's options. Web pages can also make your
+// browser send requests to localhost, so the HTTP side only takes
+// POSTs with an 'X-Ffox-Remote' header (which a page can't add to a
+// request to somewhere else without our permission, which we never
+// give) and no Origin header from anywhere else.
+//
+// Requests are queued and sent to Firefox the way -app-service does
+// (see appservice.go), so the answer only means that we'll try. Once
+// we've been idle for socketIdle we exit, and systemd starts us again
+// when the next request comes in.
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// socketIdle is how long we wait with nothing to do before we exit.
+const socketIdle = 5 * time.Minute
+
+// socketReadTimeout is how long a Unix socket client has to send us
+// its URLs.
+const socketReadTimeout = 10 * time.Second
+
+// maxSocketRequest is the most we'll read from a Unix socket client.
+const maxSocketRequest = 1 << 20
+
+// socketHeader is the header that HTTP requests have to have.
+const socketHeader = "X-Ffox-Remote"
+
+// checkURLs returns an error if any of urls would be taken as an
+// option by Firefox.
+func checkURLs(urls []string) error {
+	for _, u := range urls {
+		if strings.HasPrefix(u, "-") {
+			return fmt.Errorf("%q isn't a URL", u)
+		}
+	}
+	return nil
+}
+
+// activationListeners returns the listening sockets that systemd has
+// passed us, and unsets the environment variables that say so, so
+// that programs we run (hooks, say) don't think they have them too.
+func activationListeners() ([]net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, errors.New("we weren't started by systemd socket activation (LISTEN_PID isn't us)")
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, errors.New("systemd didn't give us any sockets (LISTEN_FDS)")
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	for _, ev := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		os.Unsetenv(ev)
+	}
+	var ls []net.Listener
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("fd %d", 3+i)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(3+i), name)
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("socket %s: %s", name, err)
+		}
+		ls = append(ls, l)
+	}
+	return ls, nil
+}
+
+// socketService serves requests from activation sockets.
+type socketService struct {
+	*appService
+	last atomic.Int64 // when we last had a request, in Unix nanoseconds
+}
+
+// request queues args for Firefox.
+func (s *socketService) request(args []string) error {
+	s.last.Store(time.Now().UnixNano())
+	return s.offer(args)
+}
+
+// serveUnix takes URLs from one Unix socket connection.
+func (s *socketService) serveUnix(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(socketReadTimeout))
+	args := []string{"firefox"}
+	sc := bufio.NewScanner(io.LimitReader(conn, maxSocketRequest))
+	for sc.Scan() {
+		if u := strings.TrimSpace(sc.Text()); u != "" {
+			args = append(args, u)
+		}
+	}
+	err := sc.Err()
+	if err == nil {
+		err = checkURLs(args[1:])
+	}
+	if err == nil {
+		if len(args) == 1 {
+			args = append(args, "-new-window")
+		}
+		err = s.request(args)
+	}
+	if err != nil {
+		fmt.Fprintf(conn, "error: %s\n", err)
+		return
+	}
+	fmt.Fprintf(conn, "ok\n")
+}
+
+// ServeHTTP takes URLs from HTTP POSTs to /open.
+func (s *socketService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/open" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "only POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.Header.Get(socketHeader) == "" {
+		http.Error(w, "no "+socketHeader+" header", http.StatusForbidden)
+		return
+	}
+	if org := r.Header.Get("Origin"); org != "" && org != "http://"+r.Host {
+		http.Error(w, "requests from "+org+" aren't allowed", http.StatusForbidden)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	args := []string{"firefox"}
+	switch where := r.Form.Get("where"); where {
+	case "":
+	case "new-tab", "new-window":
+		args = append(args, "-"+where)
+	default:
+		http.Error(w, "where must be new-tab or new-window", http.StatusBadRequest)
+		return
+	}
+	urls := r.Form["url"]
+	if err := checkURLs(urls); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(urls) == 0 && len(args) == 1 {
+		args = append(args, "-new-window")
+	}
+	if err := s.request(append(args, urls...)); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintf(w, "ok\n")
+}
+
+// runSocketService serves requests on the sockets that systemd gave
+// us until we've been idle for socketIdle.
+func runSocketService(t transport, o *options, recfile, cwd string) error {
+	ls, err := activationListeners()
+	if err != nil {
+		return err
+	}
+	s := &socketService{appService: newAppService(t, o, recfile, cwd)}
+	s.last.Store(time.Now().UnixNano())
+	go s.run()
+	for _, l := range ls {
+		if o.verbose {
+			slog.Info("serving", "socket", l.Addr().String(), "network", l.Addr().Network())
+		}
+		switch l.Addr().Network() {
+		case "unix":
+			go func(l net.Listener) {
+				for {
+					conn, err := l.Accept()
+					if err != nil {
+						slog.Warn("accept", "err", err)
+						return
+					}
+					go s.serveUnix(conn)
+				}
+			}(l)
+		case "tcp", "tcp4", "tcp6":
+			srv := &http.Server{Handler: s, ReadHeaderTimeout: socketReadTimeout}
+			go func(l net.Listener) {
+				if err := srv.Serve(l); err != nil {
+					slog.Warn("HTTP", "err", err)
+				}
+			}(l)
+		default:
+			return fmt.Errorf("socket %s: we can't serve %s sockets", l.Addr(), l.Addr().Network())
+		}
+	}
+
+	// We're only idle once whatever was queued has been sent.
+	for {
+		idle := time.Since(time.Unix(0, s.last.Load()))
+		switch {
+		case idle < socketIdle:
+			time.Sleep(socketIdle - idle)
+		case s.idle():
+			if o.verbose {
+				slog.Info("idle, exiting")
+			}
+			return nil
+		default:
+			time.Sleep(time.Second)
+		}
+	}
+}
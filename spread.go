@@ -0,0 +1,78 @@
+package main
+
+// Spreading URLs across several Firefox instances, for -spread.
+//
+// Normally we find the best matching Firefox and send it everything.
+// With -spread we find every matching Firefox instance instead (one
+// window per instance, since several windows from one Firefox all
+// lead to the same place) and deal the URLs out to them round-robin,
+// the first URL to the best instance, the second to the next best,
+// and so on. Each instance gets one command line with all of its
+// URLs, and we report what each one said.
+//
+// This is handy for splitting a pile of research tabs across
+// profiles, or for load testing several Firefoxes at once.
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// A spreader is a transport that can find every matching Firefox
+// instance, not just the best one. Each transport it returns has
+// already found its Firefox.
+type spreader interface {
+	instances() []transport
+}
+
+// instanceName describes t's Firefox in a few words, for reports.
+func instanceName(t transport) string {
+	name := pingTarget(t)
+	if d, ok := t.(detailer); ok {
+		for _, f := range d.details() {
+			if f.key == "profile" {
+				name += " profile " + f.value
+				break
+			}
+		}
+	}
+	return name
+}
+
+// spread deals urls out across ts round-robin and sends each
+// instance its share, as the command line opts plus its URLs. It
+// reports each instance's response and returns false if any of them
+// failed.
+func spread(ctx context.Context, ts []transport, o *options, recfile, cwd string, opts, urls []string) bool {
+	shares := make([][]string, len(ts))
+	for i, u := range urls {
+		shares[i%len(ts)] = append(shares[i%len(ts)], u)
+	}
+	ok := true
+	for i, t := range ts {
+		if len(shares[i]) == 0 {
+			continue
+		}
+		name := instanceName(t)
+		args := append(append([]string{}, opts...), shares[i]...)
+		resp, err := sendRecorded(ctx, t, o, recfile, cwd, args)
+		if err != nil {
+			slog.Error(name, "err", err)
+			ok = false
+			continue
+		}
+		answer := resp.raw
+		if answer == "" {
+			answer = "(no response)"
+		}
+		fmt.Printf("%s: %d URLs: %s\n", name, len(shares[i]), answer)
+		if o.verbose {
+			fmt.Printf("meaning: %s\n", resp.explain())
+		}
+		if resp.failed() {
+			ok = false
+		}
+	}
+	return ok
+}
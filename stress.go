@@ -0,0 +1,169 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+// Stress testing the locking protocol, for 'ffox-remote stress'.
+//
+// Taking the lock is the delicate part of the protocol, since every
+// client has to get it right for it to work. This starts a number of
+// concurrent senders, each with its own X connection, that all
+// hammer on the same Firefox window. Since they're all in the same
+// process, we can notice things that no single client could:
+//
+//   - lost locks, where a sender thinks it holds _MOZILLA_LOCK while
+//     another sender also thinks it does.
+//   - missed responses, where a sender saw no response or a response
+//     that wasn't a 2xx.
+//   - stuck senders, which haven't made progress for stressStuck.
+//     We report what they were doing and then give up on them.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/BurntSushi/xgbutil"
+	"github.com/siebenmann/ffox-remote/cmdline"
+)
+
+// stressStuck is how long a sender can go without making progress
+// before we decide it's stuck.
+const stressStuck = 15 * time.Second
+
+// A stressSender is one concurrent sender and what it's doing.
+type stressSender struct {
+	xu     *xgbutil.XUtil
+	mu     sync.Mutex
+	state  string
+	when   time.Time
+	sent   int
+	missed int
+	lost   int
+}
+
+// setState records that the sender is now doing something.
+func (s *stressSender) setState(state string) {
+	s.mu.Lock()
+	s.state, s.when = state, time.Now()
+	s.mu.Unlock()
+}
+
+// add increments one of the sender's counters.
+func (s *stressSender) add(c *int) {
+	s.mu.Lock()
+	*c++
+	s.mu.Unlock()
+}
+
+// stress runs senders concurrent senders, each sending args n times,
+// and reports on what went wrong.
+func (t *xTransport) stress(ctx context.Context, senders, n int, cwd string, args []string) error {
+	if senders < 1 || n < 1 {
+		return errors.New("need at least one sender and one command line")
+	}
+	if protoClass(t.ver) == protoLegacy {
+		return errors.New("can't stress test the legacy protocol")
+	}
+	if err := t.checkOwned(); err != nil {
+		return err
+	}
+	if len(args) == 1 {
+		args = append(args, "about:blank")
+	}
+	enc := cmdline.Encode(cwd, args)
+	// Phase timing isn't safe with concurrent senders.
+	timings = nil
+
+	// We connect everything first, because connecting sets our
+	// global atoms.
+	var ss []*stressSender
+	for i := 0; i < senders; i++ {
+		xu, err := xconnect(t.displayName())
+		if err != nil {
+			return err
+		}
+		ss = append(ss, &stressSender{xu: xu, state: "starting", when: time.Now()})
+	}
+
+	var holders int32
+	done := make(chan *stressSender, senders)
+	for _, s := range ss {
+		go func(s *stressSender) {
+			xc, win := liveX{s.xu}, t.win
+			if e := xc.listen(win); e != nil {
+				s.setState(fmt.Sprintf("listen error: %s", e))
+				done <- s
+				return
+			}
+			for i := 0; i < n; i++ {
+				s.setState("waiting for the lock")
+				if e := lockFirefox(ctx, xc, win, 0, false); e != nil {
+					s.setState(fmt.Sprintf("failed to lock: %s", e))
+					done <- s
+					return
+				}
+				if atomic.AddInt32(&holders, 1) > 1 {
+					s.add(&s.lost)
+				}
+				s.setState("setting the command line")
+				if e := changeProp(xc, win, cmdlProp, enc); e != nil {
+					s.add(&s.missed)
+				} else {
+					s.setState("waiting for the response")
+					if r, _ := getResponse(ctx, xc, win); !r.succeeded() {
+						s.add(&s.missed)
+					}
+				}
+				atomic.AddInt32(&holders, -1)
+				unlockFirefox(xc, win)
+				xc.sync()
+				s.add(&s.sent)
+			}
+			s.setState("done")
+			done <- s
+		}(s)
+	}
+
+	// Wait for everyone to finish or get stuck.
+	finished := 0
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+	for finished < senders {
+		select {
+		case <-done:
+			finished++
+		case <-tick.C:
+			stuck := 0
+			for _, s := range ss {
+				s.mu.Lock()
+				if s.state != "done" && time.Since(s.when) > stressStuck {
+					stuck++
+				}
+				s.mu.Unlock()
+			}
+			if finished+stuck >= senders {
+				finished = senders
+			}
+		}
+	}
+
+	var sent, missed, lost, stuck int
+	for i, s := range ss {
+		s.mu.Lock()
+		sent += s.sent
+		missed += s.missed
+		lost += s.lost
+		if s.state != "done" {
+			stuck++
+			fmt.Printf("sender %d: never finished: %s (for %v, after %d command lines)\n", i, s.state, time.Since(s.when).Round(time.Second), s.sent)
+		}
+		s.mu.Unlock()
+	}
+	fmt.Printf("%d senders, %d command lines sent: %d lost locks, %d missed responses, %d stuck senders\n",
+		senders, sent, lost, missed, stuck)
+	return nil
+}
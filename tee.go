@@ -0,0 +1,91 @@
+package main
+
+// Sending the same thing to several Firefoxes, for -tee.
+//
+// -tee takes a list of targets, each of which is either the name of
+// an instance from the configuration file or a profile (as for -P),
+// and sends every one of them the same command line, one after the
+// other. This is for checking how a page looks in several profiles
+// (or several Firefox versions, if you give them their own instances
+// with different programs or property prefixes) from one command.
+//
+// Each target is found separately, with its own transport, so one of
+// them can be on D-Bus while the others are on X. A target that we
+// can't find is reported and skipped; it doesn't stop the others.
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// teeOptions returns the options for the tee target name, starting
+// from o. Settings from the command line (in set) still win over the
+// instance's.
+func teeOptions(o *options, cfg *config, set map[string]bool, name string) *options {
+	no := *o
+	no.profile, no.profileAlt = name, ""
+	if in := cfg.instances[name]; in != nil {
+		in.apply(&no, set)
+		if no.user == "auto" {
+			no.user = loginName()
+		}
+	}
+	return &no
+}
+
+// teeTransport finds the Firefox for the options o, trying the
+// alternate transport if the usual one doesn't work out.
+func teeTransport(ctx context.Context, o *options) (transport, error) {
+	t, err := newTransport(o)
+	if err != nil {
+		if t = altTransport(o, nil); t == nil {
+			return nil, err
+		}
+	}
+	if err = t.find(ctx); err != nil {
+		alt := altTransport(o, t)
+		if alt == nil || alt.find(ctx) != nil {
+			return nil, err
+		}
+		t = alt
+	}
+	return t, nil
+}
+
+// tee sends args to each of the targets in names, reporting what
+// each of them said. It returns false if any target couldn't be
+// found or failed.
+func tee(ctx context.Context, names []string, o *options, cfg *config, set map[string]bool, recfile, cwd string, args []string) bool {
+	ok := true
+	for _, name := range names {
+		to := teeOptions(o, cfg, set, name)
+		t, err := teeTransport(ctx, to)
+		if err != nil {
+			slog.Error(name, "err", err)
+			ok = false
+			continue
+		}
+		if to.verbose {
+			t.describe()
+		}
+		resp, err := sendRecorded(ctx, t, to, recfile, cwd, args)
+		if err != nil {
+			slog.Error(name, "err", err)
+			ok = false
+			continue
+		}
+		answer := resp.raw
+		if answer == "" {
+			answer = "(no response)"
+		}
+		fmt.Printf("%s: %s: %s\n", name, pingTarget(t), answer)
+		if to.verbose {
+			fmt.Printf("meaning: %s\n", resp.explain())
+		}
+		if resp.failed() {
+			ok = false
+		}
+	}
+	return ok
+}
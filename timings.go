@@ -0,0 +1,51 @@
+package main
+
+// Timing how long each phase of talking to Firefox takes, for
+// -timings. Phases that happen more than once (such as connecting to
+// several X displays with -scan-displays) are added up.
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// phaseTimes is the accumulated time for each phase, in the order
+// we first saw them.
+type phaseTimes struct {
+	order []string
+	times map[string]time.Duration
+	start time.Time
+}
+
+// timings is nil unless we're timing things.
+var timings *phaseTimes
+
+// startTimings starts timing phases.
+func startTimings() {
+	timings = &phaseTimes{times: make(map[string]time.Duration), start: time.Now()}
+}
+
+// timePhase records that the phase name started at start and has
+// just finished. It's usually deferred.
+func timePhase(name string, start time.Time) {
+	if timings == nil {
+		return
+	}
+	if _, ok := timings.times[name]; !ok {
+		timings.order = append(timings.order, name)
+	}
+	timings.times[name] += time.Since(start)
+}
+
+// reportTimings prints how long each phase took, and the total, on
+// standard error.
+func reportTimings() {
+	if timings == nil {
+		return
+	}
+	for _, n := range timings.order {
+		fmt.Fprintf(os.Stderr, "%-20s %v\n", n+":", timings.times[n].Round(time.Microsecond))
+	}
+	fmt.Fprintf(os.Stderr, "%-20s %v\n", "total:", time.Since(timings.start).Round(time.Microsecond))
+}
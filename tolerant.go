@@ -0,0 +1,85 @@
+package main
+
+// Putting up with odd arguments, for -tolerant.
+//
+// Mail clients, mailcap entries, and old URL handlers run us with
+// whatever they think a browser takes: options we've never heard of,
+// empty arguments, and placeholders like '%s' or '%u' that they
+// didn't fill in. Normally any of these is an error (or, for the
+// placeholders, a very odd URL). With -tolerant we quietly drop
+// them instead, so that the URL still gets opened. We also take our
+// options after the URLs, since handlers like to put their extras at
+// the end.
+//
+// -tolerant has to be looked for before the options are parsed,
+// since the whole point is to parse them differently.
+
+import (
+	"flag"
+	"regexp"
+	"strings"
+)
+
+// placeholderRe matches the unexpanded placeholders that mailcap
+// entries and desktop files use, such as '%s', '%u', and
+// '%{charset}'.
+var placeholderRe = regexp.MustCompile(`^%([a-zA-Z]|\{[^}]*\})$`)
+
+// wantsTolerant reports whether -tolerant is among argv's options.
+func wantsTolerant(argv []string) bool {
+	for _, a := range argv {
+		switch a {
+		case "--":
+			return false
+		case "-tolerant", "--tolerant", "-tolerant=true", "--tolerant=true":
+			return true
+		}
+	}
+	return false
+}
+
+// tolerantArgs returns argv with the options that fs doesn't know,
+// empty arguments, and unexpanded placeholders removed, and all of
+// the options moved ahead of the other arguments. It also returns
+// what it removed.
+func tolerantArgs(fs *flag.FlagSet, argv []string) ([]string, []string) {
+	var opts, rest, dropped []string
+	for i := 0; i < len(argv); i++ {
+		a := argv[i]
+		switch {
+		case a == "--":
+			for _, r := range argv[i+1:] {
+				if strings.TrimSpace(r) == "" || placeholderRe.MatchString(r) {
+					dropped = append(dropped, r)
+				} else {
+					rest = append(rest, r)
+				}
+			}
+			i = len(argv)
+		case strings.TrimSpace(a) == "" || placeholderRe.MatchString(a):
+			dropped = append(dropped, a)
+		case strings.HasPrefix(a, "-") && a != "-":
+			name := strings.TrimPrefix(strings.TrimPrefix(a, "-"), "-")
+			hasVal := false
+			if j := strings.IndexByte(name, '='); j >= 0 {
+				name, hasVal = name[:j], true
+			}
+			f := fs.Lookup(name)
+			if f == nil {
+				dropped = append(dropped, a)
+				continue
+			}
+			opts = append(opts, a)
+			if b, ok := f.Value.(interface{ IsBoolFlag() bool }); hasVal || (ok && b.IsBoolFlag()) {
+				continue
+			}
+			if i+1 < len(argv) {
+				i++
+				opts = append(opts, argv[i])
+			}
+		default:
+			rest = append(rest, a)
+		}
+	}
+	return append(append(opts, "--"), rest...), dropped
+}
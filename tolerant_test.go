@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"testing"
+)
+
+func TestTolerantArgs(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Bool("new-tab", false, "")
+	fs.Bool("tolerant", false, "")
+	fs.String("P", "", "")
+	for _, c := range []struct {
+		in, want, dropped []string
+	}{
+		{[]string{"https://a/"}, []string{"--", "https://a/"}, nil},
+		// A bool flag doesn't take the next argument, a valued
+		// one does.
+		{[]string{"-new-tab", "https://a/"}, []string{"-new-tab", "--", "https://a/"}, nil},
+		{[]string{"-P", "work", "https://a/"}, []string{"-P", "work", "--", "https://a/"}, nil},
+		{[]string{"-P=work", "https://a/"}, []string{"-P=work", "--", "https://a/"}, nil},
+		{[]string{"--new-tab=true", "https://a/"}, []string{"--new-tab=true", "--", "https://a/"}, nil},
+		{[]string{"-P"}, []string{"-P", "--"}, nil},
+		// Options after the URLs move ahead of them.
+		{[]string{"https://a/", "-tolerant", "-new-tab"}, []string{"-tolerant", "-new-tab", "--", "https://a/"}, nil},
+		// Placeholders, empty arguments, and unknown options go.
+		{[]string{"%u", "https://a/"}, []string{"--", "https://a/"}, []string{"%u"}},
+		{[]string{"https://a/", "%s", "%U", "%{charset}"}, []string{"--", "https://a/"}, []string{"%s", "%U", "%{charset}"}},
+		{[]string{"", " ", "https://a/"}, []string{"--", "https://a/"}, []string{"", " "}},
+		{[]string{"--no-remote", "-x=1", "https://a/"}, []string{"--", "https://a/"}, []string{"--no-remote", "-x=1"}},
+		// Things that only look like placeholders stay.
+		{[]string{"%", "%%", "%ab", "50%u"}, []string{"--", "%", "%%", "%ab", "50%u"}, nil},
+		// After --, nothing is an option, but placeholders still go.
+		{[]string{"https://a/", "--", "-new-tab", "%u"}, []string{"--", "https://a/", "-new-tab"}, []string{"%u"}},
+		{[]string{"-"}, []string{"--", "-"}, nil},
+	} {
+		got, dropped := tolerantArgs(fs, c.in)
+		if fmt.Sprintf("%q", got) != fmt.Sprintf("%q", c.want) || fmt.Sprintf("%q", dropped) != fmt.Sprintf("%q", c.dropped) {
+			t.Errorf("tolerantArgs(%q) = %q, %q, want %q, %q", c.in, got, dropped, c.want, c.dropped)
+		}
+	}
+}
+
+func TestWantsTolerant(t *testing.T) {
+	for _, c := range []struct {
+		in   []string
+		want bool
+	}{
+		{[]string{"-tolerant", "https://a/"}, true},
+		{[]string{"https://a/", "--tolerant=true"}, true},
+		{[]string{"-tolerant=false", "https://a/"}, false},
+		{[]string{"--", "-tolerant"}, false},
+		{[]string{"https://a/"}, false},
+	} {
+		if got := wantsTolerant(c.in); got != c.want {
+			t.Errorf("wantsTolerant(%q) = %v", c.in, got)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+// Tracing our X operations, for -trace.
+//
+// When something hangs, it's usually because we're waiting for a
+// property change that never comes. -trace logs every X request we
+// make that matters for the protocol (and every wait for an event),
+// with when it started relative to the start of the trace and how
+// long it took, so that you can see what we were waiting for and
+// what we'd seen before then.
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/BurntSushi/xgb/xproto"
+	"github.com/siebenmann/ffox-remote/cmdline"
+)
+
+// tracer is where trace output goes, or nil if we're not tracing.
+var tracer *log.Logger
+var traceStart time.Time
+
+// startTrace starts tracing to fname, which is appended to, or to
+// standard error if fname is "-".
+func startTrace(fname string) error {
+	var w io.Writer = os.Stderr
+	if fname != "-" {
+		f, err := os.OpenFile(fname, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+		if err != nil {
+			return err
+		}
+		w = f
+	}
+	tracer = log.New(w, "trace: ", 0)
+	traceStart = time.Now()
+	return nil
+}
+
+// trace logs an operation that started at start and has just
+// finished.
+func trace(start time.Time, format string, args ...interface{}) {
+	if tracer == nil {
+		return
+	}
+	ms := func(d time.Duration) float64 {
+		return float64(d) / float64(time.Millisecond)
+	}
+	tracer.Printf("%9.3fms %8.3fms %s", ms(start.Sub(traceStart)), ms(time.Since(start)), fmt.Sprintf(format, args...))
+}
+
+// traceValue formats a property value for the trace, shortening long
+// ones.
+func traceValue(v []byte) string {
+	if len(v) > 64 {
+		return strconv.Quote(string(v[:64])) + "..."
+	}
+	return strconv.Quote(string(v))
+}
+
+// decodedValue formats a property value for -vv, decoding command
+// lines.
+func decodedValue(prop string, v []byte) string {
+	if prop == cmdlProp {
+		if pwd, args, err := cmdline.Decode(v); err == nil {
+			return fmt.Sprintf("cwd %q args %q", pwd, args)
+		}
+	}
+	return strconv.Quote(string(v))
+}
+
+// hexWin formats a window ID for logging.
+func hexWin(win xproto.Window) string {
+	return fmt.Sprintf("0x%x", win)
+}
+
+// getProp is GetProperty with tracing, except that it reads
+// long properties in chunks (see readProp).
+func getProp(xc xConn, win xproto.Window, prop string) (*xproto.GetPropertyReply, error) {
+	start := time.Now()
+	var p *xproto.GetPropertyReply
+	a, err := xc.atom(prop)
+	if err == nil {
+		p, err = readProp(xc, win, a, false)
+	}
+	if err == nil && p.Format == 0 {
+		p, err = nil, fmt.Errorf("no such property '%s' on window %x", prop, win)
+	}
+	if tracer != nil {
+		if err != nil {
+			trace(start, "GetProperty 0x%x %s: %s", win, prop, err)
+		} else {
+			trace(start, "GetProperty 0x%x %s: %s", win, prop, traceValue(p.Value))
+		}
+	}
+	if err != nil {
+		protoLog("read property", "window", hexWin(win), "prop", prop, "err", err)
+	} else {
+		protoLog("read property", "window", hexWin(win), "prop", prop, "value", decodedValue(prop, p.Value))
+	}
+	return p, err
+}
+
+// changeProp sets the string property prop on win to val, with
+// tracing. If val is too large to fit in one X request, we set it in
+// pieces, replacing the property with the first and appending the
+// rest. Other clients can see the property partly set unless this is
+// done with the server grabbed, as submitCommand does.
+func changeProp(xc xConn, win xproto.Window, prop string, val []byte) error {
+	start := time.Now()
+	err := changePropChunks(xc, win, prop, val, xc.maxChange())
+	trace(start, "ChangeProperty 0x%x %s = %s: %v", win, prop, traceValue(val), err)
+	if err != nil {
+		protoLog("set property", "window", hexWin(win), "prop", prop, "value", decodedValue(prop, val), "err", err)
+	} else {
+		protoLog("set property", "window", hexWin(win), "prop", prop, "value", decodedValue(prop, val))
+	}
+	return err
+}
+
+// changePropChunks sets prop on win to val in chunks of at most max
+// bytes. Most values fit in one chunk. An empty val still sets the
+// property (to nothing).
+func changePropChunks(xc xConn, win xproto.Window, prop string, val []byte, max int) error {
+	a, err := xc.atom(prop)
+	if err != nil {
+		return err
+	}
+	stype, err := xc.atom("STRING")
+	if err != nil {
+		return err
+	}
+	mode := byte(xproto.PropModeReplace)
+	for {
+		n := len(val)
+		if n > max {
+			n = max
+		}
+		if err = xc.changeProperty(mode, win, a, stype, val[:n]); err != nil {
+			return err
+		}
+		mode = xproto.PropModeAppend
+		val = val[n:]
+		if len(val) == 0 {
+			return nil
+		}
+	}
+}
+
+// queryTree is QueryTree with tracing.
+func queryTree(xc xConn, win xproto.Window) (*xproto.QueryTreeReply, error) {
+	start := time.Now()
+	tree, err := xc.queryTree(win)()
+	if err != nil {
+		trace(start, "QueryTree 0x%x: %s", win, err)
+	} else {
+		trace(start, "QueryTree 0x%x: %d children", win, len(tree.Children))
+	}
+	return tree, err
+}
+
+// atomName returns the name of an atom for the trace.
+func atomName(xc xConn, a xproto.Atom) string {
+	n, err := xc.atomName(a)
+	if err != nil {
+		return fmt.Sprintf("atom %d", a)
+	}
+	return n
+}
+
+// propState describes the state in a PropertyNotify event.
+func propState(state byte) string {
+	if state == xproto.PropertyDelete {
+		return "deleted"
+	}
+	return "new value"
+}
@@ -0,0 +1,91 @@
+package main
+
+// Picking a transport, and what transports can do.
+//
+// A transport (see the transport interface in main.go) is a way of
+// getting a running Firefox to run command lines for us. Each
+// platform has a table of the transports it has, transportMakers,
+// and a default one; on Unix these are X (xremote.go) and D-Bus
+// (dbusremote.go), and on Windows there's only window messages
+// (windows.go). -transport picks one by name. Everything else only
+// deals with the transport interface, so adding a transport is a
+// matter of implementing it and putting it in the table.
+//
+// The basic transport interface is the minimum: find Firefox, send
+// it command lines, and say what it found. Anything more (finding
+// every matching instance, dumping properties, holding the lock,
+// and so on) is an optional interface that the transport may also
+// implement, and these are its capabilities. We check for them
+// where they're used; capabilities() lists them, for -version.
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// A transportMaker sets up a transport with the options o.
+type transportMaker func(o *options) (transport, error)
+
+// transportNames returns the names of the transports we have, in
+// order.
+func transportNames() []string {
+	var names []string
+	for n := range transportMakers {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// newTransport sets up the transport that the options pick, which
+// is the default one unless -transport (or -dbus) says otherwise.
+func newTransport(o *options) (transport, error) {
+	name := o.transport
+	if name == "" {
+		name = defaultTransport
+	}
+	mk, ok := transportMakers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown transport %q (we have %s)", name, strings.Join(transportNames(), ", "))
+	}
+	return mk(o)
+}
+
+// capabilityChecks are the optional things that a transport can do,
+// named by the option or subcommand that uses them.
+var capabilityChecks = []struct {
+	name string
+	has  func(t transport) bool
+}{
+	{"protocol versions", func(t transport) bool { _, ok := t.(protoVersioner); return ok }},
+	{"-spread", func(t transport) bool { _, ok := t.(spreader); return ok }},
+	{"-find-format", func(t transport) bool { _, ok := t.(detailer); return ok }},
+	{"-dry-run", func(t transport) bool { _, ok := t.(encoder); return ok }},
+	{"-wait-for-firefox", func(t transport) bool { _, ok := t.(firefoxWaiter); return ok }},
+	{"-dump", func(t transport) bool { _, ok := t.(dumper); return ok }},
+	{"-set-prop", func(t transport) bool { _, ok := t.(propSetter); return ok }},
+	{"-hold-lock", func(t transport) bool { _, ok := t.(lockHolder); return ok }},
+	{"unlock", func(t transport) bool { _, ok := t.(unlocker); return ok }},
+	{"quit", func(t transport) bool { _, ok := t.(quitter); return ok }},
+	{"-kiosk", func(t transport) bool { _, ok := t.(kioskChecker); return ok }},
+	{"screenshot", func(t transport) bool { _, ok := t.(profileLocator); return ok }},
+	{"-serve", func(t transport) bool { _, ok := t.(server); return ok }},
+	{"-watch", func(t transport) bool { _, ok := t.(watcher); return ok }},
+	{"-bridge", func(t transport) bool { _, ok := t.(bridger); return ok }},
+	{"monitor", func(t transport) bool { _, ok := t.(protoMonitor); return ok }},
+	{"stress", func(t transport) bool { _, ok := t.(stresser); return ok }},
+	{"profiles states", func(t transport) bool { _, ok := t.(profileReporter); return ok }},
+}
+
+// capabilities returns the names of the optional things that t can
+// do.
+func capabilities(t transport) []string {
+	var caps []string
+	for _, c := range capabilityChecks {
+		if c.has(t) {
+			caps = append(caps, c.name)
+		}
+	}
+	return caps
+}
@@ -0,0 +1,165 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+// A terminal menu for picking which Firefox window to use, for -tui.
+//
+// If several Firefox instances match what you asked for, normally we
+// just use the first one. When you're at a terminal, it's nicer to
+// be asked. The menu lists the matching windows with their profile,
+// program, and title; you move with the arrow keys (or j and k) and
+// pick with Enter, or pick directly with the number. q, Escape, or
+// ^C cancels. We draw on and read from the terminal itself, not
+// standard input and output, and put the terminal into raw mode with
+// stty, which saves us from needing a terminal library for one menu.
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/BurntSushi/xgb/xproto"
+)
+
+// errNoChoice is returned by pickInteractively if you cancel.
+var errNoChoice = errors.New("no Firefox window chosen")
+
+// stdinIsTerminal reports whether standard input is a terminal.
+func stdinIsTerminal() bool {
+	fi, err := os.Stdin.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// wantMenu reports whether we should ask which of cands to use.
+// Automatically we only ask if they're from more than one Firefox
+// instance, since picking between windows of one Firefox doesn't
+// usually matter.
+func wantMenu(xc xConn, mode int, cands []foxCandidate) bool {
+	if len(cands) < 2 || mode == tuiNever {
+		return false
+	}
+	if mode == tuiAlways {
+		return true
+	}
+	if !stdinIsTerminal() {
+		return false
+	}
+	seen := make(map[foxProps]bool)
+	for _, fp := range fetchFoxProps(xc, candWindows(cands)) {
+		fp.ver = propVal{}
+		seen[fp] = true
+	}
+	return len(seen) > 1
+}
+
+// windowTitle returns the title of a window, or "". We prefer the
+// EWMH _NET_WM_NAME, which is UTF-8, to the ICCCM WM_NAME.
+func windowTitle(xc xConn, win xproto.Window) string {
+	if t, err := textProp(xc, win, "_NET_WM_NAME"); err == nil && t != "" {
+		return t
+	}
+	t, _ := textProp(xc, win, "WM_NAME")
+	return t
+}
+
+// menuLines returns the menu's description of each candidate.
+func menuLines(xc xConn, cands []foxCandidate) []string {
+	var lines []string
+	for i, fp := range fetchFoxProps(xc, candWindows(cands)) {
+		title := strings.Map(func(r rune) rune {
+			if r < ' ' || r == 0x7f {
+				return ' '
+			}
+			return r
+		}, windowTitle(xc, cands[i].win))
+		if r := []rune(title); len(r) > 50 {
+			title = string(r[:47]) + "..."
+		}
+		lines = append(lines, fmt.Sprintf("0x%-8x %-12s %-10s %s", cands[i].win, fp.prof.val, fp.prog.val, title))
+	}
+	return lines
+}
+
+// candWindows returns the windows of cands.
+func candWindows(cands []foxCandidate) []xproto.Window {
+	var wins []xproto.Window
+	for _, c := range cands {
+		wins = append(wins, c.win)
+	}
+	return wins
+}
+
+// stty runs stty on the terminal with args, returning its output.
+func stty(tty *os.File, args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = tty
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+// pickInteractively shows the menu of cands on the terminal and
+// returns the one that's picked.
+func pickInteractively(xc xConn, cands []foxCandidate) (foxCandidate, error) {
+	lines := menuLines(xc, cands)
+	in, out, err := openTTY()
+	if err != nil {
+		return foxCandidate{}, fmt.Errorf("can't show the menu: %s", err)
+	}
+	defer in.Close()
+	saved, err := stty(in, "-g")
+	if err != nil {
+		return foxCandidate{}, fmt.Errorf("can't set up the terminal: %s", err)
+	}
+	if _, err = stty(in, "raw", "-echo"); err != nil {
+		return foxCandidate{}, fmt.Errorf("can't set up the terminal: %s", err)
+	}
+	defer stty(in, saved)
+
+	cur := 0
+	draw := func(redraw bool) {
+		if redraw {
+			fmt.Fprintf(out, "\x1b[%dA", len(lines)+1)
+		}
+		fmt.Fprintf(out, "\r\x1b[JWhich Firefox? (arrows or j/k, Enter to pick, q to cancel)\r\n")
+		for i, l := range lines {
+			mark := " "
+			if i == cur {
+				mark = ">"
+			}
+			num := "  "
+			if i < 9 {
+				num = fmt.Sprintf("%d.", i+1)
+			}
+			fmt.Fprintf(out, "%s %s %s\r\n", mark, num, l)
+		}
+	}
+	draw(false)
+	buf := make([]byte, 8)
+	for {
+		n, err := in.Read(buf)
+		if err != nil {
+			return foxCandidate{}, err
+		}
+		k := string(buf[:n])
+		switch {
+		case k == "\r" || k == "\n":
+			return cands[cur], nil
+		case k == "q" || k == "\x1b" || k == "\x03" || k == "\x04":
+			return foxCandidate{}, errNoChoice
+		case k == "\x1b[A" || k == "\x1bOA" || k == "k":
+			if cur > 0 {
+				cur--
+			}
+		case k == "\x1b[B" || k == "\x1bOB" || k == "j":
+			if cur < len(lines)-1 {
+				cur++
+			}
+		case len(k) == 1 && k[0] >= '1' && k[0] <= '9' && int(k[0]-'1') < len(cands):
+			return cands[k[0]-'1'], nil
+		}
+		draw(true)
+	}
+}
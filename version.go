@@ -0,0 +1,88 @@
+package main
+
+// Reporting versions, for -version.
+//
+// When something doesn't work, the first questions are what version
+// of ffox-remote you have, what protocol versions it speaks, and
+// what protocol version your Firefox is advertising, so -version
+// answers all three at once for bug reports.
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+// version is our version. Release builds can set it with
+// '-ldflags -X main.version=...'; otherwise we use what the Go
+// toolchain recorded when we were built.
+var version = ""
+
+// toolVersion returns our version and, if we know it, the VCS
+// revision we were built from.
+func toolVersion() string {
+	v, rev, dirty := version, "", ""
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		if v == "" {
+			v = bi.Main.Version
+		}
+		for _, s := range bi.Settings {
+			switch {
+			case s.Key == "vcs.revision" && len(s.Value) >= 12:
+				rev = s.Value[:12]
+			case s.Key == "vcs.modified" && s.Value == "true":
+				dirty = "+dirty"
+			}
+		}
+	}
+	if v == "" {
+		v = "(devel)"
+	}
+	// Pseudo-versions already have the revision in them.
+	if rev != "" && !strings.Contains(v, rev) {
+		v += " (" + rev + dirty + ")"
+	}
+	return v
+}
+
+// A protoVersioner is a transport that can say what protocol version
+// the Firefox it found advertises.
+type protoVersioner interface {
+	protocolVersion() string
+}
+
+// showVersion prints our version, the protocol versions we speak,
+// what the transport can do, and the protocol version of the Firefox
+// that o selects if we can find one. Not finding Firefox isn't an
+// error here.
+func showVersion(ctx context.Context, o *options) {
+	fmt.Printf("ffox-remote %s, %s, %s/%s\n", toolVersion(), runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	// The transport may learn about more protocol versions, from
+	// -protocol-version.
+	t, err := newTransport(o)
+	fmt.Printf("supported protocols: %s\n", supportedProtocols())
+	if err == nil {
+		name := o.transport
+		if name == "" {
+			name = defaultTransport
+		}
+		fmt.Printf("transport: %s (of %s), which can do: %s\n", name, strings.Join(transportNames(), ", "), strings.Join(capabilities(t), ", "))
+		err = t.find(ctx)
+	}
+	if err != nil {
+		fmt.Printf("firefox: not found: %s\n", err)
+		return
+	}
+	name := "found"
+	if tg, ok := t.(targeter); ok {
+		name = tg.target()
+	}
+	pv, ok := t.(protoVersioner)
+	if !ok {
+		fmt.Printf("firefox: %s (no protocol version)\n", name)
+		return
+	}
+	fmt.Printf("firefox: %s, protocol version %s\n", name, pv.protocolVersion())
+}
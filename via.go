@@ -0,0 +1,68 @@
+package main
+
+// Running ffox-remote on another machine over SSH.
+//
+// If your browser is on your desktop and you're logged in to some
+// other machine, it's often convenient to push URLs from there to
+// your desktop Firefox. Rather than trying to tunnel X to the right
+// display, we just run ffox-remote on the desktop machine over SSH
+// with the same options and arguments, and relay its output and
+// exit status back. The remote ffox-remote uses the remote machine's
+// $DISPLAY if SSH gives it one and :0 otherwise.
+
+import (
+	"flag"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// shellQuote quotes s for the Bourne shell that SSH will run the
+// remote command with.
+func shellQuote(s string) string {
+	if s != "" && strings.IndexFunc(s, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' ||
+			r >= '0' && r <= '9' || strings.ContainsRune("-_./:=@,+%", r))
+	}) < 0 {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// viaCommand builds the remote shell command line. We pass along the
+// subcommand and all of the flags that were explicitly set, except
+// the ones that are about running things remotely in the first place.
+func viaCommand(rcmd, sub string) string {
+	cmd := []string{"DISPLAY=${DISPLAY:-:0}", shellQuote(rcmd)}
+	if sub != "" {
+		cmd = append(cmd, sub)
+	}
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "via" || f.Name == "via-cmd" {
+			return
+		}
+		cmd = append(cmd, shellQuote("-"+f.Name+"="+f.Value.String()))
+	})
+	cmd = append(cmd, "--")
+	for _, a := range flag.Args() {
+		cmd = append(cmd, shellQuote(a))
+	}
+	return strings.Join(cmd, " ")
+}
+
+// runVia runs ffox-remote on dest over SSH and exits with its exit
+// status.
+func runVia(dest, rcmd, sub string) {
+	cmd := exec.Command("ssh", dest, viaCommand(rcmd, sub))
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+	if ee, ok := err.(*exec.ExitError); ok {
+		exit(ee.ExitCode())
+	}
+	if err != nil {
+		fatal("ssh "+dest, "err", err)
+	}
+	exit(0)
+}
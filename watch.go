@@ -0,0 +1,191 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+// Watching for Firefox windows to come and go, for -watch.
+//
+// We listen for windows being mapped, unmapped, reparented, and
+// destroyed on the root window, and every time something happens we
+// rescan for matching Firefox windows and report the differences.
+// Firefox creates and maps a flurry of windows when it starts, so
+// we wait a bit after an event before rescanning to let things
+// settle down.
+//
+// If we have a command line to deliver, we send it to the first
+// matching Firefox window that appears (only once). We use a separate
+// X connection for this, because sending a command runs its own X
+// event loop and that doesn't mix with the one we're watching with.
+//
+// -wait-for-firefox uses the same idea to wait for Firefox to start
+// before we send it a command line, except that it gives up after a
+// while.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/BurntSushi/xgb"
+	"github.com/BurntSushi/xgb/xproto"
+	"github.com/BurntSushi/xgbutil"
+	"github.com/BurntSushi/xgbutil/xevent"
+	"github.com/BurntSushi/xgbutil/xwindow"
+)
+
+// How long we wait for things to settle down after a window event.
+const watchSettle = 250 * time.Millisecond
+
+// watch reports on matching Firefox windows appearing and
+// disappearing, forever. If cmd is set, we run it (with $FFOX_EVENT
+// and $FFOX_WINDOW set) for each of these. If deliver is set, we send
+// the command line args to the first new Firefox window we see.
+func (t *xTransport) watch(cmd, cwd string, args []string, deliver bool) error {
+	if t.xu == nil {
+		return fmt.Errorf("can't watch with -scan-displays")
+	}
+	xu := t.xu
+	root := xwindow.New(xu, xu.RootWin())
+	if e := root.Listen(xproto.EventMaskSubstructureNotify); e != nil {
+		return e
+	}
+
+	scan := func() map[xproto.Window]string {
+		m := make(map[xproto.Window]string)
+		for _, c := range matchingFirefoxes(liveX{xu}, t.o) {
+			m[c.win] = c.ver
+		}
+		return m
+	}
+	report := func(event string, win xproto.Window) {
+		fmt.Printf("%s %s 0x%x\n", time.Now().Format("15:04:05"), event, win)
+		if cmd == "" {
+			return
+		}
+		c := exec.Command("/bin/sh", "-c", cmd)
+		c.Env = append(os.Environ(), "FFOX_EVENT="+event, fmt.Sprintf("FFOX_WINDOW=0x%x", win))
+		c.Stdout, c.Stderr = os.Stdout, os.Stderr
+		if e := c.Run(); e != nil {
+			slog.Warn("watch command", "err", e)
+		}
+	}
+
+	known := scan()
+	for win := range known {
+		report("present", win)
+	}
+
+	dirty := false
+	setDirty := func() { dirty = true }
+	xevent.MapNotifyFun(func(*xgbutil.XUtil, xevent.MapNotifyEvent) { setDirty() }).Connect(xu, xu.RootWin())
+	xevent.UnmapNotifyFun(func(*xgbutil.XUtil, xevent.UnmapNotifyEvent) { setDirty() }).Connect(xu, xu.RootWin())
+	xevent.ReparentNotifyFun(func(*xgbutil.XUtil, xevent.ReparentNotifyEvent) { setDirty() }).Connect(xu, xu.RootWin())
+	xevent.DestroyNotifyFun(func(*xgbutil.XUtil, xevent.DestroyNotifyEvent) { setDirty() }).Connect(xu, xu.RootWin())
+
+	bchan, achan, qchan := xevent.MainPing(xu)
+	for {
+		select {
+		case <-bchan:
+		case <-qchan:
+			return nil
+		case <-achan:
+			if !dirty {
+				continue
+			}
+			time.Sleep(watchSettle)
+			dirty = false
+			now := scan()
+			for win := range known {
+				if _, ok := now[win]; !ok {
+					report("disappeared", win)
+				}
+			}
+			for win, ver := range now {
+				if _, ok := known[win]; ok {
+					continue
+				}
+				report("appeared", win)
+				if deliver {
+					deliver = false
+					resp, err := t.deliver(win, ver, cwd, args)
+					if err != nil {
+						slog.Warn("", "err", err)
+					} else if t.o.verbose {
+						fmt.Printf("response: %s\n", resp)
+					}
+				}
+			}
+			known = now
+		}
+	}
+}
+
+// deliver sends a command line to win over a new X connection and
+// returns the response. -watch runs until it's killed, so there's
+// no context to give up with.
+func (t *xTransport) deliver(win xproto.Window, ver, cwd string, args []string) (response, error) {
+	xu, err := xconnect(t.displayName())
+	if err != nil {
+		return response{}, err
+	}
+	defer xu.Conn().Close()
+	dt := &xTransport{o: t.o, xu: xu, win: win, ver: ver}
+	if win == t.win {
+		dt.ident = t.ident
+	}
+	return dt.send(context.Background(), cwd, args)
+}
+
+// waitForFirefox waits up to timeout for a matching Firefox window
+// to appear, for -wait-for-firefox. We rescan when windows change
+// on the root window (after letting things settle down), and also
+// every second, since Firefox may set its properties after it maps
+// its windows.
+func (t *xTransport) waitForFirefox(ctx context.Context, timeout time.Duration) error {
+	if t.xu == nil {
+		return findRetrying(ctx, t, int(timeout/time.Second), time.Second, t.o.verbose)
+	}
+	xu := t.xu
+	root := xwindow.New(xu, xu.RootWin())
+	if e := root.Listen(xproto.EventMaskSubstructureNotify); e != nil {
+		return e
+	}
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+	events := xevents(xu)
+	for {
+		if t.find(ctx) == nil {
+			return nil
+		}
+		select {
+		case _, ok := <-events:
+			if !ok {
+				return errors.New("X connection closed")
+			}
+			time.Sleep(watchSettle)
+			drainEvents(events)
+		case <-tick.C:
+		case <-deadline.C:
+			return fmt.Errorf("no Firefox window appeared within %v", timeout)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// drainEvents throws away all of the events currently waiting.
+func drainEvents(events chan xgb.Event) {
+	for {
+		select {
+		case <-events:
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,208 @@
+//go:build windows
+// +build windows
+
+package main
+
+// The Windows transport.
+//
+// On Windows, Firefox doesn't use X properties (obviously). Instead
+// each running Firefox creates a hidden 'remote window' with a
+// window class name built from its program name and profile,
+// 'Mozilla_<program>_<profile>_RemoteWindow', and another Firefox
+// passes it its command line by sending that window a WM_COPYDATA
+// message. The message data is the full command line and the
+// working directory as NUL-terminated UTF-16 strings, and dwData is
+// the format version (2 for this format; 0 and 1 were older narrow
+// string versions). There's no lock and no response; the message
+// is synchronous, so once it's been delivered we're done.
+//
+// The -U, -force, -legacy, -pref, and -protocol-version options
+// don't mean anything here and are ignored.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	user32                  = syscall.NewLazyDLL("user32.dll")
+	procFindWindowW         = user32.NewProc("FindWindowW")
+	procEnumWindows         = user32.NewProc("EnumWindows")
+	procGetClassNameW       = user32.NewProc("GetClassNameW")
+	procSendMessageTimeoutW = user32.NewProc("SendMessageTimeoutW")
+)
+
+const (
+	wmCopyData      = 0x004A
+	smtoAbortIfHung = 0x0002
+	// How long we'll wait for Firefox to take the message, in
+	// milliseconds.
+	sendTimeout = 10 * 1000
+
+	// The WM_COPYDATA format version for a UTF-16 command line
+	// plus working directory.
+	remoteMsgUTF16 = 2
+)
+
+// copyDataStruct is the Win32 COPYDATASTRUCT.
+type copyDataStruct struct {
+	dwData uintptr
+	cbData uint32
+	lpData uintptr
+}
+
+// remoteClassName is the window class name of the remote window for
+// a particular program and profile.
+func remoteClassName(program, profile string) string {
+	if profile == "" {
+		return "Mozilla_" + program + "_RemoteWindow"
+	}
+	return "Mozilla_" + program + "_" + profile + "_RemoteWindow"
+}
+
+// winTransport talks to Firefox through window messages.
+type winTransport struct {
+	o    *options
+	hwnd uintptr
+	name string
+}
+
+// transportMakers are the transports we have on Windows; see
+// transports.go.
+var transportMakers = map[string]transportMaker{
+	"windows": newWinTransport,
+}
+
+// defaultTransport is the transport we use unless told otherwise.
+const defaultTransport = "windows"
+
+func newWinTransport(o *options) (transport, error) {
+	return &winTransport{o: o}, nil
+}
+
+// list finds all windows with a remote window class name. We can't
+// look for them directly, so we have to walk all top level windows.
+func (t *winTransport) list() {
+	cb := syscall.NewCallback(func(hwnd uintptr, _ uintptr) uintptr {
+		buf := make([]uint16, 256)
+		n, _, _ := procGetClassNameW.Call(hwnd, uintptr(unsafe.Pointer(&buf[0])), uintptr(len(buf)))
+		cls := syscall.UTF16ToString(buf[:n])
+		if strings.HasPrefix(cls, "Mozilla_") && strings.HasSuffix(cls, "_RemoteWindow") {
+			fmt.Printf("0x%x %s\n", hwnd, cls)
+		}
+		return 1
+	})
+	_, _, _ = procEnumWindows.Call(cb, 0)
+}
+
+// find looks for the remote window of each program in turn. Unlike
+// X, a blank profile doesn't match anything; it matches a Firefox
+// that was started without a profile name.
+func (t *winTransport) find(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	for _, p := range t.o.programs {
+		name := remoteClassName(p, t.o.profile)
+		cls, e := syscall.UTF16PtrFromString(name)
+		if e != nil {
+			return e
+		}
+		hwnd, _, _ := procFindWindowW.Call(uintptr(unsafe.Pointer(cls)), 0)
+		if hwnd != 0 {
+			t.hwnd, t.name = hwnd, name
+			return nil
+		}
+	}
+	return errors.New("can't find a running Firefox window")
+}
+
+func (t *winTransport) describe() {
+	fmt.Printf("firefox window: 0x%x\n", t.hwnd)
+	fmt.Printf("firefox window class: %s\n", t.name)
+}
+
+// details reports what we know about the window we found, for
+// -find-format.
+func (t *winTransport) details() []foundField {
+	return []foundField{{"window", fmt.Sprintf("0x%x", t.hwnd)}, {"class", t.name}}
+}
+
+// target describes the window we found, for records.
+func (t *winTransport) target() string {
+	return fmt.Sprintf("window 0x%x", t.hwnd)
+}
+
+// send delivers the command line. It has to be turned back into a
+// single Windows command line string with Windows quoting rules.
+// SendMessageTimeout has its own timeout, so all we can do with ctx
+// is not start if it's already done.
+func (t *winTransport) send(ctx context.Context, cwd string, args []string) (response, error) {
+	if err := ctx.Err(); err != nil {
+		return response{}, err
+	}
+	var qargs []string
+	for _, a := range args {
+		qargs = append(qargs, syscall.EscapeArg(a))
+	}
+	cmdl, e := syscall.UTF16FromString(strings.Join(qargs, " "))
+	if e != nil {
+		return response{}, e
+	}
+	wd, e := syscall.UTF16FromString(cwd)
+	if e != nil {
+		return response{}, e
+	}
+	data := append(cmdl, wd...)
+	cds := copyDataStruct{
+		dwData: remoteMsgUTF16,
+		cbData: uint32(len(data) * 2),
+		lpData: uintptr(unsafe.Pointer(&data[0])),
+	}
+	var result uintptr
+	r, _, err := procSendMessageTimeoutW.Call(t.hwnd, wmCopyData, 0,
+		uintptr(unsafe.Pointer(&cds)), smtoAbortIfHung, sendTimeout,
+		uintptr(unsafe.Pointer(&result)))
+	if r == 0 {
+		return response{}, fmt.Errorf("sending to Firefox: %s", err)
+	}
+	return response{}, nil
+}
+
+// altTransport is the transport to try if the normal one can't find
+// Firefox. On Windows there isn't one.
+func altTransport(o *options, t transport) transport {
+	return nil
+}
+
+// portalOpen is the desktop portal fallback, which doesn't exist on
+// Windows.
+func portalOpen(urls []string) error {
+	return errors.New("there is no desktop portal on Windows")
+}
+
+// readSelection is -selection and -clipboard, which read the X
+// selection.
+func readSelection(o *options, which string) (string, error) {
+	return "", errors.New("-selection and -clipboard need X")
+}
+
+// runAppService is -app-service, which needs D-Bus.
+func runAppService(t transport, o *options, recfile, cwd, appid string) error {
+	return errors.New("-app-service needs D-Bus, which Windows doesn't have")
+}
+
+// runSocketService is -socket-activated, which needs systemd.
+func runSocketService(t transport, o *options, recfile, cwd string) error {
+	return errors.New("-socket-activated needs systemd socket activation, which Windows doesn't have")
+}
+
+// supportedProtocols describes what we speak to Windows Firefox, for
+// -version.
+func supportedProtocols() string {
+	return "WM_COPYDATA command lines (format 2)"
+}
@@ -0,0 +1,81 @@
+package main
+
+// Bridging from WSL to Windows Firefox.
+//
+// Under WSL (the Windows Subsystem for Linux) your browser is usually
+// a Windows Firefox, which we can't reach through X. However, WSL
+// lets Linux programs run Windows ones, and running a second
+// firefox.exe hands its command line to the running Windows Firefox
+// (through the Windows remote control mechanism in windows.go). So
+// if we're in WSL and there's no X Firefox to talk to, we do that.
+// It's slower than talking to Firefox ourselves, but it works.
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Where we look for firefox.exe if it's not on $PATH.
+var wslFirefoxPaths = []string{
+	"/mnt/c/Program Files/Mozilla Firefox/firefox.exe",
+	"/mnt/c/Program Files (x86)/Mozilla Firefox/firefox.exe",
+}
+
+// inWSL returns true if we seem to be running under WSL.
+func inWSL() bool {
+	if os.Getenv("WSL_DISTRO_NAME") != "" {
+		return true
+	}
+	if _, e := os.Stat("/proc/sys/fs/binfmt_misc/WSLInterop"); e == nil {
+		return true
+	}
+	v, e := ioutil.ReadFile("/proc/version")
+	return e == nil && strings.Contains(strings.ToLower(string(v)), "microsoft")
+}
+
+// wslFirefox finds the Windows firefox.exe.
+func wslFirefox() (string, error) {
+	if p, e := exec.LookPath("firefox.exe"); e == nil {
+		return p, nil
+	}
+	for _, p := range wslFirefoxPaths {
+		if _, e := os.Stat(p); e == nil {
+			return p, nil
+		}
+	}
+	return "", errors.New("can't find a Windows firefox.exe")
+}
+
+// wslPath converts arguments that are Linux files into Windows paths,
+// so that Windows Firefox can open them. Anything else is passed
+// through untouched.
+func wslPath(arg string) string {
+	if _, e := os.Stat(arg); e != nil {
+		return arg
+	}
+	out, e := exec.Command("wslpath", "-w", arg).Output()
+	if e != nil {
+		return arg
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// wslOpen runs Windows Firefox on our command line (without the
+// program name).
+func wslOpen(args []string) error {
+	ff, e := wslFirefox()
+	if e != nil {
+		return e
+	}
+	var wargs []string
+	for _, a := range args {
+		wargs = append(wargs, wslPath(a))
+	}
+	cmd := exec.Command(ff, wargs...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
@@ -0,0 +1,153 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+// What the remote control protocol needs from the X server.
+//
+// Finding Firefox, locking it, and sending it command lines only take
+// a handful of X operations: interning atoms, walking the window
+// tree, reading, setting, and deleting properties, grabbing the
+// server, and waiting for PropertyNotify events. The code that does
+// these things talks to an xConn instead of straight to xgb, so that
+// it can be run against something other than a real X server. A
+// liveX is the real thing; a fakeX (see fakex_test.go) is an X
+// server in memory, for testing the protocol code without one.
+//
+// The requests that we send in bulk (QueryTree and GetProperty)
+// return a function to get the reply, so that we can send a lot of
+// them before waiting for any replies, just as we would with xgb's
+// cookies.
+
+import (
+	"github.com/BurntSushi/xgb"
+	"github.com/BurntSushi/xgb/xproto"
+	"github.com/BurntSushi/xgbutil"
+	"github.com/BurntSushi/xgbutil/xprop"
+	"github.com/BurntSushi/xgbutil/xwindow"
+)
+
+// An xConn is a connection to an X server, as far as the protocol
+// code is concerned.
+type xConn interface {
+	// atom returns the atom for name, interning it if necessary,
+	// and atomName is the reverse.
+	atom(name string) (xproto.Atom, error)
+	atomName(a xproto.Atom) (string, error)
+	// internAtoms interns all of names at once, so that atom
+	// doesn't have to ask the server about them later.
+	internAtoms(names []string)
+
+	// root returns the root window.
+	root() xproto.Window
+	// queryTree asks for the children of win.
+	queryTree(win xproto.Window) func() (*xproto.QueryTreeReply, error)
+	// getProperty asks for length 32-bit units of property a
+	// on win, starting at offset (also in 32-bit units),
+	// deleting it afterward if del is set and we've read all of
+	// it. A property that isn't set has a Format of 0.
+	getProperty(win xproto.Window, a xproto.Atom, del bool, offset, length uint32) func() (*xproto.GetPropertyReply, error)
+	// changeProperty replaces or appends to (depending on mode)
+	// property a on win with data, as 8-bit values of type typ.
+	changeProperty(mode byte, win xproto.Window, a, typ xproto.Atom, data []byte) error
+	// deleteProperty deletes property a on win, without waiting
+	// to hear if that worked.
+	deleteProperty(win xproto.Window, a xproto.Atom)
+	// maxChange is the most data that one changeProperty can set.
+	maxChange() int
+
+	// listen asks for PropertyNotify and DestroyNotify events for
+	// win.
+	listen(win xproto.Window) error
+	// events returns the channel of events for this connection;
+	// see xevents.
+	events() chan xgb.Event
+
+	// grab and ungrab grab and release the server, and sync waits
+	// for the server to have handled everything we've sent.
+	grab()
+	ungrab()
+	sync()
+}
+
+// liveX is a real X server connection.
+type liveX struct {
+	xu *xgbutil.XUtil
+}
+
+func (x liveX) atom(name string) (xproto.Atom, error) {
+	return xprop.Atm(x.xu, name)
+}
+
+func (x liveX) atomName(a xproto.Atom) (string, error) {
+	return xprop.AtomName(x.xu, a)
+}
+
+// internAtoms sends all of the InternAtom requests at once and then
+// collects the replies, putting them in xgbutil's atom cache so that
+// xprop doesn't have to look them up again.
+func (x liveX) internAtoms(names []string) {
+	xu := x.xu
+	var cookies []xproto.InternAtomCookie
+	for _, n := range names {
+		cookies = append(cookies, xproto.InternAtom(xu.Conn(), false, uint16(len(n)), n))
+	}
+	xu.AtomsLck.Lock()
+	xu.AtomNamesLck.Lock()
+	for i, c := range cookies {
+		r, err := c.Reply()
+		if err != nil || r.Atom == 0 {
+			continue
+		}
+		xu.Atoms[names[i]] = r.Atom
+		xu.AtomNames[r.Atom] = names[i]
+	}
+	xu.AtomNamesLck.Unlock()
+	xu.AtomsLck.Unlock()
+}
+
+func (x liveX) root() xproto.Window {
+	return x.xu.RootWin()
+}
+
+func (x liveX) queryTree(win xproto.Window) func() (*xproto.QueryTreeReply, error) {
+	return xproto.QueryTree(x.xu.Conn(), win).Reply
+}
+
+func (x liveX) getProperty(win xproto.Window, a xproto.Atom, del bool, offset, length uint32) func() (*xproto.GetPropertyReply, error) {
+	return xproto.GetProperty(x.xu.Conn(), del, win, a, xproto.GetPropertyTypeAny, offset, length).Reply
+}
+
+func (x liveX) changeProperty(mode byte, win xproto.Window, a, typ xproto.Atom, data []byte) error {
+	return xproto.ChangePropertyChecked(x.xu.Conn(), mode, win, a, typ, 8, uint32(len(data)), data).Check()
+}
+
+func (x liveX) deleteProperty(win xproto.Window, a xproto.Atom) {
+	_ = xproto.DeleteProperty(x.xu.Conn(), win, a)
+}
+
+// maxChange is the maximum request length less the 24 byte
+// ChangeProperty request header.
+func (x liveX) maxChange() int {
+	return int(xproto.Setup(x.xu.Conn()).MaximumRequestLength)*4 - 24
+}
+
+func (x liveX) listen(win xproto.Window) error {
+	return xwindow.New(x.xu, win).Listen(xproto.EventMaskPropertyChange, xproto.EventMaskStructureNotify)
+}
+
+func (x liveX) events() chan xgb.Event {
+	return xevents(x.xu)
+}
+
+func (x liveX) grab() {
+	x.xu.Grab()
+}
+
+func (x liveX) ungrab() {
+	x.xu.Ungrab()
+}
+
+func (x liveX) sync() {
+	x.xu.Sync()
+}
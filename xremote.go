@@ -0,0 +1,1508 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+// The X property transport, which is how we normally talk to Firefox
+// on Unix.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/xgb"
+	"github.com/BurntSushi/xgb/xproto"
+	"github.com/BurntSushi/xgbutil"
+	"github.com/BurntSushi/xgbutil/ewmh"
+	"github.com/BurntSushi/xgbutil/icccm"
+	"github.com/BurntSushi/xgbutil/xwindow"
+	"github.com/siebenmann/ffox-remote/cmdline"
+)
+
+// The X property names that the Firefox remote control protocol uses.
+//
+// These are vars instead of consts because they can have a different
+// prefix than _MOZILLA, either from -pref or from the instance in the
+// configuration file (see config.go). This lets you run several
+// Firefoxes that can only be remote controlled separately.
+var (
+	lockProp = "_MOZILLA_LOCK"
+	cmdlProp = "_MOZILLA_COMMANDLINE"
+	cmdProp  = "_MOZILLA_COMMAND" // legacy protocol only
+	respProp = "_MOZILLA_RESPONSE"
+	versProp = "_MOZILLA_VERSION"
+	// Mozilla user, profile (usually 'default'), and
+	// program name (usually 'firefox')
+	userProp = "_MOZILLA_USER"
+	profProp = "_MOZILLA_PROFILE"
+	progProp = "_MOZILLA_PROGRAM"
+)
+
+// propNames returns pointers to all of the property name variables,
+// for rewriting them.
+func propNames() []*string {
+	return []*string{&lockProp, &cmdlProp, &cmdProp, &respProp, &versProp, &userProp, &profProp, &progProp}
+}
+
+// stockProps is the standard names of all of the properties, in
+// propNames order, so that we can switch back and forth between
+// prefixes.
+var stockProps = func() []string {
+	var n []string
+	for _, p := range propNames() {
+		n = append(n, *p)
+	}
+	return n
+}()
+
+const (
+	// Current value for versProp. This is a *protocol* version, not
+	// a Firefox version.
+	firefoxVersion = "5.1"
+)
+
+// How compatible we think a particular _MOZILLA_VERSION is with what
+// we speak. These are in order of increasing preference, so that we
+// can pick the best window if several match.
+const (
+	protoBad     = iota // we don't know how to talk to it
+	protoLegacy         // old _MOZILLA_COMMAND protocol; see legacy.go
+	protoNewer          // a different 5.1-like version; try it anyway
+	protoCurrent        // exactly what we expect
+)
+
+// protoVersions is the table of protocol versions we know about.
+// -protocol-version adds to it. Versions that aren't in the table
+// are classified by protoClass.
+var protoVersions = map[string]int{
+	firefoxVersion: protoCurrent,
+}
+
+// supportedProtocols describes the protocol versions we speak, for
+// -version.
+func supportedProtocols() string {
+	var extra []string
+	for v, c := range protoVersions {
+		if v != firefoxVersion && c == protoCurrent {
+			extra = append(extra, v)
+		}
+	}
+	sort.Strings(extra)
+	s := firefoxVersion
+	if len(extra) > 0 {
+		s += " and " + strings.Join(extra, ", ") + " (from -protocol-version)"
+	}
+	return s + " with _MOZILLA_COMMANDLINE, older versions (legacy _MOZILLA_COMMAND), and newer versions if they work"
+}
+
+// protoClass classifies a _MOZILLA_VERSION value. Unknown numeric
+// versions older than 5.1 are taken to be the legacy protocol, and
+// newer ones are assumed to be compatible enough to try (Mozilla's
+// own remote client accepted anything from 5.1 up to 6 as the
+// command line protocol). Anything else we can't talk to.
+func protoClass(ver string) int {
+	if c, ok := protoVersions[ver]; ok {
+		return c
+	}
+	v, e := strconv.ParseFloat(ver, 64)
+	switch {
+	case e != nil || v <= 0:
+		return protoBad
+	case v < 5.1:
+		return protoLegacy
+	default:
+		return protoNewer
+	}
+}
+
+// FIREFOX'S REMOTE CONTROL PROTOCOL
+//
+// The general remote control protocol goes like this:
+//
+// 1. Find a or the Firefox window. It will have WM_STATE and at least
+//    _MOZILLA_VERSION set on it. Make sure you think you understand
+//    the protocol version; we prefer it to be exactly 5.1, but will
+//    take other versions with a warning (see protoClass).
+//
+// 2. Check that _MOZILLA_PROFILE, _MOZILLA_USER, and _MOZILLA_PROGRAM
+//    match so that you are talking to the right instance with the right
+//    profile. If you have found a Firefox window but it is the wrong
+//    profile et al, continue looking (return to step 1).
+//
+// 3. Obtain the remote control lock by being the person to set
+//    _MOZILLA_LOCK on the window. If you can't, wait for the
+//    _MOZILLA_LOCK property to go away and try again.
+//    (In theory the contents should be something that identify you, for
+//    help in debugging. In practice this doesn't matter; who's going to
+//    look?)
+//    The lock is necessary to prevent two different remote control
+//    clients from stomping over each other's efforts to send Firefox
+//    a command and read its reply. I don't think it's needed otherwise,
+//    but Firefox may look for it to be set or changed as a marker of
+//    something. Someday I may find out.
+//
+// 4. Set _MOZILLA_COMMANDLINE to the encoded Firefox command line. See
+//    the comment later on for how this is encoded, because it is crazy.
+//
+// 5. Wait for _MOZILLA_RESPONSE to be set and read it. In theory it is
+//    a SMTP/HTTP style 'Nxx <message>' response, where a '2xx' reply is
+//    success, a '5xx' is failure, a '1xx' means in progress, and there's
+//    some other prefixes too. In practice current versions of Firefox
+//    only ever send 200 or 5xx responses.
+//
+// 6. Release your ownership of _MOZILLA_LOCK by deleting the property.
+//
+// Note that because unlocking requires actively clearing a property,
+// it's possible for a fumbled remote control attempt to leave Firefox
+// in a 'locked' state. For this reason we support not trying to
+// acquire the lock (and we still clear the lock).
+
+// We use the low level X Atom values for locking and the response, so
+// we look them up at the start and remember them (effectively
+// interning them in the server).
+var lockatom, responseatom xproto.Atom
+
+// getAtom returns the atom for aname. For our property names, this
+// normally comes from xgbutil's cache, filled in by getAtoms.
+func getAtom(xc xConn, aname string) xproto.Atom {
+	r, e := xc.atom(aname)
+	if e != nil {
+		fatal("getAtom", "err", e)
+	}
+	return r
+}
+
+// getAtoms interns all of the atoms we'll need, all at once, so this
+// is only one round trip to the X server. It has to be called again
+// if the property names change.
+func getAtoms(xc xConn) {
+	start := time.Now()
+	defer timePhase("atom interning", start)
+	names := []string{"WM_STATE", "STRING"}
+	for _, p := range propNames() {
+		names = append(names, *p)
+	}
+	xc.internAtoms(names)
+	trace(start, "InternAtom x %d", len(names))
+
+	lockatom = getAtom(xc, lockProp)
+	responseatom = getAtom(xc, respProp)
+}
+
+// ClientWindow finds the actual client window underneath what may be
+// a window manager frame. This is an implementation of
+// XmuClientWindow(), based on its documentation; we look through
+// direct children of the window for one with WM_STATE set, and if
+// there isn't one we return the window itself.
+func ClientWindow(xc xConn, win xproto.Window) xproto.Window {
+	tree, err := queryTree(xc, win)
+	if err != nil {
+		// The window probably went away on us.
+		scanError(err)
+		return win
+	}
+	for _, c := range tree.Children {
+		_, e := getProp(xc, c, "WM_STATE")
+		if e == nil {
+			return c
+		}
+	}
+	// whatever, man. we'll just return the original window as the
+	// best we can do.
+	return win
+}
+
+// propChunk is how much of a property we ask for at once, in 32-bit
+// units. Asking for a property all at once can run into X server
+// limits on how large a reply can be, so we read long ones in pieces.
+const propChunk = 16 * 1024
+
+// readProp reads all of the property a on win, in chunks if it's
+// long. If del is set, the X server deletes the property once we've
+// read the end of it, just like with a single GetProperty. (INCR
+// only applies to selections, not to properties on windows, so we
+// don't need to handle it.)
+func readProp(xc xConn, win xproto.Window, a xproto.Atom, del bool) (*xproto.GetPropertyReply, error) {
+	var all *xproto.GetPropertyReply
+	var offset uint32
+	for {
+		r, err := xc.getProperty(win, a, del, offset, propChunk)()
+		if err != nil {
+			return nil, err
+		}
+		if all == nil {
+			all = r
+		} else {
+			all.Value = append(all.Value, r.Value...)
+			all.ValueLen += r.ValueLen
+			all.BytesAfter = r.BytesAfter
+		}
+		if r.BytesAfter == 0 || r.Format == 0 {
+			return all, nil
+		}
+		offset += uint32(len(r.Value)) / 4
+	}
+}
+
+// scanError deals with an error from a request about one of the
+// windows we're scanning. Windows come and go all the time, so it's
+// normal for one to have gone away by the time we ask about it, which
+// gets us a BadWindow error; we just skip that window. Anything else
+// means something is wrong with our X connection, which is fatal.
+func scanError(err error) {
+	if _, ok := err.(xproto.WindowError); ok {
+		return
+	}
+	fatal("window scan", "err", err)
+}
+
+// candidateWindows returns all of the windows that may be Firefox
+// windows. Normally these come from the X window tree, but we may
+// ask i3 or sway instead (see ipc.go).
+func candidateWindows(xc xConn) []xproto.Window {
+	if useIPC {
+		return ipcWindows(xc)
+	}
+	return treeWindows(xc)
+}
+
+// treeWindows returns the client windows of all children of the
+// root window, which nominally will include any Firefox windows.
+func treeWindows(xc xConn) []xproto.Window {
+	tree, err := queryTree(xc, xc.root())
+	if err != nil {
+		fatal("", "err", err)
+	}
+	return clientWindows(xc, tree.Children)
+}
+
+// clientBatch is how many windows clientWindows works on at once.
+// This bounds how many requests we have outstanding to the X server.
+const clientBatch = 256
+
+// clientWindows is ClientWindow for a lot of windows at once. Instead
+// of two round trips to the X server per window, we send the
+// QueryTree requests for a batch of windows all at once, then the
+// WM_STATE requests for all of their children all at once, and then
+// collect the replies.
+func clientWindows(xc xConn, tops []xproto.Window) []xproto.Window {
+	start := time.Now()
+	wmstate := getAtom(xc, "WM_STATE")
+	var wins []xproto.Window
+	for len(tops) > 0 {
+		batch := tops
+		if len(batch) > clientBatch {
+			batch = batch[:clientBatch]
+		}
+		tops = tops[len(batch):]
+
+		var tcookies []func() (*xproto.QueryTreeReply, error)
+		for _, w := range batch {
+			tcookies = append(tcookies, xc.queryTree(w))
+		}
+		children := make([][]xproto.Window, len(batch))
+		pcookies := make([][]func() (*xproto.GetPropertyReply, error), len(batch))
+		for i, c := range tcookies {
+			tree, err := c()
+			if err != nil {
+				// The window probably went away on us.
+				scanError(err)
+				continue
+			}
+			children[i] = tree.Children
+			for _, cw := range tree.Children {
+				pcookies[i] = append(pcookies[i], xc.getProperty(cw, wmstate, false, 0, 0))
+			}
+		}
+		for i, w := range batch {
+			client := w
+			for j, c := range pcookies[i] {
+				p, err := c()
+				if err != nil {
+					scanError(err)
+					continue
+				}
+				if p.Format != 0 && client == w {
+					client = children[i][j]
+				}
+			}
+			wins = append(wins, client)
+		}
+	}
+	trace(start, "QueryTree and WM_STATE for %d windows", len(wins))
+	return wins
+}
+
+// textProp returns the value of the property prop on win as a
+// string, quietly. Unlike getProp, it doesn't trace or log, since
+// it's for properties that aren't part of the protocol (such as
+// WM_CLASS and the window title).
+func textProp(xc xConn, win xproto.Window, prop string) (string, error) {
+	a, err := xc.atom(prop)
+	if err != nil {
+		return "", err
+	}
+	p, err := readProp(xc, win, a, false)
+	if err != nil {
+		return "", err
+	}
+	if p.Format == 0 {
+		return "", fmt.Errorf("no such property '%s' on window %x", prop, win)
+	}
+	return string(p.Value), nil
+}
+
+// propValue returns the value of the string X property prop on win,
+// or "" if it's not set or there's some problem.
+func propValue(xc xConn, win xproto.Window, prop string) string {
+	pv, e := getProp(xc, win, prop)
+	if e != nil {
+		return ""
+	}
+	return string(pv.Value)
+}
+
+// A propVal is the value of a string property on a window, if it's
+// set at all.
+type propVal struct {
+	val string
+	set bool
+}
+
+// foxProps are the identification properties of a possible Firefox
+// window.
+type foxProps struct {
+	ver, user, prof, prog propVal
+}
+
+// fetchFoxProps gets the identification properties of all of the
+// windows. Rather than making a round trip to the X server for each
+// property of each window, we send all of the requests at once and
+// then collect all of the replies, which is much faster when there
+// are a lot of windows.
+func fetchFoxProps(xc xConn, wins []xproto.Window) []foxProps {
+	start := time.Now()
+	atoms := []xproto.Atom{getAtom(xc, versProp), getAtom(xc, userProp), getAtom(xc, profProp), getAtom(xc, progProp)}
+	cookies := make([][]func() (*xproto.GetPropertyReply, error), len(wins))
+	for i, w := range wins {
+		for _, a := range atoms {
+			cookies[i] = append(cookies[i], xc.getProperty(w, a, false, 0, propChunk))
+		}
+	}
+	res := make([]foxProps, len(wins))
+	for i := range wins {
+		fp := []*propVal{&res[i].ver, &res[i].user, &res[i].prof, &res[i].prog}
+		for j, c := range cookies[i] {
+			// A property that isn't set has a Format of 0.
+			r, err := c()
+			if err != nil {
+				scanError(err)
+				continue
+			}
+			if r.BytesAfter > 0 {
+				// A long one; get the rest of it.
+				if r, err = readProp(xc, wins[i], atoms[j], false); err != nil {
+					scanError(err)
+					continue
+				}
+			}
+			if r.Format != 0 {
+				*fp[j] = propVal{string(r.Value), true}
+			}
+		}
+	}
+	trace(start, "GetProperty x %d for %d windows", len(wins)*len(atoms), len(wins))
+	return res
+}
+
+// changedFrom reports how the identification properties of win differ
+// from fp, or "" if they're the same.
+func (fp foxProps) changedFrom(xc xConn, win xproto.Window) string {
+	now := fetchFoxProps(xc, []xproto.Window{win})[0]
+	for _, c := range []struct {
+		prop     string
+		was, now propVal
+	}{{versProp, fp.ver, now.ver}, {userProp, fp.user, now.user},
+		{profProp, fp.prof, now.prof}, {progProp, fp.prog, now.prog}} {
+		if c.was != c.now {
+			return fmt.Sprintf("%s was %q, now %q", c.prop, c.was.val, c.now.val)
+		}
+	}
+	return ""
+}
+
+// propMatch returns true if the property is set and val is empty or
+// the property's value.
+func propMatch(pv propVal, val string) bool {
+	if !pv.set {
+		return false
+	}
+	// unset value matches anything
+	return (val == "" || pv.val == val)
+}
+
+// As of Firefox 131 or so, the 'profile' X property value is actually
+// the full path to the profile. This is also true for the D-Bus
+// version of the protocol. We cope by matching a full path if you
+// gave us one or only the suffix otherwise, so you can continue to
+// use plain profile names.
+func profileMatch(pv propVal, val string) bool {
+	if !pv.set {
+		return false
+	}
+	// unset value matches anything
+	sv := pv.val
+	if val == "" || sv == val {
+		return true
+	}
+	// If the property value starts with a /, we are dealing with
+	// the new Firefox 131 format. If the profile value to match
+	// against doesn't start with a /, assuming it is the old
+	// style name and match it against the '.<name>' at the end of
+	// the full profile path.
+	if sv != "" && sv[0] == '/' && val[0] != '/' &&
+		strings.HasSuffix(sv, "."+val) {
+		return true
+	}
+	if strings.ContainsAny(val, "*?[") {
+		return profileGlob(sv, val)
+	}
+	return false
+}
+
+// profileGlob matches a shell glob pattern against a profile property
+// value, so that you can say -P 'work*' instead of knowing the random
+// 'abcd1234.' that Firefox puts at the start of profile directory
+// names. A pattern with a / in it has to match the whole value;
+// otherwise it can match the profile's directory name or the part
+// of it after the random prefix (or an old style plain name).
+func profileGlob(sv, pat string) bool {
+	if strings.Contains(pat, "/") {
+		ok, _ := path.Match(pat, sv)
+		return ok
+	}
+	base := path.Base(sv)
+	names := []string{base}
+	if i := strings.IndexByte(base, '.'); i >= 0 && strings.HasPrefix(sv, "/") {
+		names = append(names, base[i+1:])
+	}
+	for _, n := range names {
+		if ok, _ := path.Match(pat, n); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// A foxCandidate is a Firefox window that matches what we're looking
+// for, along with how well it matches.
+type foxCandidate struct {
+	win   xproto.Window
+	ver   string
+	class int // from protoClass
+	prog  int // index in the list of programs
+}
+
+// better returns true if c is preferable to o. We prefer windows
+// for programs earlier in the list, and then a window with the exact
+// correct version, then other modern versions, then legacy ones.
+// Otherwise we take the lowest window ID, so that which window we
+// pick doesn't depend on the order that the X server (or the window
+// manager) happens to list them in, which changes as windows are
+// raised and lowered.
+func (c foxCandidate) better(o foxCandidate) bool {
+	switch {
+	case c.prog != o.prog:
+		return c.prog < o.prog
+	case c.class != o.class:
+		return c.class > o.class
+	}
+	return c.win < o.win
+}
+
+// windowMatch checks the things about a possible Firefox window that
+// aren't remote control properties, which are its title, WM_CLASS,
+// and WM_CLIENT_MACHINE (from -title, -class, and -host). Since
+// these take a round trip to the X server each, we only check them
+// for windows that otherwise match.
+func windowMatch(xc xConn, win xproto.Window, o *options) bool {
+	if o.title != nil && !o.title.MatchString(windowTitle(xc, win)) {
+		return false
+	}
+	if o.class != nil {
+		// WM_CLASS is the instance and then the class, each
+		// NUL-terminated.
+		c, err := textProp(xc, win, "WM_CLASS")
+		parts := strings.Split(c, "\x00")
+		if err != nil || len(parts) < 2 || !(o.class.MatchString(parts[0]) || o.class.MatchString(parts[1])) {
+			return false
+		}
+	}
+	if o.host != "" {
+		cm, err := textProp(xc, win, "WM_CLIENT_MACHINE")
+		if err != nil || !hostMatch(cm, o.host) {
+			return false
+		}
+	}
+	return true
+}
+
+// hostMatch reports whether the client machine cm is host, ignoring
+// case. A host without a domain matches any fully qualified cm with
+// that name.
+func hostMatch(cm, host string) bool {
+	if strings.EqualFold(cm, host) {
+		return true
+	}
+	if strings.Contains(host, ".") {
+		return false
+	}
+	i := strings.IndexByte(cm, '.')
+	return i > 0 && strings.EqualFold(cm[:i], host)
+}
+
+// Find all Firefox windows for a specific user, profile, and one of
+// a list of programs (if they are set), and with -title and -class
+// if they're set, in order of preference. The
+// windows must have a protocol version we can talk to (see
+// protoClass). We print a warning if we found no windows but did
+// find what looks like a Firefox window with a _MOZILLA_VERSION we
+// can't talk to at all; this is for debugging in case the version
+// ever does change in an incompatible way.
+//
+// (<jwz>'s old moz-remote.c preferred an exact match but would take
+// any window with a _MOZILLA_VERSION if it had to. This is no longer
+// fully viable and anyways this way is simpler code.)
+func matchingFirefoxes(xc xConn, o *options) []foxCandidate {
+	var wrongver string
+	var cands []foxCandidate
+
+	wins := candidateWindows(xc)
+	for i, fp := range fetchFoxProps(xc, wins) {
+		win := wins[i]
+		if !fp.ver.set {
+			continue
+		}
+		ver := fp.ver.val
+		class := protoClass(ver)
+		if class == protoBad {
+			wrongver = ver
+			continue
+		}
+		if !(propMatch(fp.user, o.user) &&
+			profileMatch(fp.prof, o.profile)) {
+			continue
+		}
+		prog := programIndex(fp.prog, o.programs)
+		if prog < 0 || !windowMatch(xc, win, o) {
+			continue
+		}
+		cands = append(cands, foxCandidate{win, ver, class, prog})
+	}
+	sort.SliceStable(cands, func(i, j int) bool {
+		return cands[i].better(cands[j])
+	})
+
+	// Code flow means we'll print this warning if we found both
+	// a wrong-version window and a right-version window with a
+	// mismatch in protocol et al.
+	if len(cands) == 0 && wrongver != "" {
+		slog.Warn(fmt.Sprintf("found a protocol %s Firefox window but no %s one.", wrongver, firefoxVersion))
+	}
+	return cands
+}
+
+// warnAmbiguous warns if other Firefox instances match as well as the
+// first of cands does, which means that we picked one of them by
+// window ID and you may want to be more specific. Several windows
+// from one Firefox instance are normal and don't count.
+func warnAmbiguous(xc xConn, cands []foxCandidate) {
+	if len(cands) < 2 {
+		return
+	}
+	fps := fetchFoxProps(xc, candWindows(cands))
+	best := foxIdent(fps[0])
+	seen := map[string]bool{best: true}
+	var others []string
+	for i, c := range cands[1:] {
+		if c.prog != cands[0].prog || c.class != cands[0].class {
+			break
+		}
+		id := foxIdent(fps[i+1])
+		if !seen[id] {
+			seen[id] = true
+			others = append(others, fmt.Sprintf("0x%x %s", c.win, id))
+		}
+	}
+	if len(others) > 0 {
+		slog.Warn(fmt.Sprintf("several Firefox instances match; using window 0x%x %s (use -P, -window, or -tui to pick)", cands[0].win, best),
+			"others", strings.Join(others, "; "))
+	}
+}
+
+// foxIdent identifies the Firefox instance that a window belongs to.
+func foxIdent(fp foxProps) string {
+	return fmt.Sprintf("user=%s program=%s profile=%s", fp.user.val, fp.prog.val, fp.prof.val)
+}
+
+// errWindowGone and errTimedOut are why waitForPropChange can fail.
+var (
+	errWindowGone = errors.New("Firefox window disappeared")
+	errTimedOut   = errors.New("timed out waiting for Firefox")
+)
+
+// We read X events straight from the xgb connection, instead of
+// going through xgbutil's event loop machinery, which is a lot of
+// overkill for waiting for one event. Since xgb's WaitForEvent()
+// blocks, we have a goroutine per connection that feeds events into
+// a channel that we can select on. This means that nothing else can
+// read events from a connection that we've waited on, but the things
+// that run xevent's main loop (-serve, -watch, and so on) use their
+// own connections to send command lines.
+var (
+	eventsLock sync.Mutex
+	eventChans = make(map[*xgbutil.XUtil]chan xgb.Event)
+)
+
+// xevents returns the channel of X events for xu, starting the
+// goroutine that reads them if necessary. The channel is closed
+// when the connection is.
+func xevents(xu *xgbutil.XUtil) chan xgb.Event {
+	eventsLock.Lock()
+	defer eventsLock.Unlock()
+	if c, ok := eventChans[xu]; ok {
+		return c
+	}
+	c := make(chan xgb.Event, 16)
+	eventChans[xu] = c
+	eventLog("starting X event reader")
+	go func() {
+		for {
+			ev, err := xu.Conn().WaitForEvent()
+			if err != nil {
+				eventLog("X error", "err", err)
+			}
+			if ev == nil && err == nil {
+				eventLog("X connection closed")
+				eventsLock.Lock()
+				delete(eventChans, xu)
+				eventsLock.Unlock()
+				close(c)
+				return
+			}
+			if ev != nil {
+				c <- ev
+			}
+		}
+	}()
+	return c
+}
+
+// waitForPropChange waits for the X property patom on window win to
+// change or disappear (ie, a PropertyNotify event for it), for up to
+// timeout if it's not zero. It returns the event if this happened,
+// or errWindowGone if the window was deleted instead (or our X
+// connection went away) and errTimedOut if we ran out of time. If
+// ctx is done first, we return an error wrapping ctx.Err().
+func waitForPropChange(ctx context.Context, xc xConn, win xproto.Window, patom xproto.Atom, timeout time.Duration) (xproto.PropertyNotifyEvent, error) {
+	start := time.Now()
+	var tmo <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		tmo = t.C
+	}
+	events := xc.events()
+	if logEnabled(levelEvent) {
+		eventLog("waiting for a property change", "window", hexWin(win), "prop", atomName(xc, patom), "timeout", timeout)
+	}
+	for {
+		select {
+		case ev, ok := <-events:
+			if ok && logEnabled(levelEvent) {
+				eventLog("X event", "event", ev.String())
+			}
+			if !ok {
+				trace(start, "wait for %s on 0x%x: X connection closed", atomName(xc, patom), win)
+				return xproto.PropertyNotifyEvent{}, errWindowGone
+			}
+			switch e := ev.(type) {
+			case xproto.PropertyNotifyEvent:
+				if e.Window == win && e.Atom == patom {
+					trace(start, "wait for %s on 0x%x: %s", atomName(xc, patom), win, propState(e.State))
+					return e, nil
+				}
+			case xproto.DestroyNotifyEvent:
+				if e.Window == win {
+					trace(start, "wait for %s on 0x%x: window destroyed", atomName(xc, patom), win)
+					return xproto.PropertyNotifyEvent{}, errWindowGone
+				}
+			}
+		case <-tmo:
+			trace(start, "wait for %s on 0x%x: timed out", atomName(xc, patom), win)
+			return xproto.PropertyNotifyEvent{}, errTimedOut
+		case <-ctx.Done():
+			trace(start, "wait for %s on 0x%x: given up", atomName(xc, patom), win)
+			return xproto.PropertyNotifyEvent{}, fmt.Errorf("gave up waiting for Firefox: %w", ctx.Err())
+		}
+	}
+}
+
+// lockValue is what we set lockProp to when we take the lock. This is
+// the same 'pid@host' form that Firefox itself uses.
+var lockValue = func() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("ffox-remote %d@%s", os.Getpid(), host)
+}()
+
+// holdingLock returns true if we still hold the lock on win. This
+// has to be called with the server grabbed to mean much.
+func holdingLock(xc xConn, win xproto.Window) bool {
+	return propValue(xc, win, lockProp) == lockValue
+}
+
+// tryLock makes one attempt to obtain the magic Firefox lock property.
+// The protocol is that lockProp normally does not exist and you take
+// the lock by setting it. This must be done with the X server grabbed
+// so that no one else can do that at the same time.
+//
+// If we fail, our caller is going to wait for the lock to be
+// released, so we must be listening for PropertyNotify events before
+// the lock holder can possibly release it. We make sure of this by
+// starting to listen inside the grab, before we look at the lock;
+// anything that happens to the lock afterward generates an event
+// that xgb will queue for us. (Doing this every time is harmless,
+// since selecting the same events again doesn't change anything.)
+func tryLock(xc xConn, win xproto.Window) (bool, error) {
+	success := false
+	start := time.Now()
+	xc.grab()
+	trace(start, "GrabServer")
+	lstart := time.Now()
+	e := xc.listen(win)
+	trace(lstart, "ChangeWindowAttributes 0x%x: listen for property changes", win)
+	if e != nil {
+		xc.ungrab()
+		return false, fmt.Errorf("listen error: %s", e)
+	}
+	p, e := getProp(xc, win, lockProp)
+	if e != nil || len(p.Value) == 0 {
+		// We set a value that's unique to us, so that we can
+		// tell later if someone else has taken the lock away
+		// from us (see submitCommand). As a side benefit,
+		// -dump and monitor can say who has the lock.
+		e = changeProp(xc, win, lockProp, []byte(lockValue))
+		success = (e == nil)
+	}
+	switch {
+	case success:
+		protoLog("took the lock", "window", hexWin(win))
+	case p != nil:
+		protoLog("the lock is held", "window", hexWin(win), "by", string(p.Value))
+	}
+	start = time.Now()
+	xc.ungrab()
+	xc.sync()
+	trace(start, "UngrabServer")
+	return success, nil
+}
+
+// lockFirefox obtains the remote command invocation lock on the Firefox
+// window, waiting up to timeout for it (forever if timeout is 0). If
+// someone else has held the lock that long, they've probably crashed
+// or gotten stuck; if steal is set we take the lock over (as if we'd
+// been given -force), and otherwise we fail, reporting who has it.
+// We also stop waiting if ctx is done. If we return an error, we
+// don't hold the lock.
+func lockFirefox(ctx context.Context, xc xConn, win xproto.Window, timeout time.Duration, steal bool) error {
+	start := time.Now()
+	defer timePhase("lock acquisition", start)
+	for {
+		res, err := tryLock(xc, win)
+		if err != nil {
+			return err
+		}
+		if res {
+			return nil
+		}
+		// Someone else has the property active. Wait for a
+		// property change on it.
+		var left time.Duration
+		if timeout > 0 {
+			left = timeout - time.Since(start)
+			if left <= 0 {
+				left = time.Nanosecond
+			}
+		}
+		_, err = waitForPropChange(ctx, xc, win, lockatom, left)
+		if err == errTimedOut {
+			holder := propValue(xc, win, lockProp)
+			if !steal {
+				return lockedError{holder, timeout}
+			}
+			slog.Warn(fmt.Sprintf("taking over the lock from %q after %v", holder, timeout))
+			if e := changeProp(xc, win, lockProp, []byte(lockValue)); e != nil {
+				return fmt.Errorf("taking over the lock: %s", e)
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// We don't bother checking the event state for
+		// PropertyDelete, because we don't care. If the
+		// property just changed value, we'll find out
+		// when we fail to get the lock.
+	}
+}
+
+// A lockedError is what lockFirefox returns if someone else held the
+// lock for too long.
+type lockedError struct {
+	holder  string
+	timeout time.Duration
+}
+
+func (e lockedError) Error() string {
+	return fmt.Sprintf("Firefox has been locked by %q for over %v (use -force or -steal-lock to go on anyway)", e.holder, e.timeout)
+}
+
+// unlockFirefox unconditionally releases the remote command invocation
+// lock on the Firefox window. We are assumed to own it since we have
+// no simple choice.
+func unlockFirefox(xc xConn, win xproto.Window) {
+	// xproto does not expose the synchronous delete property of
+	// XGetWindowProperty(), so we assume that we are the owner
+	// and our ownership has not been overwritten.
+	start := time.Now()
+	xc.deleteProperty(win, lockatom)
+	trace(start, "DeleteProperty 0x%x %s", win, lockProp)
+	protoLog("released the lock", "window", hexWin(win))
+}
+
+// progressTimeout is how long we wait for a final response after
+// Firefox has given us a 1xx 'in progress' one.
+const progressTimeout = 30 * time.Second
+
+// getResponse gets the response to our Firefox remote command, which
+// appears in the value of respProp. We return an error if the window
+// went away and no response if there is some other problem.
+// A response starting with '1' is a 'things are in progress' response,
+// and the real response comes later. Modern versions of Firefox never
+// emit these, but the protocol allows them, so we wait (for a while)
+// for the final response. If it doesn't come, we return the last 1xx
+// response. If ctx is done before we get a response, we fail.
+func getResponse(ctx context.Context, xc xConn, win xproto.Window) (response, error) {
+	defer timePhase("response wait", time.Now())
+	var timeout time.Duration
+	var resp response
+	for {
+		event, err := waitForPropChange(ctx, xc, win, responseatom, timeout)
+		if err == errTimedOut {
+			return resp, nil
+		}
+		if err != nil {
+			return response{}, err
+		}
+		if event.State != xproto.PropertyNewValue {
+			// Someone deleted it; this isn't something
+			// that a 1xx response leads us to expect.
+			return resp, nil
+		}
+		p, r := getProp(xc, win, respProp)
+		if r != nil {
+			return resp, nil
+		}
+		resp = parseResponse(string(p.Value))
+		if resp.class() != respInProgress {
+			return resp, nil
+		}
+		timeout = progressTimeout
+	}
+}
+
+// submitCommand sends our command to the remote Firefox window and
+// waits for its response, returning the response.
+// We are given the property to set (cmdlProp normally, cmdProp for
+// the legacy protocol) and the already-encoded property value.
+// Process: obtain lock, set the property to the value, wait for the
+// response property to be set (or the window to poof), unlock Firefox.
+// Every failure after we have the lock releases it before we return
+// the error, so that we never leave Firefox locked.
+// If we're not waiting, we skip waiting for the response and return
+// no response right away. The options tell us about -force and how long to
+// wait for the lock. If ident isn't nil, it's what the window's
+// identification properties were when we found it; if they've
+// changed by the time we have the lock, the window has been reused
+// or Firefox has restarted, and we give up rather than send our
+// command line to the wrong thing. If ctx is done while we're
+// waiting for the lock or the response, we give up (releasing the
+// lock if we have it).
+func submitCommand(ctx context.Context, xc xConn, win xproto.Window, prop string, cmd []byte, o *options, wait bool, ident *foxProps) (response, error) {
+	// We must be listening to PropertyNotify events on the target
+	// window before we try to lock it, because otherwise there is
+	// a race between our lock attempt failing, the lock holder
+	// removing the property, and us starting to listen that could
+	// leave us hanging with the property unlocked. tryLock takes
+	// care of this, but with -force we never call it, and we still
+	// need to hear about the response.
+	var e error
+	if o.force {
+		start := time.Now()
+		e = xc.listen(win)
+		trace(start, "ChangeWindowAttributes 0x%x: listen for property changes", win)
+		if e != nil {
+			return response{}, fmt.Errorf("listen error: %s", e)
+		}
+	}
+
+	// If we're forced, we don't try to lock Firefox but we will unlock
+	// it. As a side effect this will unstick a Firefox that has been
+	// locked and never unlocked.
+	if !o.force {
+		if e := lockFirefox(ctx, xc, win, o.lockWait, o.stealLock); e != nil {
+			return response{}, e
+		}
+	}
+
+	// Another client may have taken the lock away from us (for
+	// example with -steal-lock), in which case setting the command
+	// line now would interleave our command with theirs. So we
+	// check that we still hold the lock and set the command line
+	// with the server grabbed, and if we've lost the lock we wait
+	// to get it back.
+	for {
+		start := time.Now()
+		xc.grab()
+		held := o.force || holdingLock(xc, win)
+		changed := ""
+		if held && ident != nil {
+			changed = ident.changedFrom(xc, win)
+		}
+		if held && changed == "" {
+			e = changeProp(xc, win, prop, cmd)
+		}
+		xc.ungrab()
+		xc.sync()
+		timePhase("command submission", start)
+		if changed != "" {
+			unlockFirefox(xc, win)
+			xc.sync()
+			return response{}, fmt.Errorf("Firefox window 0x%x changed while we were waiting for it: %s", win, changed)
+		}
+		if held {
+			break
+		}
+		slog.Warn(fmt.Sprintf("someone took the Firefox lock (now %q) away from us; waiting to get it back", propValue(xc, win, lockProp)))
+		if e := lockFirefox(ctx, xc, win, o.lockWait, o.stealLock); e != nil {
+			return response{}, e
+		}
+	}
+	if e != nil {
+		unlockFirefox(xc, win)
+		xc.sync()
+		return response{}, fmt.Errorf("command line change: %s", e)
+	}
+
+	// Firefox doesn't care about the lock when it reads the
+	// command line, so we can drop it before it gets there.
+	if !wait {
+		unlockFirefox(xc, win)
+		xc.sync()
+		return response{}, nil
+	}
+
+	resp, e := getResponse(ctx, xc, win)
+	unlockFirefox(xc, win)
+	xc.sync()
+	return resp, e
+}
+
+// Rewrite all of our property names to have a different prefix.
+// The rest of the code just uses the property name variables, so
+// switching prefixes is only a matter of doing this.
+func fixupPref(pfix string, elems ...*string) {
+	plen := len("_MOZILLA")
+	for _, e := range elems {
+		us := *e
+		ns := fmt.Sprintf("%s%s", pfix, us[plen:])
+		*e = ns
+	}
+}
+
+// setPrefix switches all of our property names to use pfix, or the
+// standard _MOZILLA prefix if pfix is "". You need to call getAtoms
+// again afterward.
+func setPrefix(pfix string) {
+	for i, p := range propNames() {
+		*p = stockProps[i]
+	}
+	if pfix != "" {
+		fixupPref(pfix, propNames()...)
+	}
+}
+
+// programIndex returns the index in programs of the first program
+// name that the window's program property matches, or -1 if it
+// matches none of them.
+func programIndex(pv propVal, programs []string) int {
+	for i, p := range programs {
+		if propMatch(pv, p) {
+			return i
+		}
+	}
+	return -1
+}
+
+// xTransport talks to Firefox through X properties. If we're
+// scanning displays, displays is all of the displays to look at and
+// display is the one we found Firefox on.
+type xTransport struct {
+	o        *options
+	xu       *xgbutil.XUtil
+	win      xproto.Window
+	ver      string
+	displays []string
+	display  string
+	pfix     string
+	ident    *foxProps
+	owned    xproto.Window // the window checkOwned has approved
+}
+
+// transportMakers are the transports we have on Unix; see
+// transports.go.
+var transportMakers = map[string]transportMaker{
+	"x": newXTransport,
+	"dbus": func(o *options) (transport, error) {
+		// Don't turn a nil *dbusTransport into a non-nil
+		// transport.
+		dt, err := newDBusTransport(o)
+		if err != nil {
+			return nil, err
+		}
+		return dt, nil
+	},
+}
+
+// defaultTransport is the transport we use unless told otherwise.
+const defaultTransport = "x"
+
+// newXTransport connects to the X server and sets up to talk to
+// Firefox through it.
+func newXTransport(o *options) (transport, error) {
+	if o.protover != "" {
+		protoVersions[o.protover] = protoCurrent
+	}
+
+	// We start out with the first prefix; find() tries the rest.
+	if len(o.pfixes) > 0 {
+		setPrefix(o.pfixes[0])
+	}
+
+	if o.trace != "" {
+		if err := startTrace(o.trace); err != nil {
+			return nil, err
+		}
+	}
+
+	useIPC = o.ipc
+
+	// xgb only knows how to get the authority file name from the
+	// environment.
+	if o.xauthority != "" {
+		os.Setenv("XAUTHORITY", o.xauthority)
+	}
+
+	// If we're scanning displays, we connect to them later, one by
+	// one.
+	if o.scanDisplays {
+		t := &xTransport{o: o, displays: localDisplays()}
+		if len(t.displays) == 0 {
+			return nil, errors.New("no local X displays found")
+		}
+		return t, nil
+	}
+
+	xu, err := xconnect(o.display)
+	if err != nil {
+		return nil, err
+	}
+	return &xTransport{o: o, xu: xu}, nil
+}
+
+// displayName returns the X display that we found Firefox on.
+func (t *xTransport) displayName() string {
+	if t.display != "" {
+		return t.display
+	}
+	return t.o.display
+}
+
+// xconnect connects to an X display and sets up our atoms for it.
+func xconnect(display string) (*xgbutil.XUtil, error) {
+	start := time.Now()
+	xu, err := xgbutil.NewConnDisplay(display)
+	trace(start, "connect to %q: %v", display, err)
+	timePhase("X connect", start)
+	if err != nil {
+		return nil, fmt.Errorf("X connection: %s", err)
+	}
+	getAtoms(liveX{xu})
+	return xu, nil
+}
+
+func (t *xTransport) list() {
+	if t.displays == nil {
+		t.listOn(t.xu, "")
+		if t.o.listFormat == "" {
+			listRunning(t.xu)
+		}
+		return
+	}
+	for _, d := range t.displays {
+		xu, err := xconnect(d)
+		if err != nil {
+			if t.o.verbose {
+				slog.Warn("", "err", err)
+			}
+			continue
+		}
+		t.listOn(xu, d)
+		xu.Conn().Close()
+	}
+}
+
+// prefixes returns the property prefixes to try, in order. "" is the
+// standard one.
+func (t *xTransport) prefixes() []string {
+	if len(t.o.pfixes) == 0 {
+		return []string{""}
+	}
+	return t.o.pfixes
+}
+
+// listOn lists the Firefox windows on a display (which is "" unless
+// we're scanning displays) under all of our property prefixes. If
+// there's more than one prefix, we say which one each window was
+// found under.
+func (t *xTransport) listOn(xu *xgbutil.XUtil, display string) {
+	pfixes := t.prefixes()
+	for _, p := range pfixes {
+		setPrefix(p)
+		pfix := strings.TrimSuffix(versProp, "_VERSION")
+		l := ""
+		if display != "" {
+			l = display + " "
+		}
+		if len(pfixes) > 1 {
+			l += pfix + " "
+		}
+		fields := map[string]string{"display": display, "prefix": pfix}
+		listFirefox(xu, l, t.o.listFormat, fields)
+	}
+}
+
+// find locates the command window (or a command window) for the
+// running Firefox. When scanning displays, we take the first display
+// that has a matching Firefox. Finding is quick, so we only check
+// ctx between displays.
+func (t *xTransport) find(ctx context.Context) error {
+	if t.o.window != 0 {
+		return t.findWindow(xproto.Window(t.o.window))
+	}
+	if t.displays == nil {
+		t.win, t.ver = t.findOn(t.xu)
+	}
+	for _, d := range t.displays {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		xu, err := xconnect(d)
+		if err != nil {
+			if t.o.verbose {
+				slog.Warn("", "err", err)
+			}
+			continue
+		}
+		t.win, t.ver = t.findOn(xu)
+		if t.win != 0 {
+			t.xu, t.display = xu, d
+			break
+		}
+		xu.Conn().Close()
+	}
+	if t.win == 0 {
+		return errors.New("can't find a running Firefox window" + runningHint())
+	}
+	ident := fetchFoxProps(liveX{t.xu}, []xproto.Window{t.win})[0]
+	t.ident = &ident
+	if protoClass(t.ver) == protoNewer {
+		slog.Warn(fmt.Sprintf("Firefox window has protocol version %s, not %s; trying anyway.", t.ver, firefoxVersion))
+	}
+	return nil
+}
+
+// findWindow uses win, from -window, if it's a Firefox window under
+// one of our property prefixes. We don't check its profile and so on,
+// since you picked it.
+func (t *xTransport) findWindow(win xproto.Window) error {
+	xc := liveX{t.xu}
+	for _, p := range t.prefixes() {
+		setPrefix(p)
+		getAtoms(xc)
+		fp := fetchFoxProps(xc, []xproto.Window{win})[0]
+		if !fp.ver.set {
+			continue
+		}
+		if protoClass(fp.ver.val) == protoBad {
+			return fmt.Errorf("window 0x%x has protocol version %s, which we can't talk to", win, fp.ver.val)
+		}
+		t.win, t.ver, t.pfix = win, fp.ver.val, p
+		t.ident = &fp
+		return nil
+	}
+	return fmt.Errorf("window 0x%x isn't a Firefox window", win)
+}
+
+// findOn finds the Firefox window to use on a particular display,
+// trying each of our property prefixes in order. We stop at the
+// first prefix that has a Firefox window, leaving our property names
+// (and atoms) set for it.
+func (t *xTransport) findOn(xu *xgbutil.XUtil) (xproto.Window, string) {
+	for _, p := range t.prefixes() {
+		setPrefix(p)
+		getAtoms(liveX{xu})
+		if win, ver := t.findWith(xu); win != 0 {
+			t.pfix = p
+			return win, ver
+		}
+	}
+	return 0, ""
+}
+
+// findWith finds the Firefox window to use on a particular display
+// with the current property prefix. Normally this is the best one,
+// but we may prefer one on a particular monitor (see monitor.go) or
+// ask which one to use (see tui.go). If nothing matches our profile,
+// we try the alternate one (see options.profileAlt).
+func (t *xTransport) findWith(xu *xgbutil.XUtil) (xproto.Window, string) {
+	xc := liveX{xu}
+	defer timePhase("window scan", time.Now())
+	cands := matchingFirefoxes(xc, t.o)
+	if len(cands) == 0 && t.o.profileAlt != "" {
+		alt := *t.o
+		alt.profile = alt.profileAlt
+		cands = matchingFirefoxes(xc, &alt)
+	}
+	if len(cands) == 0 {
+		return 0, ""
+	}
+	if wantMenu(xc, t.o.tui, cands) {
+		c, err := pickInteractively(xc, cands)
+		if err != nil {
+			fatal("", "err", err)
+		}
+		return c.win, c.ver
+	}
+	if t.o.monitor == "" {
+		warnAmbiguous(xc, cands)
+		return cands[0].win, cands[0].ver
+	}
+	c := pickByMonitor(xu, cands, t.o.monitor, t.o.verbose)
+	return c.win, c.ver
+}
+
+func (t *xTransport) describe() {
+	if t.display != "" {
+		fmt.Printf("firefox display: %s\n", t.display)
+	}
+	fmt.Printf("firefox window: 0x%x\n", t.win)
+	if len(t.o.pfixes) > 1 {
+		fmt.Printf("firefox property prefix: %s\n", strings.TrimSuffix(versProp, "_VERSION"))
+	}
+	fmt.Printf("firefox program: %s\n", propValue(liveX{t.xu}, t.win, progProp))
+	if pk := packaging(t.xu, t.win); pk != "" {
+		fmt.Printf("firefox packaging: %s\n", pk)
+	}
+	if protoClass(t.ver) == protoLegacy {
+		fmt.Printf("legacy protocol version: %s\n", t.ver)
+	}
+}
+
+// details reports everything we know about the window we found, for
+// -find-format.
+func (t *xTransport) details() []foundField {
+	xu, win := t.xu, t.win
+	xc := liveX{xu}
+	fields := []foundField{{"window", fmt.Sprintf("0x%x", win)}}
+	add := func(k, v string) {
+		if v != "" {
+			fields = append(fields, foundField{k, v})
+		}
+	}
+	add("display", t.displayName())
+	add("user", propValue(xc, win, userProp))
+	prof := propValue(xc, win, profProp)
+	add("profile", prof)
+	if prof != "" {
+		add("profile_path", profileDir(prof))
+	}
+	add("program", propValue(xc, win, progProp))
+	if cm, err := icccm.WmClientMachineGet(xu, win); err == nil {
+		add("host", cm)
+	}
+	add("protocol", t.ver)
+	add("packaging", packaging(xu, win))
+	if pid, err := ewmh.WmPidGet(xu, win); err == nil {
+		add("pid", strconv.FormatUint(uint64(pid), 10))
+	}
+	add("title", windowTitle(xc, win))
+	if g, err := xwindow.New(xu, win).DecorGeometry(); err == nil {
+		add("geometry", fmt.Sprintf("%dx%d+%d+%d", g.Width(), g.Height(), g.X(), g.Y()))
+	}
+	if d, err := ewmh.WmDesktopGet(xu, win); err == nil {
+		add("desktop", strconv.FormatUint(uint64(d), 10))
+	}
+	return fields
+}
+
+// instances returns a transport for each Firefox instance that
+// matches, best first, using the first window we see from each. It
+// looks with whatever property prefix (and on whatever display) find
+// settled on. With -window, the only instance is that window's.
+func (t *xTransport) instances() []transport {
+	xc := liveX{t.xu}
+	if t.o.window != 0 {
+		return []transport{t}
+	}
+	cands := matchingFirefoxes(xc, t.o)
+	if len(cands) == 0 && t.o.profileAlt != "" {
+		alt := *t.o
+		alt.profile = alt.profileAlt
+		cands = matchingFirefoxes(xc, &alt)
+	}
+	var ts []transport
+	seen := make(map[string]bool)
+	for i, fp := range fetchFoxProps(xc, candWindows(cands)) {
+		id := foxIdent(fp)
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		nt := *t
+		nt.win, nt.ver, nt.owned = cands[i].win, cands[i].ver, 0
+		fp := fp
+		nt.ident = &fp
+		ts = append(ts, &nt)
+	}
+	return ts
+}
+
+// target describes the window we found, for records.
+func (t *xTransport) target() string {
+	d := t.displayName()
+	if d == "" {
+		d = os.Getenv("DISPLAY")
+	}
+	return fmt.Sprintf("window 0x%x on %s", t.win, d)
+}
+
+// foundProfileDir returns the profile directory of the Firefox we
+// found, or "" if we can't tell.
+func (t *xTransport) foundProfileDir() string {
+	if t.ident == nil {
+		return ""
+	}
+	return profileDir(t.ident.prof.val)
+}
+
+// protocolVersion reports the _MOZILLA_VERSION of the window we
+// found and what we make of it.
+func (t *xTransport) protocolVersion() string {
+	switch protoClass(t.ver) {
+	case protoCurrent:
+		return t.ver
+	case protoNewer:
+		return t.ver + " (newer than we know; we'll try it)"
+	case protoLegacy:
+		return t.ver + " (legacy _MOZILLA_COMMAND protocol)"
+	default:
+		return fmt.Sprintf("%q (not one we can talk to)", t.ver)
+	}
+}
+
+// encode returns the property values that send would set to send
+// args, in order. Old browsers get the old protocol, one command per
+// URL; otherwise a command line that's too big for one property is
+// split into several.
+func (t *xTransport) encode(cwd string, args []string) ([]wireMsg, error) {
+	var msgs []wireMsg
+	if t.o.legacy || protoClass(t.ver) == protoLegacy {
+		cmds, e := legacyCommands(args[1:])
+		if e != nil {
+			return nil, e
+		}
+		for _, c := range cmds {
+			msgs = append(msgs, wireMsg{where: cmdProp, data: []byte(c)})
+		}
+		return msgs, nil
+	}
+
+	enc := cmdline.Encode(cwd, args)
+	if len(enc) <= cmdline.MaxLen {
+		return []wireMsg{{where: cmdlProp, data: enc, cmdline: true}}, nil
+	}
+	cls, e := splitCommandLine(cwd, args, cmdline.MaxLen)
+	if e != nil {
+		return nil, e
+	}
+	for _, cl := range cls {
+		msgs = append(msgs, wireMsg{where: cmdlProp, data: cmdline.Encode(cwd, cl), cmdline: true})
+	}
+	return msgs, nil
+}
+
+func (t *xTransport) send(ctx context.Context, cwd string, args []string) (response, error) {
+	if e := t.checkOwned(); e != nil {
+		return response{}, e
+	}
+	msgs, e := t.encode(cwd, args)
+	if e != nil {
+		return response{}, e
+	}
+	return sendWire(ctx, liveX{t.xu}, t.win, msgs, t.o, t.ident)
+}
+
+// sendWire submits msgs to win one after another, stopping if Firefox
+// rejects a command line, and returns the last response.
+func sendWire(ctx context.Context, xc xConn, win xproto.Window, msgs []wireMsg, o *options, ident *foxProps) (response, error) {
+	var resp response
+	var e error
+	for i, m := range msgs {
+		if i > 0 && o.verbose {
+			fmt.Printf("response: %s\n", resp)
+		}
+		// We always wait for legacy commands and for all but
+		// the last piece of a split command line, since we're
+		// going to send another one; if we didn't, we could
+		// overwrite the property before Firefox has read it.
+		wait := !m.cmdline || !o.noWait || i < len(msgs)-1
+		resp, e = submitCommand(ctx, xc, win, m.where, m.data, o, wait, ident)
+		if e != nil {
+			return response{}, e
+		}
+		if m.cmdline && resp.failed() {
+			break
+		}
+	}
+	return resp, nil
+}
@@ -0,0 +1,294 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/xgb/xproto"
+	"github.com/siebenmann/ffox-remote/cmdline"
+)
+
+// received is the command lines that a fake Firefox has gotten.
+type received struct {
+	mu  sync.Mutex
+	cls [][]byte
+}
+
+func (r *received) add(b []byte) {
+	r.mu.Lock()
+	r.cls = append(r.cls, b)
+	r.mu.Unlock()
+}
+
+func (r *received) get() [][]byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([][]byte(nil), r.cls...)
+}
+
+// setupFake returns a fakeX with one Firefox window (for user 'cks',
+// with a modern profile path) that answers command lines with a
+// success response, recording what it got.
+func setupFake(t *testing.T) (*fakeX, xproto.Window, *received) {
+	t.Helper()
+	fx := newFakeX()
+	getAtoms(fx)
+	win := fx.addFirefox("cks", "/home/cks/.mozilla/firefox/abcd1234.default-release", "firefox")
+	got := &received{}
+	fx.answerAs(win, "200 executed command", got.add)
+	return fx, win, got
+}
+
+// testOptions are options for talking to a fake Firefox without
+// waiting long for anything.
+func testOptions() *options {
+	return &options{programs: []string{"firefox"}, lockWait: 2 * time.Second}
+}
+
+// hasProp reports whether prop is set on win.
+func hasProp(fx *fakeX, win xproto.Window, prop string) bool {
+	a, _ := fx.atom(prop)
+	r, err := fx.readProperty(win, a, false, 0, 1)
+	return err == nil && r.Format != 0
+}
+
+func TestMatchingFirefoxes(t *testing.T) {
+	fx := newFakeX()
+	getAtoms(fx)
+	work := fx.addFirefox("cks", "/home/cks/.mozilla/firefox/abcd1234.work", "firefox")
+	old := fx.addFirefox("cks", "default", "firefox")
+	other := fx.addFirefox("fred", "/home/fred/.mozilla/firefox/efgh5678.work", "firefox")
+	fx.addWindow(fx.root())
+
+	for _, c := range []struct {
+		user, profile string
+		want          []xproto.Window
+	}{
+		{"", "", []xproto.Window{work, old, other}},
+		{"cks", "", []xproto.Window{work, old}},
+		{"cks", "work", []xproto.Window{work}},
+		{"", "work", []xproto.Window{work, other}},
+		{"", "/home/cks/.mozilla/firefox/abcd1234.work", []xproto.Window{work}},
+		{"", "default", []xproto.Window{old}},
+		{"", "wo*", []xproto.Window{work, other}},
+		{"", "abcd*", []xproto.Window{work}},
+		{"", "nosuch", nil},
+	} {
+		o := testOptions()
+		o.user, o.profile = c.user, c.profile
+		var wins []xproto.Window
+		for _, cand := range matchingFirefoxes(fx, o) {
+			wins = append(wins, cand.win)
+		}
+		if fmt.Sprint(wins) != fmt.Sprint(c.want) {
+			t.Errorf("user %q profile %q: got %v, want %v", c.user, c.profile, wins, c.want)
+		}
+	}
+}
+
+func TestSubmitCommand(t *testing.T) {
+	fx, win, got := setupFake(t)
+	cmd := cmdline.Encode("/tmp", []string{"firefox", "https://example.org/"})
+	resp, err := submitCommand(context.Background(), fx, win, cmdlProp, cmd, testOptions(), true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.code != 200 {
+		t.Errorf("response %q, want a 200", resp.raw)
+	}
+	if len(got.get()) != 1 || string(got.get()[0]) != string(cmd) {
+		t.Errorf("Firefox got %q, want %q", got.get(), cmd)
+	}
+	if hasProp(fx, win, lockProp) {
+		t.Errorf("the lock is still held")
+	}
+}
+
+// Another client has the lock and releases it after a while; we
+// should wait for it and then send our command line, not before.
+func TestLockContention(t *testing.T) {
+	fx, win, got := setupFake(t)
+	fx.setProp(win, lockProp, "other 1234@host")
+	released := make(chan struct{})
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		if len(got.get()) != 0 {
+			t.Errorf("command line sent while someone else had the lock")
+		}
+		close(released)
+		fx.deleteProperty(win, lockatom)
+	}()
+	cmd := cmdline.Encode("/tmp", []string{"firefox", "https://example.org/"})
+	resp, err := submitCommand(context.Background(), fx, win, cmdlProp, cmd, testOptions(), true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-released:
+	default:
+		t.Fatalf("submitCommand didn't wait for the lock")
+	}
+	if resp.code != 200 || len(got.get()) != 1 {
+		t.Errorf("response %q, Firefox got %d command lines", resp.raw, len(got.get()))
+	}
+	if hasProp(fx, win, lockProp) {
+		t.Errorf("the lock is still held")
+	}
+}
+
+// A client that dies holding the lock leaves it behind, since it's a
+// property on Firefox's window. We give up after -lock-wait, leaving
+// the lock alone, unless we've been told to steal it.
+func TestDeadLockHolder(t *testing.T) {
+	fx, win, got := setupFake(t)
+	holder := "ffox-remote 99999@host"
+	fx.setProp(win, lockProp, holder)
+	cmd := cmdline.Encode("/tmp", []string{"firefox", "https://example.org/"})
+
+	o := testOptions()
+	o.lockWait = 50 * time.Millisecond
+	_, err := submitCommand(context.Background(), fx, win, cmdlProp, cmd, o, true, nil)
+	var le lockedError
+	if !errors.As(err, &le) || le.holder != holder {
+		t.Fatalf("got error %v, want a lockedError for %q", err, holder)
+	}
+	if len(got.get()) != 0 {
+		t.Errorf("command line sent without the lock")
+	}
+	if propValue(fx, win, lockProp) != holder {
+		t.Errorf("the lock was changed to %q", propValue(fx, win, lockProp))
+	}
+
+	o.stealLock = true
+	resp, err := submitCommand(context.Background(), fx, win, cmdlProp, cmd, o, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.code != 200 || len(got.get()) != 1 {
+		t.Errorf("response %q, Firefox got %d command lines", resp.raw, len(got.get()))
+	}
+	if hasProp(fx, win, lockProp) {
+		t.Errorf("the lock is still held")
+	}
+}
+
+// If the window's identification changes while we wait for the lock
+// (Firefox restarted with another profile and the window ID was
+// reused, say), we mustn't send our command line to it.
+func TestIdentChanged(t *testing.T) {
+	fx, win, got := setupFake(t)
+	ident := fetchFoxProps(fx, []xproto.Window{win})[0]
+	fx.setProp(win, lockProp, "other 1234@host")
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		fx.setProp(win, profProp, "/home/cks/.mozilla/firefox/efgh5678.other")
+		fx.deleteProperty(win, lockatom)
+	}()
+	cmd := cmdline.Encode("/tmp", []string{"firefox", "https://example.org/"})
+	_, err := submitCommand(context.Background(), fx, win, cmdlProp, cmd, testOptions(), true, &ident)
+	if err == nil || !strings.Contains(err.Error(), "changed") {
+		t.Fatalf("got error %v, want one about the window changing", err)
+	}
+	if len(got.get()) != 0 {
+		t.Errorf("command line sent to a changed window")
+	}
+	if hasProp(fx, win, lockProp) {
+		t.Errorf("the lock is still held")
+	}
+}
+
+// If Firefox goes away while we wait for its response, we say so
+// instead of waiting forever.
+func TestWindowGone(t *testing.T) {
+	fx, win, _ := setupFake(t)
+	fx.onChange = func(w xproto.Window, prop string) {
+		if w == win && prop == cmdlProp {
+			go fx.destroyWindow(win)
+		}
+	}
+	cmd := cmdline.Encode("/tmp", []string{"firefox", "https://example.org/"})
+	_, err := submitCommand(context.Background(), fx, win, cmdlProp, cmd, testOptions(), true, nil)
+	if !errors.Is(err, errWindowGone) {
+		t.Fatalf("got error %v, want %v", err, errWindowGone)
+	}
+}
+
+// A command line too big for Firefox goes as several, each of them
+// set in pieces, and every URL has to get there, in order, even with
+// -no-wait.
+func TestSplitCommandLine(t *testing.T) {
+	var urls []string
+	for i := 0; i < 1000; i++ {
+		urls = append(urls, fmt.Sprintf("https://example.org/a/fairly/long/path/to/page/%d", i))
+	}
+	args := append([]string{"firefox", "-new-tab"}, urls...)
+	cls, err := splitCommandLine("/tmp", args, cmdline.MaxLen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cls) < 2 {
+		t.Fatalf("the command line wasn't split")
+	}
+	var msgs []wireMsg
+	for _, cl := range cls {
+		enc := cmdline.Encode("/tmp", cl)
+		if len(enc) > cmdline.MaxLen {
+			t.Fatalf("a piece is %d bytes, over %d", len(enc), cmdline.MaxLen)
+		}
+		if len(enc) <= fakeMaxChange {
+			t.Fatalf("a piece is only %d bytes, so it won't be set in pieces", len(enc))
+		}
+		msgs = append(msgs, wireMsg{where: cmdlProp, data: enc, cmdline: true})
+	}
+
+	for _, noWait := range []bool{false, true} {
+		fx, win, got := setupFake(t)
+		o := testOptions()
+		o.noWait = noWait
+		// Firefox doesn't read the command line right away,
+		// so if we didn't wait for it, we'd overwrite it.
+		answer := fx.onChange
+		fx.onChange = func(w xproto.Window, prop string) {
+			go func() {
+				time.Sleep(5 * time.Millisecond)
+				answer(w, prop)
+			}()
+		}
+		if _, err := sendWire(context.Background(), fx, win, msgs, o, nil); err != nil {
+			t.Fatal(err)
+		}
+		// With -no-wait, the last command line may not have
+		// been answered yet.
+		for i := 0; i < 100 && len(got.get()) < len(msgs); i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if len(got.get()) != len(msgs) {
+			t.Fatalf("-no-wait %v: Firefox got %d command lines, want %d", noWait, len(got.get()), len(msgs))
+		}
+		var seen []string
+		for _, b := range got.get() {
+			pwd, cl, err := cmdline.DecodeStrict(b)
+			if err != nil {
+				t.Fatalf("-no-wait %v: %s", noWait, err)
+			}
+			if pwd != "/tmp" || len(cl) < 3 || cl[1] != "-new-tab" {
+				t.Fatalf("-no-wait %v: odd command line %q %q", noWait, pwd, cl[:2])
+			}
+			seen = append(seen, cl[2:]...)
+		}
+		if strings.Join(seen, " ") != strings.Join(urls, " ") {
+			t.Errorf("-no-wait %v: the URLs didn't all get there in order", noWait)
+		}
+		if hasProp(fx, win, lockProp) {
+			t.Errorf("-no-wait %v: the lock is still held", noWait)
+		}
+	}
+}